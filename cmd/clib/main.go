@@ -15,17 +15,21 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+// shared by all calls, which may come from different threads
+var mkParser = func() *parserlib.FrozenParser {
+	p := parserlib.GetFullMKParser()
+	return p.Freeze()
+}()
+
 //export ParseMarkDownToAstString
 func ParseMarkDownToAstString(markdown string) *C.char {
-	p := parserlib.GetFullMKParser()
-	ast := p.Parse(markdown)
+	ast := mkParser.Parse(markdown)
 	return C.CString(ast.String())
 }
 
 //export ParseMarkDownToAstProto
 func ParseMarkDownToAstProto(markdown string) C.struct_ByteSlice {
-	p := parserlib.GetFullMKParser()
-	ast := p.Parse(markdown)
+	ast := mkParser.Parse(markdown)
 	astProto := xxmkproto.AstToProtoBuf(&ast)
 	buf, err := proto.Marshal(astProto)
 	var slice C.struct_ByteSlice
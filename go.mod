@@ -2,12 +2,16 @@ module github.com/XiaoXuan42/xxmk
 
 go 1.21.5
 
+require (
+	github.com/jessevdk/go-flags v1.5.0
+	github.com/stretchr/testify v1.8.4
+	golang.org/x/text v0.14.0
+	google.golang.org/protobuf v1.31.0
+)
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/jessevdk/go-flags v1.5.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.8.4 // indirect
-	golang.org/x/sys v0.0.0-20210320140829-1e4c9ba3b0c4 // indirect
-	google.golang.org/protobuf v1.31.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
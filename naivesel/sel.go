@@ -3,11 +3,18 @@ package naivesel
 import "reflect"
 
 func _getValList(tp interface{}) []interface{} {
-	var result []interface{}
-	val := reflect.ValueOf(tp).Elem()
+	return _appendValList(nil, reflect.ValueOf(tp).Elem())
+}
+
+// the fields of nested structs are encoded in place, one after another
+func _appendValList(result []interface{}, val reflect.Value) []interface{} {
 	for i := 0; i < val.NumField(); i++ {
-		addr := val.Field(i).Addr().Interface()
-		result = append(result, addr)
+		field := val.Field(i)
+		if field.Kind() == reflect.Struct {
+			result = _appendValList(result, field)
+		} else {
+			result = append(result, field.Addr().Interface())
+		}
 	}
 	return result
 }
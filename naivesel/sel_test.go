@@ -3,6 +3,7 @@ package naivesel
 import (
 	"testing"
 
+	"github.com/XiaoXuan42/xxmk/parserlib"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,3 +22,48 @@ func TestEncDecSimple(t *testing.T) {
 	assert.Equal(t, "link", refLink2.Title)
 	assert.Equal(t, "http", refLink2.Link)
 }
+
+type S2 struct {
+	Name  string
+	Inner struct {
+		Line int
+		S1
+	}
+	Ok bool
+}
+
+func TestEncDecNested(t *testing.T) {
+	s := S2{Name: "a", Ok: true}
+	s.Inner.Line = 3
+	s.Inner.Title = "t"
+	s2 := S2{}
+	Deserialize(&s2, Serialize(&s))
+	assert.Equal(t, s, s2)
+}
+
+// the ranges of the node types round trip with the rest of their fields
+func TestEncDecRanged(t *testing.T) {
+	parser := parserlib.GetFullMKParser()
+	ast := parser.Parse("# *a* b\n\n`c` $d$ [e](http://f.g \"h\") ![i](j.png \"k\")\n\n```go\nk\n```\n\n$$\nl\n$$\n")
+	types := map[string]parserlib.AstNodeType{
+		"Header":    &parserlib.Header{},
+		"Code":      &parserlib.Code{},
+		"Math":      &parserlib.Math{},
+		"Link":      &parserlib.Link{},
+		"Image":     &parserlib.Image{},
+		"CodeBlock": &parserlib.CodeBlock{},
+		"MathBlock": &parserlib.MathBlock{},
+	}
+	found := map[string]bool{}
+	ast.Root.PreVisit(func(node *parserlib.AstNode) {
+		name := parserlib.GetNodeTypeName(node.Type)
+		back, ok := types[name]
+		if !ok {
+			return
+		}
+		found[name] = true
+		Deserialize(back, Serialize(node.Type))
+		assert.Equal(t, node.Type, back)
+	})
+	assert.Equal(t, len(types), len(found))
+}
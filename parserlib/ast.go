@@ -7,6 +7,9 @@ import (
 	"unicode/utf8"
 )
 
+// Pos is a position in the source. Line and Col start at 0, Col counts the runes
+// between the beginning of the line and the position, an invalid UTF-8 byte counts as
+// a rune. Offset is in bytes. LineIndex converts offsets to other kinds of columns.
 type Pos struct {
 	Line   int
 	Col    int
@@ -18,18 +21,32 @@ func (pos Pos) String() string {
 }
 
 func (pos *Pos) Consume(c rune) {
+	pos.consume(c, utf8.RuneLen(c))
+}
+
+// consume a rune of size bytes, an invalid byte is consumed as utf8.RuneError of 1 byte
+func (pos *Pos) consume(c rune, size int) {
 	if c == '\n' {
 		pos.Line = pos.Line + 1
 		pos.Col = 0
 	} else {
 		pos.Col = pos.Col + 1
 	}
-	pos.Offset = pos.Offset + utf8.RuneLen(c)
+	pos.Offset = pos.Offset + size
+}
+
+// bytes of the rune c ranged over at s[i], utf8.RuneError may be an invalid byte
+func _runeSize(s string, i int, c rune) int {
+	if c != utf8.RuneError {
+		return utf8.RuneLen(c)
+	}
+	_, size := utf8.DecodeRuneInString(s[i:])
+	return size
 }
 
 func (pos *Pos) ConsumeStr(s string) {
-	for _, c := range s {
-		pos.Consume(c)
+	for i, c := range s {
+		pos.consume(c, _runeSize(s, i, c))
 	}
 }
 
@@ -38,15 +55,66 @@ func (pos *Pos) ForwardInlineByInt(cnt int) {
 	pos.Offset += cnt
 }
 
+// Back undoes Consume(c) for c in the line of pos, going back over '\n' needs a LineIndex
 func (pos *Pos) Back(c rune) {
-	l := utf8.RuneLen(c)
-	pos.Col -= l
-	pos.Offset -= l
+	if c == '\n' {
+		panic("Can't go back to the previous line")
+	}
+	pos.Col -= 1
+	pos.Offset -= utf8.RuneLen(c)
 	if pos.Col < 0 || pos.Offset < 0 {
 		panic("Invalid position")
 	}
 }
 
+// Range is the part [Start, End) of the source
+type Range struct {
+	Start Pos
+	End   Pos
+}
+
+// range of s[start:end] for s starting at pos
+func _rangeIn(s string, pos Pos, start int, end int) Range {
+	r := Range{Start: pos}
+	r.Start.ConsumeStr(s[:start])
+	r.End = r.Start
+	r.End.ConsumeStr(s[start:end])
+	return r
+}
+
+// range of the n single-byte characters at pos, they are on the line of pos
+func _asciiRange(pos Pos, n int) Range {
+	return Range{Start: pos, End: Pos{Line: pos.Line, Col: pos.Col + n, Offset: pos.Offset + n}}
+}
+
+// range of the n single-byte characters before end, they are on the line of end
+func _asciiRangeBefore(end Pos, n int) Range {
+	return Range{Start: Pos{Line: end.Line, Col: end.Col - n, Offset: end.Offset - n}, End: end}
+}
+
+// SyntaxRange is a part of the syntax of a node, Kind is TokenMarker for delimiters
+// and TokenTrivia for whitespace
+type SyntaxRange struct {
+	Kind uint32
+	Range
+}
+
+// appends the syntax of kind in r unless r is empty
+func _appendSyntax(syntax []SyntaxRange, kind uint32, r Range) []SyntaxRange {
+	if r.Start.Offset < r.End.Offset {
+		syntax = append(syntax, SyntaxRange{Kind: kind, Range: r})
+	}
+	return syntax
+}
+
+func (astnode *AstNode) addSyntax(kind uint32, r Range) {
+	astnode.Syntax = _appendSyntax(astnode.Syntax, kind, r)
+}
+
+func (r Range) Text(s string) string {
+	return s[r.Start.Offset:r.End.Offset]
+}
+
 type AstNode struct {
 	Type  AstNodeType
 	Start Pos
@@ -55,6 +123,12 @@ type AstNode struct {
 	Parent      *AstNode
 	LeftSibling *AstNode
 	Children    []*AstNode
+	// delimiters and whitespace recorded by the parser, see CST
+	Syntax []SyntaxRange
+	// pending inline children, see ParseLazy
+	lazy *lazyInlines
+	// source of the node, see Text
+	src *nodeSource
 }
 
 func (astnode *AstNode) StringLines() ([]string, []int) {
@@ -105,10 +179,6 @@ func (astnode *AstNode) PreVisit(f func(*AstNode)) {
 	}
 }
 
-func (astnode *AstNode) Text(s string) string {
-	return s[astnode.Start.Offset:astnode.End.Offset]
-}
-
 func (astnode *AstNode) _eq(other *AstNode) bool {
 	if !reflect.DeepEqual(astnode.Type, other.Type) {
 		return false
@@ -126,6 +196,11 @@ func (astnode *AstNode) _eq(other *AstNode) bool {
 
 type Ast struct {
 	Root AstNode
+	// source and parser of the document, kept by Parse for Update, the nodes share the source for Text
+	src    string
+	parser *MKParser
+	// offsets of the chunks of the source kept by Parse for Update, see _chunkBoundaries
+	chunks []int
 }
 
 func (ast *Ast) String() string {
@@ -193,6 +268,10 @@ func (it AstIterator) AstItToFstChild() AstIterator {
 
 func (it AstIterator) AstFindRightFirstType(targetId int, until *AstNode) AstIterator {
 	failedRes := AstIterator{}
+	if targetId <= 0 {
+		// unknown type
+		return failedRes
+	}
 	if targetId == GetNodeTypeId(it.Cur.Type) {
 		return it
 	}
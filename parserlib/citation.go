@@ -0,0 +1,418 @@
+package parserlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type BibEntry struct {
+	Key  string
+	Type string
+	// "Family, Given" or a literal name
+	Authors   []string
+	Title     string
+	Year      string
+	Container string
+	Publisher string
+	// every field as it appears in the bibliography file
+	Fields map[string]string
+}
+
+type Bibliography struct {
+	Entries map[string]*BibEntry
+	// keys in the order of the bibliography file
+	Keys []string
+}
+
+func newBibliography() *Bibliography {
+	return &Bibliography{Entries: map[string]*BibEntry{}}
+}
+
+func (bib *Bibliography) add(entry *BibEntry) {
+	if _, ok := bib.Entries[entry.Key]; !ok {
+		bib.Keys = append(bib.Keys, entry.Key)
+	}
+	bib.Entries[entry.Key] = entry
+}
+
+// LoadBibliography reads a .bib or CSL-JSON(.json) file
+func LoadBibliography(path string) (*Bibliography, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".bib", ".bibtex":
+		return ParseBibTeX(string(content))
+	case ".json":
+		return ParseCSLJSON(content)
+	default:
+		return nil, fmt.Errorf("unknown bibliography format: %s", path)
+	}
+}
+
+/* BibTeX */
+var bibAuthorSepRegex = regexp.MustCompile(`\s+and\s+`)
+
+func _cleanBibValue(s string) string {
+	s = strings.NewReplacer("{", "", "}", "").Replace(s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// index of the delimiter closing the group opened at s[0], braces inside are balanced
+func _findBibGroupEnd(s string, right byte) int {
+	depth := 0
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '{':
+			depth += 1
+		case s[i] == '}' && depth > 0:
+			depth -= 1
+		case s[i] == right && depth == 0:
+			return i
+		}
+	}
+	return -1
+}
+
+// field = {value} # "value" # number, ...
+func _parseBibFields(s string) (map[string]string, error) {
+	fields := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t\r\n,")
+		if len(s) == 0 {
+			return fields, nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("bibtex: missing '=' in %q", s)
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+		var value strings.Builder
+		for {
+			s = strings.TrimLeft(s, " \t\r\n")
+			if len(s) == 0 {
+				break
+			}
+			switch s[0] {
+			case '{', '"':
+				right := byte('}')
+				if s[0] == '"' {
+					right = '"'
+				}
+				end := _findBibGroupEnd(s, right)
+				if end < 0 {
+					return nil, fmt.Errorf("bibtex: unclosed value of field %s", name)
+				}
+				value.WriteString(s[1:end])
+				s = s[end+1:]
+			default:
+				end := strings.IndexAny(s, ",# \t\r\n")
+				if end < 0 {
+					end = len(s)
+				}
+				value.WriteString(s[:end])
+				s = s[end:]
+			}
+			s = strings.TrimLeft(s, " \t\r\n")
+			if len(s) == 0 || s[0] != '#' {
+				break
+			}
+			s = s[1:]
+		}
+		fields[name] = _cleanBibValue(value.String())
+	}
+}
+
+func ParseBibTeX(s string) (*Bibliography, error) {
+	bib := newBibliography()
+	for {
+		at := strings.IndexByte(s, '@')
+		if at < 0 {
+			return bib, nil
+		}
+		s = s[at+1:]
+		tpEnd := strings.IndexAny(s, "{(")
+		if tpEnd < 0 {
+			return nil, fmt.Errorf("bibtex: missing body after @%s", s)
+		}
+		tp := strings.ToLower(strings.TrimSpace(s[:tpEnd]))
+		right := byte('}')
+		if s[tpEnd] == '(' {
+			right = ')'
+		}
+		end := _findBibGroupEnd(s[tpEnd:], right)
+		if end < 0 {
+			return nil, fmt.Errorf("bibtex: unclosed entry @%s", tp)
+		}
+		body := s[tpEnd+1 : tpEnd+end]
+		s = s[tpEnd+end+1:]
+		if tp == "comment" || tp == "preamble" || tp == "string" {
+			continue
+		}
+
+		comma := strings.IndexByte(body, ',')
+		if comma < 0 {
+			comma = len(body)
+		}
+		key := strings.TrimSpace(body[:comma])
+		if len(key) == 0 {
+			return nil, fmt.Errorf("bibtex: entry @%s without key", tp)
+		}
+		fields, err := _parseBibFields(body[comma:])
+		if err != nil {
+			return nil, fmt.Errorf("%w (entry %s)", err, key)
+		}
+		entry := &BibEntry{
+			Key:       key,
+			Type:      tp,
+			Title:     fields["title"],
+			Year:      fields["year"],
+			Container: fields["journal"],
+			Publisher: fields["publisher"],
+			Fields:    fields,
+		}
+		if len(entry.Container) == 0 {
+			entry.Container = fields["booktitle"]
+		}
+		if authors := fields["author"]; len(authors) > 0 {
+			entry.Authors = bibAuthorSepRegex.Split(authors, -1)
+		}
+		bib.add(entry)
+	}
+}
+
+/* end BibTeX */
+
+/* CSL-JSON */
+type cslName struct {
+	Family  string `json:"family"`
+	Given   string `json:"given"`
+	Literal string `json:"literal"`
+}
+
+type cslItem struct {
+	Id             interface{} `json:"id"`
+	Type           string      `json:"type"`
+	Title          string      `json:"title"`
+	Author         []cslName   `json:"author"`
+	ContainerTitle string      `json:"container-title"`
+	Publisher      string      `json:"publisher"`
+	Issued         struct {
+		DateParts [][]interface{} `json:"date-parts"`
+		Literal   string          `json:"literal"`
+	} `json:"issued"`
+}
+
+func ParseCSLJSON(content []byte) (*Bibliography, error) {
+	var items []cslItem
+	if err := json.Unmarshal(content, &items); err != nil {
+		return nil, fmt.Errorf("csl-json: %w", err)
+	}
+	bib := newBibliography()
+	for i, item := range items {
+		if item.Id == nil {
+			return nil, fmt.Errorf("csl-json: item %d without id", i)
+		}
+		entry := &BibEntry{
+			Key:       fmt.Sprint(item.Id),
+			Type:      item.Type,
+			Title:     item.Title,
+			Container: item.ContainerTitle,
+			Publisher: item.Publisher,
+			Fields:    map[string]string{},
+		}
+		for _, name := range item.Author {
+			if len(name.Literal) > 0 {
+				entry.Authors = append(entry.Authors, name.Literal)
+			} else if len(name.Given) > 0 {
+				entry.Authors = append(entry.Authors, name.Family+", "+name.Given)
+			} else {
+				entry.Authors = append(entry.Authors, name.Family)
+			}
+		}
+		if len(item.Issued.DateParts) > 0 && len(item.Issued.DateParts[0]) > 0 {
+			entry.Year = fmt.Sprint(item.Issued.DateParts[0][0])
+		} else {
+			entry.Year = item.Issued.Literal
+		}
+		entry.Fields["title"] = entry.Title
+		entry.Fields["author"] = strings.Join(entry.Authors, " and ")
+		entry.Fields["year"] = entry.Year
+		bib.add(entry)
+	}
+	return bib, nil
+}
+
+/* end CSL-JSON */
+
+type CitationError struct {
+	Key   string
+	Start Pos
+	End   Pos
+}
+
+func (err *CitationError) Error() string {
+	return fmt.Sprintf("unknown citation key %s at %s", err.Key, err.Start)
+}
+
+// ResolveCitations numbers every CitationItem by the first citation of its key.
+// It returns the cited keys in that order and the citations of unknown keys.
+func ResolveCitations(ast *Ast, bib *Bibliography) ([]string, []*CitationError) {
+	var keys []string
+	var errs []*CitationError
+	numbers := map[string]uint32{}
+	ast.Root.preVisitInlines(func(node *AstNode) {
+		item, ok := node.Type.(*CitationItem)
+		if !ok || len(_crossRefKind(item.Key)) > 0 {
+			// cross references are resolved by ResolveCrossRefs
+			return
+		}
+		if _, ok := bib.Entries[item.Key]; !ok {
+			item.Number = 0
+			errs = append(errs, &CitationError{Key: item.Key, Start: node.Start, End: node.End})
+			return
+		}
+		if _, ok := numbers[item.Key]; !ok {
+			keys = append(keys, item.Key)
+			numbers[item.Key] = uint32(len(keys))
+		}
+		item.Number = numbers[item.Key]
+	})
+	return keys, errs
+}
+
+const (
+	BibliographyNumeric uint32 = iota
+	BibliographyAuthorYear
+)
+
+func _authorFamily(author string) string {
+	if comma := strings.IndexByte(author, ','); comma >= 0 {
+		return strings.TrimSpace(author[:comma])
+	}
+	words := strings.Fields(author)
+	if len(words) == 0 {
+		return ""
+	}
+	return words[len(words)-1]
+}
+
+func (entry *BibEntry) shortAuthors() string {
+	switch len(entry.Authors) {
+	case 0:
+		return entry.Title
+	case 1:
+		return _authorFamily(entry.Authors[0])
+	case 2:
+		return _authorFamily(entry.Authors[0]) + " and " + _authorFamily(entry.Authors[1])
+	default:
+		return _authorFamily(entry.Authors[0]) + " et al."
+	}
+}
+
+func (entry *BibEntry) reference() string {
+	var parts []string
+	if len(entry.Authors) > 0 {
+		parts = append(parts, strings.Join(entry.Authors, "; "))
+	}
+	if len(entry.Year) > 0 {
+		parts = append(parts, "("+entry.Year+")")
+	}
+	ref := strings.Join(parts, " ")
+	for _, s := range []string{entry.Title, entry.Container, entry.Publisher} {
+		if len(s) == 0 {
+			continue
+		}
+		if len(ref) > 0 {
+			ref += ". "
+		}
+		ref += s
+	}
+	return ref + "."
+}
+
+// FormatCitation renders a Citation node, numbers are assigned by ResolveCitations
+func FormatCitation(node *AstNode, bib *Bibliography, style uint32) string {
+	citation, ok := node.Type.(*Citation)
+	if !ok {
+		return ""
+	}
+	var items []string
+	for _, ch := range node.Children {
+		item, ok := ch.Type.(*CitationItem)
+		if !ok {
+			continue
+		}
+		entry, ok := bib.Entries[item.Key]
+		var s string
+		if !ok {
+			s = "?" + item.Key
+		} else if style == BibliographyNumeric {
+			s = fmt.Sprint(item.Number)
+		} else if citation.InText {
+			s = entry.shortAuthors() + " (" + entry.Year
+		} else if item.SuppressAuthor {
+			s = entry.Year
+		} else {
+			s = entry.shortAuthors() + " " + entry.Year
+		}
+		if len(item.Prefix) > 0 {
+			s = item.Prefix + " " + s
+		}
+		for _, extra := range []string{item.Locator, item.Suffix} {
+			if len(extra) > 0 {
+				s += ", " + extra
+			}
+		}
+		if ok && style == BibliographyAuthorYear && citation.InText {
+			s += ")"
+		}
+		items = append(items, s)
+	}
+	if style == BibliographyNumeric {
+		return "[" + strings.Join(items, "; ") + "]"
+	} else if citation.InText {
+		return strings.Join(items, "; ")
+	}
+	return "(" + strings.Join(items, "; ") + ")"
+}
+
+// RenderBibliography emits a markdown reference section for keys,
+// keys should be ordered as returned by ResolveCitations
+func RenderBibliography(bib *Bibliography, keys []string, style uint32) string {
+	var entries []*BibEntry
+	for _, key := range keys {
+		if entry, ok := bib.Entries[key]; ok {
+			entries = append(entries, entry)
+		}
+	}
+	if style == BibliographyAuthorYear {
+		sort.SliceStable(entries, func(i, j int) bool {
+			ai, aj := entries[i].shortAuthors(), entries[j].shortAuthors()
+			if ai != aj {
+				return ai < aj
+			}
+			return entries[i].Year < entries[j].Year
+		})
+	}
+
+	var builder strings.Builder
+	builder.WriteString("## References\n\n")
+	for i, entry := range entries {
+		if style == BibliographyNumeric {
+			builder.WriteString(fmt.Sprintf("%d. ", i+1))
+		} else {
+			builder.WriteString("- ")
+		}
+		builder.WriteString(entry.reference())
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
@@ -0,0 +1,108 @@
+package parserlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCitation(t *testing.T) {
+	mk := `As @knuth1984 [p. 33] shows [see @knuth1984, pp. 33-35, for details; -@doe2020].
+Mail me@example.com, cite [@lamport94] or [no citation here].`
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+	t.Logf(ast.String())
+	assert.Nil(t, ast.Validate())
+
+	var citationType []Citation
+	var itemType []CitationItem
+	var itemNode []*AstNode
+	ast.Root.PreVisit(func(node *AstNode) {
+		switch tp := node.Type.(type) {
+		case *Citation:
+			citationType = append(citationType, *tp)
+		case *CitationItem:
+			itemType = append(itemType, *tp)
+			itemNode = append(itemNode, node)
+		}
+	})
+	assert.Equal(t, 3, len(citationType))
+	assert.Equal(t, []bool{true, false, false}, []bool{citationType[0].InText, citationType[1].InText, citationType[2].InText})
+	assert.Equal(t, 4, len(itemType))
+
+	assert.Equal(t, CitationItem{Key: "knuth1984", Locator: "p. 33"}, itemType[0])
+	assert.Equal(t, "@knuth1984 [p. 33]", itemNode[0].Text())
+	assert.Equal(t, CitationItem{Key: "knuth1984", Prefix: "see", Locator: "pp. 33-35", Suffix: "for details"}, itemType[1])
+	assert.Equal(t, "see @knuth1984, pp. 33-35, for details", itemNode[1].Text())
+	assert.Equal(t, CitationItem{Key: "doe2020", SuppressAuthor: true}, itemType[2])
+	assert.Equal(t, "-@doe2020", itemNode[2].Text())
+	assert.Equal(t, "lamport94", itemType[3].Key)
+}
+
+func TestBibliography(t *testing.T) {
+	bibtex := `@comment{ignored}
+@book{knuth1984,
+  author    = {Donald E. Knuth},
+  title     = {The {\TeX}book},
+  publisher = "Addison-Wesley",
+  year      = 1984
+}
+@article(doe2020, author = {Doe, Jane and Roe, Richard}, title = {On Things},
+  journal = {Journal} # { of Things}, year = {2020})`
+	csl := `[{"id": "lamport94", "type": "book", "title": "LaTeX",
+  "author": [{"family": "Lamport", "given": "Leslie"}],
+  "issued": {"date-parts": [[1994, 6]]}}]`
+
+	dir := t.TempDir()
+	bibPath := filepath.Join(dir, "refs.bib")
+	cslPath := filepath.Join(dir, "refs.json")
+	assert.Equal(t, nil, os.WriteFile(bibPath, []byte(bibtex), 0644))
+	assert.Equal(t, nil, os.WriteFile(cslPath, []byte(csl), 0644))
+
+	bib, err := LoadBibliography(bibPath)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"knuth1984", "doe2020"}, bib.Keys)
+	assert.Equal(t, []string{"Donald E. Knuth"}, bib.Entries["knuth1984"].Authors)
+	assert.Equal(t, `The \TeXbook`, bib.Entries["knuth1984"].Title)
+	assert.Equal(t, "1984", bib.Entries["knuth1984"].Year)
+	assert.Equal(t, []string{"Doe, Jane", "Roe, Richard"}, bib.Entries["doe2020"].Authors)
+	assert.Equal(t, "Journal of Things", bib.Entries["doe2020"].Container)
+
+	cslBib, err := LoadBibliography(cslPath)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"Lamport, Leslie"}, cslBib.Entries["lamport94"].Authors)
+	assert.Equal(t, "1994", cslBib.Entries["lamport94"].Year)
+
+	_, err = ParseBibTeX("@book{broken, title = {unclosed}")
+	assert.NotNil(t, err)
+
+	mk := `[@doe2020; @knuth1984] and @knuth1984 [p. 3], @missing`
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+	keys, errs := ResolveCitations(&ast, bib)
+	assert.Equal(t, []string{"doe2020", "knuth1984"}, keys)
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, "missing", errs[0].Key)
+	assert.Equal(t, 46, errs[0].Start.Offset)
+
+	var citations []*AstNode
+	ast.Root.PreVisit(func(node *AstNode) {
+		if _, ok := node.Type.(*Citation); ok {
+			citations = append(citations, node)
+		}
+	})
+	assert.Equal(t, "[1; 2]", FormatCitation(citations[0], bib, BibliographyNumeric))
+	assert.Equal(t, "(Doe and Roe 2020; Knuth 1984)", FormatCitation(citations[0], bib, BibliographyAuthorYear))
+	assert.Equal(t, "Knuth (1984, p. 3)", FormatCitation(citations[1], bib, BibliographyAuthorYear))
+
+	assert.Equal(t, "## References\n\n"+
+		"1. Doe, Jane; Roe, Richard (2020). On Things. Journal of Things.\n"+
+		"2. Donald E. Knuth (1984). The \\TeXbook. Addison-Wesley.\n",
+		RenderBibliography(bib, keys, BibliographyNumeric))
+	assert.Equal(t, "## References\n\n"+
+		"- Doe, Jane; Roe, Richard (2020). On Things. Journal of Things.\n"+
+		"- Donald E. Knuth (1984). The \\TeXbook. Addison-Wesley.\n",
+		RenderBibliography(bib, []string{"knuth1984", "doe2020"}, BibliographyAuthorYear))
+}
@@ -0,0 +1,304 @@
+package parserlib
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// position of a compact node, documents are limited to math.MaxInt32 bytes
+type compactPos struct {
+	line, col, offset int32
+}
+
+func (pos compactPos) pos() Pos {
+	return Pos{Line: int(pos.line), Col: int(pos.col), Offset: int(pos.offset)}
+}
+
+func _compactPos(pos Pos) compactPos {
+	return compactPos{line: int32(pos.Line), col: int32(pos.Col), offset: int32(pos.Offset)}
+}
+
+// a SyntaxRange of a compact node
+type compactSyntax struct {
+	kind       uint32
+	start, end compactPos
+}
+
+// CompactAst is an ast stored as arrays indexed by nodes in pre-order, the root is node 0.
+// Except for the table of node types, the arrays hold no pointers, so that a large
+// document costs the garbage collector little. Node types without fields share an
+// entry of the table.
+type CompactAst struct {
+	src string
+	// ids of the node types, 0 for types neither default nor registered
+	kinds  []uint16
+	starts []compactPos
+	ends   []compactPos
+	// indices of the related nodes, -1 if there isn't one
+	parents       []int32
+	leftSiblings  []int32
+	firstChildren []int32
+	nextSiblings  []int32
+	// index of the type of the node in types
+	typeIndices []int32
+	types       []AstNodeType
+	// the syntax of node i is syntax[syntaxStarts[i]:syntaxStarts[i+1]]
+	syntaxStarts []int32
+	syntax       []compactSyntax
+}
+
+// id of the node type tp as the kind of a compact node
+func _compactKind(id int, tp AstNodeType) (uint16, error) {
+	if id < 0 {
+		return 0, fmt.Errorf("node type %s is not registered", GetNodeTypeName(tp))
+	} else if id > math.MaxUint16 {
+		return 0, fmt.Errorf("id %d of node type %s is too large to compact", id, GetNodeTypeName(tp))
+	}
+	return uint16(id), nil
+}
+
+// Compact converts the ast to a CompactAst, text nodes parsed by ParseLazy are parsed first.
+// It fails if the document is larger than math.MaxInt32 bytes or nodes, or if a node type
+// isn't registered or has an id larger than math.MaxUint16.
+func (ast *Ast) Compact() (*CompactAst, error) {
+	if len(ast.src) > math.MaxInt32 {
+		return nil, fmt.Errorf("a document of %d bytes is too large to compact", len(ast.src))
+	}
+	c := &CompactAst{src: ast.src}
+	// type ids and shared types by the reflected type
+	ids := map[reflect.Type]uint16{}
+	shared := map[reflect.Type]int32{}
+	var err error
+	var fAdd func(node *AstNode, parent int32, leftSibling int32) int32
+	fAdd = func(node *AstNode, parent int32, leftSibling int32) int32 {
+		if err != nil {
+			return -1
+		}
+		if len(c.kinds) == math.MaxInt32 {
+			err = fmt.Errorf("a document of more than %d nodes is too large to compact", math.MaxInt32)
+			return -1
+		}
+		index := int32(len(c.kinds))
+		tp := reflect.TypeOf(node.Type)
+		id, ok := ids[tp]
+		if !ok {
+			if id, err = _compactKind(GetNodeTypeId(node.Type), node.Type); err != nil {
+				return -1
+			}
+			ids[tp] = id
+		}
+		typeIndex, ok := shared[tp]
+		if !ok {
+			typeIndex = int32(len(c.types))
+			c.types = append(c.types, node.Type)
+			if tp.Kind() == reflect.Ptr && tp.Elem().Size() == 0 {
+				shared[tp] = typeIndex
+			}
+		}
+		c.kinds = append(c.kinds, id)
+		c.starts = append(c.starts, _compactPos(node.Start))
+		c.ends = append(c.ends, _compactPos(node.End))
+		c.parents = append(c.parents, parent)
+		c.leftSiblings = append(c.leftSiblings, leftSibling)
+		c.firstChildren = append(c.firstChildren, -1)
+		c.nextSiblings = append(c.nextSiblings, -1)
+		c.typeIndices = append(c.typeIndices, typeIndex)
+		c.syntaxStarts = append(c.syntaxStarts, int32(len(c.syntax)))
+		for _, r := range node.Syntax {
+			c.syntax = append(c.syntax, compactSyntax{kind: r.Kind, start: _compactPos(r.Start), end: _compactPos(r.End)})
+		}
+
+		last := int32(-1)
+		for _, ch := range node.Inlines() {
+			child := fAdd(ch, index, last)
+			if last < 0 {
+				c.firstChildren[index] = child
+			} else {
+				c.nextSiblings[last] = child
+			}
+			last = child
+		}
+		return index
+	}
+	fAdd(&ast.Root, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+	c.syntaxStarts = append(c.syntaxStarts, int32(len(c.syntax)))
+	return c, nil
+}
+
+// ParseCompact parses s like Parse and returns its CompactAst, the ast parsed is dropped.
+// The ast is still built first, so parsing allocates as much as Parse does, see
+// BenchmarkParseCompact; the CompactAst only saves the memory of the ast kept afterwards.
+func (parser *MKParser) ParseCompact(s string) (*CompactAst, error) {
+	ast := parser.Parse(s)
+	return ast.Compact()
+}
+
+// Source returns the document the ast is parsed from
+func (c *CompactAst) Source() string {
+	return c.src
+}
+
+// Len returns the number of nodes, the root included
+func (c *CompactAst) Len() int {
+	return len(c.kinds)
+}
+
+func (c *CompactAst) Root() CompactNode {
+	return CompactNode{ast: c, index: 0}
+}
+
+// Node returns the node of index in pre-order, no node if index is out of the nodes
+func (c *CompactAst) Node(index int) CompactNode {
+	if index < 0 || index >= len(c.kinds) {
+		return CompactNode{}
+	}
+	return CompactNode{ast: c, index: int32(index)}
+}
+
+// ToAst converts the CompactAst back to an ast, which can't be updated
+func (c *CompactAst) ToAst() Ast {
+	ast := Ast{src: c.src}
+	// the root is kept in the ast
+	nodes := make([]AstNode, len(c.kinds))
+	fNode := func(index int32) *AstNode {
+		if index < 0 {
+			return nil
+		} else if index == 0 {
+			return &ast.Root
+		}
+		return &nodes[index]
+	}
+	for i := range nodes {
+		node := fNode(int32(i))
+		node.Type = c.types[c.typeIndices[i]]
+		node.Start = c.starts[i].pos()
+		node.End = c.ends[i].pos()
+		node.Parent = fNode(c.parents[i])
+		node.LeftSibling = fNode(c.leftSiblings[i])
+		node.Syntax = CompactNode{ast: c, index: int32(i)}.Syntax()
+		for ch := c.firstChildren[i]; ch >= 0; ch = c.nextSiblings[ch] {
+			node.Children = append(node.Children, fNode(ch))
+		}
+	}
+	ast.setSource()
+	return ast
+}
+
+// CompactNode is a node of a CompactAst, the zero value is no node
+type CompactNode struct {
+	ast   *CompactAst
+	index int32
+}
+
+func (c *CompactAst) node(index int32) CompactNode {
+	if index < 0 {
+		return CompactNode{}
+	}
+	return CompactNode{ast: c, index: index}
+}
+
+// IsNil reports whether there is no node, like a nil *AstNode
+func (node CompactNode) IsNil() bool {
+	return node.ast == nil
+}
+
+// Index returns the index of the node in pre-order
+func (node CompactNode) Index() int {
+	return int(node.index)
+}
+
+func (node CompactNode) Type() AstNodeType {
+	return node.ast.types[node.ast.typeIndices[node.index]]
+}
+
+// Kind returns the id of the node type like GetNodeTypeId
+func (node CompactNode) Kind() int {
+	return int(node.ast.kinds[node.index])
+}
+
+func (node CompactNode) Start() Pos {
+	return node.ast.starts[node.index].pos()
+}
+
+func (node CompactNode) End() Pos {
+	return node.ast.ends[node.index].pos()
+}
+
+// Syntax returns the syntax recorded by the parsers like AstNode.Syntax
+func (node CompactNode) Syntax() []SyntaxRange {
+	var syntax []SyntaxRange
+	for _, r := range node.ast.syntax[node.ast.syntaxStarts[node.index]:node.ast.syntaxStarts[node.index+1]] {
+		syntax = append(syntax, SyntaxRange{Kind: r.kind, Range: Range{Start: r.start.pos(), End: r.end.pos()}})
+	}
+	return syntax
+}
+
+func (node CompactNode) Parent() CompactNode {
+	return node.ast.node(node.ast.parents[node.index])
+}
+
+func (node CompactNode) LeftSibling() CompactNode {
+	return node.ast.node(node.ast.leftSiblings[node.index])
+}
+
+func (node CompactNode) FirstChild() CompactNode {
+	return node.ast.node(node.ast.firstChildren[node.index])
+}
+
+func (node CompactNode) NextSibling() CompactNode {
+	return node.ast.node(node.ast.nextSiblings[node.index])
+}
+
+func (node CompactNode) Children() []CompactNode {
+	children := []CompactNode{}
+	for ch := node.FirstChild(); !ch.IsNil(); ch = ch.NextSibling() {
+		children = append(children, ch)
+	}
+	return children
+}
+
+func (node CompactNode) PreVisit(f func(CompactNode)) {
+	if node.IsNil() {
+		return
+	}
+	// the subtree is the nodes up to the next node out of it
+	end := int32(len(node.ast.kinds))
+	for cur := node; !cur.IsNil(); cur = cur.Parent() {
+		if next := node.ast.nextSiblings[cur.index]; next >= 0 {
+			end = next
+			break
+		}
+	}
+	for i := node.index; i < end; i++ {
+		f(CompactNode{ast: node.ast, index: i})
+	}
+}
+
+// Text returns the source of the node
+func (node CompactNode) Text() string {
+	return node.ast.src[node.ast.starts[node.index].offset:node.ast.ends[node.index].offset]
+}
+
+func (node CompactNode) String() string {
+	if node.IsNil() {
+		return "None"
+	}
+	lines := []string{}
+	node.PreVisit(func(cur CompactNode) {
+		if cur.index == node.index {
+			lines = append(lines, cur.Type().String()+cur.Start().String())
+			return
+		}
+		depth := 0
+		for p := cur; p.index != node.index; p = p.Parent() {
+			depth += 1
+		}
+		lines = append(lines, strings.Repeat("  ", depth)+"|-"+cur.Type().String()+cur.Start().String())
+	})
+	return strings.Join(lines, "\n")
+}
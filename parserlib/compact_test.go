@@ -0,0 +1,114 @@
+package parserlib
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactAst(t *testing.T) {
+	content, err := os.ReadFile("../tests/largemk.md")
+	assert.Nil(t, err)
+	parser := GetFullMKParser()
+	ast := parser.Parse(string(content))
+	c, err := parser.ParseCompact(string(content))
+	assert.Nil(t, err)
+	assert.Equal(t, string(content), c.Source())
+	assert.Equal(t, ast.Root.String(), c.Root().String())
+	// most nodes have no fields and share their types
+	assert.Less(t, len(c.types)*3, c.Len())
+
+	// the views navigate like the nodes
+	nodes := []*AstNode{}
+	ast.Root.PreVisit(func(node *AstNode) {
+		nodes = append(nodes, node)
+	})
+	assert.Equal(t, len(nodes), c.Len())
+	index := map[*AstNode]int{}
+	for i, node := range nodes {
+		index[node] = i
+	}
+	fIndex := func(node *AstNode) int {
+		if node == nil {
+			return -1
+		}
+		if _isCopiedRoot(node) {
+			return 0
+		}
+		return index[node]
+	}
+	fViewIndex := func(view CompactNode) int {
+		if view.IsNil() {
+			return -1
+		}
+		return view.Index()
+	}
+	c.Root().PreVisit(func(view CompactNode) {
+		node := nodes[view.Index()]
+		assert.Equal(t, node.Type, view.Type())
+		assert.Equal(t, GetNodeTypeId(node.Type), view.Kind())
+		assert.Equal(t, node.Start, view.Start())
+		assert.Equal(t, node.End, view.End())
+		assert.Equal(t, node.Syntax, view.Syntax())
+		assert.Equal(t, node.Text(), view.Text())
+		assert.Equal(t, fIndex(node.Parent), fViewIndex(view.Parent()))
+		assert.Equal(t, fIndex(node.LeftSibling), fViewIndex(view.LeftSibling()))
+		children := view.Children()
+		assert.Equal(t, len(node.Children), len(children))
+		for i, ch := range children {
+			assert.Equal(t, index[node.Children[i]], ch.Index())
+		}
+	})
+
+	// a subtree is visited alone
+	list := c.Node(index[ast.Root.Children[3]])
+	visited := 0
+	list.PreVisit(func(view CompactNode) {
+		visited += 1
+	})
+	cnt := 0
+	ast.Root.Children[3].PreVisit(func(node *AstNode) {
+		cnt += 1
+	})
+	assert.Equal(t, cnt, visited)
+	assert.True(t, c.Root().Parent().IsNil())
+	assert.True(t, c.Node(c.Len()).IsNil())
+
+	back := c.ToAst()
+	assert.Nil(t, back.Validate())
+	assert.True(t, ast.Root._eq(&back.Root))
+	assert.Equal(t, ast.Root.String(), back.Root.String())
+
+	// pending inlines are parsed
+	lazy := parser.ParseLazy(string(content))
+	lazyCompact, err := lazy.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, c.Root().String(), lazyCompact.Root().String())
+
+	// ids that don't fit are an error instead of a wrong kind
+	_, err = _compactKind(math.MaxUint16+1, &Text{})
+	assert.NotNil(t, err)
+}
+
+// ParseCompact builds the ast before the arrays, so it allocates more than Parse while parsing
+func BenchmarkParseCompact(b *testing.B) {
+	content, err := os.ReadFile("../tests/largemk.md")
+	if err != nil {
+		b.Fatal(err)
+	}
+	parser := GetFullMKParser()
+	b.Run("Parse", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			parser.Parse(string(content))
+		}
+	})
+	b.Run("ParseCompact", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			parser.ParseCompact(string(content))
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package parserlib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// label kinds numbered by ResolveCrossRefs, "@fig:name" refers to "{#fig:name}"
+var crossRefKinds = []string{"fig", "tbl", "eq"}
+
+// kind of a label or reference key, empty if it isn't a cross reference
+func _crossRefKind(key string) string {
+	colon := strings.IndexByte(key, ':')
+	if colon < 0 {
+		return ""
+	}
+	for _, kind := range crossRefKinds {
+		if key[:colon] == kind {
+			return kind
+		}
+	}
+	return ""
+}
+
+type CrossRef struct {
+	// CitationItem node of the reference
+	Ref *AstNode
+	// labelled Image, Table or MathBlock, nil if the reference is dangling
+	Target *AstNode
+	Number uint32
+}
+
+type CrossRefs struct {
+	// labelled nodes by label name
+	Targets map[string]*AstNode
+	// resolved references in document order
+	Refs     []CrossRef
+	Dangling []CrossRef
+}
+
+func (ref CrossRef) Error() string {
+	item := ref.Ref.Type.(*CitationItem)
+	return fmt.Sprintf("dangling reference %s at %s", item.Key, ref.Ref.Start)
+}
+
+// ResolveCrossRefs numbers labels of every kind in document order and links
+// references to the labelled nodes. Labels defined twice keep the first number.
+func ResolveCrossRefs(ast *Ast) *CrossRefs {
+	refs := &CrossRefs{Targets: map[string]*AstNode{}}
+	numbers := map[string]uint32{}
+	counts := map[string]uint32{}
+	ast.Root.preVisitInlines(func(node *AstNode) {
+		label, ok := node.Type.(*Label)
+		if !ok {
+			return
+		}
+		kind := _crossRefKind(label.Name)
+		if len(kind) == 0 {
+			return
+		}
+		if number, ok := numbers[label.Name]; ok {
+			label.Number = number
+			return
+		}
+		target := node.Parent
+		if _, ok := target.Type.(*TableCaption); ok {
+			target = target.Parent
+		}
+		counts[kind] += 1
+		label.Number = counts[kind]
+		numbers[label.Name] = label.Number
+		refs.Targets[label.Name] = target
+	})
+
+	ast.Root.preVisitInlines(func(node *AstNode) {
+		item, ok := node.Type.(*CitationItem)
+		if !ok || len(_crossRefKind(item.Key)) == 0 {
+			return
+		}
+		ref := CrossRef{Ref: node}
+		if target, ok := refs.Targets[item.Key]; ok {
+			ref.Target = target
+			ref.Number = numbers[item.Key]
+			item.Number = ref.Number
+			refs.Refs = append(refs.Refs, ref)
+		} else {
+			item.Number = 0
+			refs.Dangling = append(refs.Dangling, ref)
+		}
+	})
+	return refs
+}
@@ -0,0 +1,237 @@
+package parserlib
+
+import (
+	"slices"
+	"strings"
+)
+
+const (
+	// content: text, code, urls, titles, info strings
+	TokenText uint32 = iota
+	// syntax: '#'s, list markers, fences, delimiters, table pipes
+	TokenMarker
+	// whitespace: indentation, line endings, blank lines, the BOM
+	TokenTrivia
+)
+
+// Token is a piece of the source owned by a node of the concrete syntax tree
+type Token struct {
+	Kind  uint32
+	Start Pos
+	End   Pos
+	// source of the token, rewrite it to change the output of Source
+	Text string
+}
+
+// CstNode is a node of the concrete syntax tree. Its tokens and the ranges of its
+// children cover the range of its ast node without gaps, so the source is reproduced
+// byte-for-byte from the tree.
+type CstNode struct {
+	Node   *AstNode
+	Parent *CstNode
+	// tokens of the node and its children in source order
+	Items []CstItem
+}
+
+// CstItem is either a token of the node or a child
+type CstItem struct {
+	Token *Token
+	Child *CstNode
+}
+
+// CST builds the concrete syntax tree of the ast, text nodes parsed by ParseLazy are parsed first.
+// The tree refers to the nodes of the ast and goes stale once the ast is updated.
+func (ast *Ast) CST() *CstNode {
+	index := NewLineIndex(ast.src)
+	var fBuild func(node *AstNode, parent *CstNode, inherited []SyntaxRange) *CstNode
+	fBuild = func(node *AstNode, parent *CstNode, inherited []SyntaxRange) *CstNode {
+		cst := &CstNode{Node: node, Parent: parent}
+		syntax := append(append([]SyntaxRange{}, inherited...), node.Syntax...)
+		slices.SortFunc(syntax, func(a, b SyntaxRange) int {
+			return a.Start.Offset - b.Start.Offset
+		})
+		fTokens := func(start int, end int) {
+			for _, piece := range _cstSplit(ast.src, start, end, node, syntax) {
+				end := start + piece.size
+				cst.Items = append(cst.Items, CstItem{Token: &Token{
+					Kind:  piece.kind,
+					Start: index.Pos(start),
+					End:   index.Pos(end),
+					Text:  ast.src[start:end],
+				}})
+				start = end
+			}
+		}
+		cur := node.Start.Offset
+		for _, ch := range node.Inlines() {
+			if ch.Start.Offset > cur {
+				fTokens(cur, ch.Start.Offset)
+			}
+			// the syntax of a text node holds for the inlines parsed from it
+			var chSyntax []SyntaxRange
+			for _, r := range syntax {
+				if r.Start.Offset < ch.End.Offset && r.End.Offset > ch.Start.Offset {
+					chSyntax = append(chSyntax, r)
+				}
+			}
+			cst.Items = append(cst.Items, CstItem{Child: fBuild(ch, cst, chSyntax)})
+			cur = ch.End.Offset
+		}
+		if node.End.Offset > cur {
+			fTokens(cur, node.End.Offset)
+		}
+		return cst
+	}
+	return fBuild(&ast.Root, nil, nil)
+}
+
+// Source reproduces the source of the node from its tokens, rewritten tokens included
+func (node *CstNode) Source() string {
+	var sb strings.Builder
+	var fWrite func(node *CstNode)
+	fWrite = func(node *CstNode) {
+		for _, item := range node.Items {
+			if item.Token != nil {
+				sb.WriteString(item.Token.Text)
+			} else {
+				fWrite(item.Child)
+			}
+		}
+	}
+	fWrite(node)
+	return sb.String()
+}
+
+// Tokens returns the tokens of the node, without the tokens of its children
+func (node *CstNode) Tokens() []*Token {
+	tokens := []*Token{}
+	for _, item := range node.Items {
+		if item.Token != nil {
+			tokens = append(tokens, item.Token)
+		}
+	}
+	return tokens
+}
+
+// AllTokens returns the tokens of the node and its descendants in source order
+func (node *CstNode) AllTokens() []*Token {
+	tokens := []*Token{}
+	for _, item := range node.Items {
+		if item.Token != nil {
+			tokens = append(tokens, item.Token)
+		} else {
+			tokens = append(tokens, item.Child.AllTokens()...)
+		}
+	}
+	return tokens
+}
+
+func (node *CstNode) Children() []*CstNode {
+	children := []*CstNode{}
+	for _, item := range node.Items {
+		if item.Child != nil {
+			children = append(children, item.Child)
+		}
+	}
+	return children
+}
+
+func (node *CstNode) PreVisit(f func(*CstNode)) {
+	if node == nil {
+		return
+	}
+	f(node)
+	for _, item := range node.Items {
+		if item.Child != nil {
+			item.Child.PreVisit(f)
+		}
+	}
+}
+
+// a token of the given kind and size
+type cstPiece struct {
+	kind uint32
+	size int
+}
+
+func _isCstSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func _cstAppend(pieces []cstPiece, kind uint32, size int) []cstPiece {
+	if size == 0 {
+		return pieces
+	}
+	if n := len(pieces); n > 0 && pieces[n-1].kind == kind {
+		pieces[n-1].size += size
+		return pieces
+	}
+	return append(pieces, cstPiece{kind: kind, size: size})
+}
+
+// splits src[start:end] owned by node: the syntax recorded by the parsers is marker or
+// trivia, the content ranges of its type are text and the rest is split by _cstLayout
+func _cstSplit(src string, start int, end int, node *AstNode, syntax []SyntaxRange) []cstPiece {
+	pieces := []cstPiece{}
+	fRanges := func(pieces []cstPiece, start int, end int) []cstPiece {
+		if ranged, ok := node.Type.(rangedType); ok {
+			for _, r := range ranged.ranges() {
+				rStart := min(max(r.Start.Offset, start), end)
+				rEnd := min(max(r.End.Offset, start), end)
+				if rStart >= rEnd {
+					continue
+				}
+				pieces = _cstLayout(pieces, src, start, rStart, node)
+				pieces = _cstAppend(pieces, TokenText, rEnd-rStart)
+				start = rEnd
+			}
+		}
+		return _cstLayout(pieces, src, start, end, node)
+	}
+	cur := start
+	for _, r := range syntax {
+		rStart := min(max(r.Start.Offset, cur), end)
+		rEnd := min(max(r.End.Offset, cur), end)
+		if rStart >= rEnd {
+			continue
+		}
+		pieces = fRanges(pieces, cur, rStart)
+		pieces = _cstAppend(pieces, r.Kind, rEnd-rStart)
+		cur = rEnd
+	}
+	return fRanges(pieces, cur, end)
+}
+
+// splits src[start:end] that no parser recorded: the BOM is trivia, text nodes are text,
+// elsewhere whitespace at the start or the end of a line is trivia and the rest is text
+func _cstLayout(pieces []cstPiece, src string, start int, end int, node *AstNode) []cstPiece {
+	if start == 0 && end >= len(utf8BOM) && strings.HasPrefix(src, utf8BOM) {
+		pieces = _cstAppend(pieces, TokenTrivia, len(utf8BOM))
+		start = len(utf8BOM)
+	}
+	if _, ok := node.Type.(*Text); ok {
+		return _cstAppend(pieces, TokenText, end-start)
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && _isCstSpace(src[j]) {
+			j++
+		}
+		if j > i {
+			atEdge := i == 0 || src[i-1] == '\n' || j == len(src) || src[j] == '\n' || strings.IndexByte(src[i:j], '\n') >= 0
+			if atEdge {
+				pieces = _cstAppend(pieces, TokenTrivia, j-i)
+			} else {
+				pieces = _cstAppend(pieces, TokenText, j-i)
+			}
+			i = j
+			continue
+		}
+		for j < end && !_isCstSpace(src[j]) {
+			j++
+		}
+		pieces = _cstAppend(pieces, TokenText, j-i)
+		i = j
+	}
+	return pieces
+}
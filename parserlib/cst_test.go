@@ -0,0 +1,91 @@
+package parserlib
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// kinds and texts of the tokens of the node, "m:" for markers, "t:" for text and "_:" for trivia
+func _cstTokens(node *CstNode) []string {
+	prefixes := []string{"t:", "m:", "_:"}
+	tokens := []string{}
+	for _, token := range node.Tokens() {
+		tokens = append(tokens, prefixes[token.Kind]+token.Text)
+	}
+	return tokens
+}
+
+func TestCST(t *testing.T) {
+	content, err := os.ReadFile("../tests/largemk.md")
+	assert.Nil(t, err)
+	parser := GetFullMKParser()
+	for _, s := range []string{string(content), "", "\n\n", "\uFEFF# a\r\n\r\ntext\r\n\r\n"} {
+		ast := parser.Parse(s)
+		cst := ast.CST()
+		assert.Equal(t, s, cst.Source())
+		lazy := parser.ParseLazy(s)
+		assert.Equal(t, s, lazy.CST().Source())
+
+		// tokens are in order and match the source
+		offset := 0
+		for _, token := range cst.AllTokens() {
+			assert.Equal(t, offset, token.Start.Offset)
+			assert.Equal(t, token.Text, s[token.Start.Offset:token.End.Offset])
+			offset = token.End.Offset
+		}
+		assert.Equal(t, len(s), offset)
+		assert.Same(t, &ast.Root, cst.Node)
+	}
+
+	s := "## Title ##\n\n- [x] a\n- [ ] b\n\n3. c\n\n> q\n> r\n\n```go\nx\n```\n\n| a | b |\n|---|:-:|\n\n" +
+		"[l](http://x.y \"t\") `c` **e** <http://a.b>\n\n***\n"
+	ast := parser.Parse(s)
+	cst := ast.CST()
+	t.Logf(ast.String())
+	children := cst.Children()
+	header := children[0]
+	assert.Equal(t, []string{"m:##", "_:\n"}, _cstTokens(header))
+	assert.Equal(t, []string{"_: ", "t:Title", "_: ", "m:##"}, _cstTokens(header.Children()[0]))
+	assert.Equal(t, []string{"m:-", "_: ", "m:[x]", "_:\n"}, _cstTokens(children[1].Children()[0]))
+	assert.Equal(t, []string{"m:-", "_: ", "m:[ ]", "_:\n"}, _cstTokens(children[1].Children()[1]))
+	assert.Equal(t, []string{"m:3.", "_:\n"}, _cstTokens(children[2].Children()[0]))
+	assert.Equal(t, []string{"m:>", "_: ", "_:\n"}, _cstTokens(children[3]))
+
+	var code, table, link *CstNode
+	cst.PreVisit(func(node *CstNode) {
+		switch node.Node.Type.(type) {
+		case *CodeBlock:
+			code = node
+		case *TableHead:
+			table = node
+		case *Link:
+			link = node
+		}
+	})
+	assert.Equal(t, []string{"m:```", "t:go", "_:\n", "t:x\n", "m:```", "_:\n"}, _cstTokens(code))
+	assert.Equal(t, []string{"m:|", "_: ", "_: ", "m:|", "_: ", "_: ", "m:|", "_:\n"}, _cstTokens(table))
+	assert.Equal(t, []string{"m:[", "m:](", "t:http://x.y", "_: ", "m:\"", "t:t", "m:\")"}, _cstTokens(link))
+
+	// punctuation is text unless a parser recorded it as syntax
+	ast = parser.Parse("see @a. (b) **c, d.** [-@e; f @g]\n\n*[HTML]: Hyper Text Markup.\n")
+	t.Logf(ast.String())
+	children = ast.CST().Children()
+	assert.Equal(t, []string{"m:*[", "t:HTML", "m:]:", "_: ", "t:Hyper Text Markup.", "_:\n"}, _cstTokens(children[1]))
+	items := children[0].Children()
+	assert.Equal(t, []string{"t:. (b) "}, _cstTokens(items[2]))
+	assert.Equal(t, []string{"m:@", "t:a"}, _cstTokens(items[1].Children()[0]))
+	assert.Equal(t, []string{"m:**", "t:c, d.", "m:**"}, _cstTokens(items[3]))
+	assert.Equal(t, []string{"m:[", "m:;", "_: ", "m:]"}, _cstTokens(items[5]))
+	assert.Equal(t, []string{"m:-@", "t:e"}, _cstTokens(items[5].Children()[0]))
+	assert.Equal(t, []string{"t:f ", "m:@", "t:g"}, _cstTokens(items[5].Children()[1]))
+
+	// rewriting a token leaves the others alone
+	header.Tokens()[0].Text = "###"
+	code.Tokens()[0].Text = "~~~"
+	code.Tokens()[4].Text = "~~~"
+	want := strings.Replace(strings.Replace(s, "## Title", "### Title", 1), "```go\nx\n```", "~~~go\nx\n~~~", 1)
+	assert.Equal(t, want, cst.Source())
+}
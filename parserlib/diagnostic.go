@@ -0,0 +1,120 @@
+package parserlib
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	SeverityWarning uint32 = iota
+	SeverityError
+)
+
+type Diagnostic struct {
+	Severity uint32
+	Message  string
+	Start    Pos
+	End      Pos
+}
+
+func (diag *Diagnostic) Error() string {
+	severity := "warning"
+	if diag.Severity == SeverityError {
+		severity = "error"
+	}
+	return fmt.Sprintf("%s%s: %s", severity, diag.Start, diag.Message)
+}
+
+type diagnosticSink struct {
+	diags []Diagnostic
+	// start of the block being parsed, used to locate internal failures
+	blockStart Pos
+}
+
+// report an internal inconsistency of the parser
+func _bug(start Pos, end Pos, format string, args ...interface{}) {
+	panic(&Diagnostic{
+		Severity: SeverityError,
+		Message:  "Bug: " + fmt.Sprintf(format, args...),
+		Start:    start,
+		End:      end,
+	})
+}
+
+// Warn reports a recoverable syntax oddity, it does nothing unless parsing with diagnostics
+func (ctx ParseContext) Warn(start Pos, end Pos, format string, args ...interface{}) {
+	if ctx.diag == nil {
+		return
+	}
+	ctx.diag.diags = append(ctx.diag.diags, Diagnostic{
+		Severity: SeverityWarning,
+		Message:  fmt.Sprintf(format, args...),
+		Start:    start,
+		End:      end,
+	})
+}
+
+// convert a recovered panic to a diagnostic located at the block being parsed
+func (sink *diagnosticSink) recovered(r interface{}, s string) *Diagnostic {
+	if diag, ok := r.(*Diagnostic); ok {
+		return diag
+	}
+	index := NewLineIndex(s)
+	end := index.Pos(index.LineEnd(index.Line(sink.blockStart.Offset)))
+	return &Diagnostic{
+		Severity: SeverityError,
+		Message:  fmt.Sprint(r),
+		Start:    sink.blockStart,
+		End:      end,
+	}
+}
+
+// warn about footnotes, reference links and cross references without definitions
+func _checkReferences(ast *Ast, ctx ParseContext) {
+	footNotes := map[string]bool{}
+	refLinks := map[string]bool{}
+	ast.Root.PreVisit(func(node *AstNode) {
+		switch tp := node.Type.(type) {
+		case *FootNoteIndex:
+			footNotes[tp.Index] = true
+		case *ReferenceLinkIndex:
+			refLinks[tp.Index] = true
+		}
+	})
+	ast.Root.PreVisit(func(node *AstNode) {
+		switch tp := node.Type.(type) {
+		case *FootNote:
+			if !footNotes[tp.Index] {
+				ctx.Warn(node.Start, node.End, "footnote %s is not defined", tp.Index)
+			}
+		case *ReferenceLink:
+			if !refLinks[tp.Index] {
+				ctx.Warn(node.Start, node.End, "reference link %s is not defined", tp.Index)
+			}
+		}
+	})
+	for _, ref := range ResolveCrossRefs(ast).Dangling {
+		ctx.Warn(ref.Ref.Start, ref.Ref.End, "%s", ref.Error())
+	}
+}
+
+// ParseWithDiagnostics parses s like Parse, but internal failures are recovered
+// and returned as an error, and syntax oddities are reported as warnings.
+func (parser *MKParser) ParseWithDiagnostics(s string) (ast Ast, diags []Diagnostic, err error) {
+	sink := &diagnosticSink{}
+	defer func() {
+		if r := recover(); r != nil {
+			diag := sink.recovered(r, s)
+			ast = Ast{Root: AstNode{Type: &Document{}}}
+			diags = append(sink.diags, *diag)
+			err = diag
+		}
+	}()
+
+	ast = parser.parse(s, sink, nil, false)
+	_checkReferences(&ast, ParseContext{diag: sink})
+	sort.SliceStable(sink.diags, func(i, j int) bool {
+		return sink.diags[i].Start.Offset < sink.diags[j].Start.Offset
+	})
+	return ast, sink.diags, nil
+}
@@ -0,0 +1,59 @@
+package parserlib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagnostics(t *testing.T) {
+	mk := "| a | b |\n" +
+		"| -- |\n" +
+		"\n" +
+		"See [^missing] and @fig:none, `code` and $x$.\n" +
+		"\n" +
+		"```go\n" +
+		"unclosed"
+	parser := GetFullMKParser()
+	ast, diags, err := parser.ParseWithDiagnostics(mk)
+	t.Logf(ast.String())
+	assert.Equal(t, nil, err)
+	assert.Nil(t, ast.Validate())
+	expected := parser.Parse(mk)
+	assert.Equal(t, expected.String(), ast.String())
+
+	messages := []string{
+		"malformed table",
+		"footnote missing",
+		"dangling reference fig:none",
+		"unclosed ``` block",
+	}
+	texts := []string{"| -- |", "[^missing]", "@fig:none", "```go"}
+	assert.Equal(t, len(messages), len(diags))
+	for i, diag := range diags {
+		assert.Equal(t, SeverityWarning, diag.Severity)
+		assert.Equal(t, true, strings.Contains(diag.Message, messages[i]), diag.Message)
+		assert.Equal(t, texts[i], mk[diag.Start.Offset:diag.End.Offset])
+	}
+}
+
+func TestDiagnosticsRecover(t *testing.T) {
+	mk := "fine\n\n!!boom\n"
+	parser := GetFullMKParser()
+	parser.AddExtensionBlockParser(func(s string, ctx ParseContext) *AstNode {
+		if strings.HasPrefix(s, "!!") {
+			panic("extension failed")
+		}
+		return nil
+	})
+	ast, diags, err := parser.ParseWithDiagnostics(mk)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(ast.Root.Children))
+	assert.Equal(t, 1, len(diags))
+	assert.Equal(t, SeverityError, diags[0].Severity)
+	assert.Equal(t, "extension failed", diags[0].Message)
+	assert.Equal(t, "!!boom", mk[diags[0].Start.Offset:diags[0].End.Offset])
+
+	assert.Panics(t, func() { parser.Parse(mk) })
+}
@@ -0,0 +1,633 @@
+package parserlib
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+ * Parsers of the constructs CommonMark and GFM spell differently from xxmk, the profiles
+ * of GetProfileParser use them in place of the xxmk ones.
+ */
+
+// lines that end a paragraph in CommonMark: atx headers, fences, quotes, thematic breaks and
+// list items that aren't empty, an ordered one has to start with 1
+var paragraphInterruptRegex = regexp.MustCompile("^ {0,3}(#{1,6}([ \t]|$)|```|~~~|>|([-*+]|1[.)])[ \t]+[^ \t]|((\\*[ \t]*){3,}|(-[ \t]*){3,}|(_[ \t]*){3,})$)")
+
+var setextUnderlineRegex = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+
+// whether the line at ctx.P continues a paragraph: the pending text before it ends with a line that isn't blank
+func _inParagraph(ctx ParseContext) bool {
+	left := ctx.LeftSibling
+	if left == nil || ctx.scan == nil {
+		return false
+	}
+	if _, ok := left.Type.(*Text); !ok {
+		return false
+	}
+	start, end := left.Start.Offset-ctx.scan.base, left.End.Offset-ctx.scan.base
+	cur := ctx.P.Offset - ctx.scan.base
+	if start < 0 || end > cur || cur > len(ctx.scan.s) || !_isBlankLine(ctx.scan.s[end:cur]) {
+		return false
+	}
+	pending := strings.TrimSuffix(ctx.scan.s[start:end], "\n")
+	return !_isBlankLine(pending[strings.LastIndexByte(pending, '\n')+1:])
+}
+
+// whether the line is indented by a tab stop or more
+func _isIndentedLine(line string, indent int) bool {
+	_, ok := _indentPrefix(line, indent+tabStop)
+	return ok && !_isBlankLine(line)
+}
+
+/*
+ * Header
+ * ======
+ */
+func parseSetextHeader(s string, ctx ParseContext) *AstNode {
+	if len(s) == 0 || _inParagraph(ctx) {
+		return nil
+	}
+	first := s[:_lineEnd(s, 0)]
+	if _isBlankLine(first) || _isIndentedLine(first, 0) || paragraphInterruptRegex.MatchString(first) {
+		return nil
+	}
+	lineStart := len(first) + 1
+	var underline []string
+	for lineStart < len(s) {
+		lineEnd := _lineEnd(s, lineStart)
+		indent, _ := _indentPrefix(s[lineStart:], ctx.Indent)
+		line := s[lineStart+indent : lineEnd]
+		if underline = setextUnderlineRegex.FindStringSubmatch(line); underline != nil {
+			lineStart += indent
+			break
+		}
+		if _isBlankLine(line) || paragraphInterruptRegex.MatchString(line) {
+			return nil
+		}
+		lineStart = lineEnd + 1
+	}
+	if underline == nil {
+		return nil
+	}
+
+	head := Header{Level: 1}
+	if underline[1][0] == '-' {
+		head.Level = 2
+	}
+	textStart := len(first) - len(strings.TrimLeft(first, " \t"))
+	textEnd := len(strings.TrimRight(s[:lineStart], " \t\n"))
+	lineEnd := _lineEnd(s, lineStart)
+	endPos := ctx.P
+	endPos.ConsumeStr(s[:min(lineEnd+1, len(s))])
+	node := &AstNode{
+		Start:       ctx.P,
+		End:         endPos,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+	head.TextRange = _rangeIn(s, ctx.P, textStart, textEnd)
+	node.Type = &head
+
+	curCtx := ctx
+	curCtx.P.ConsumeStr(s[:textStart])
+	curCtx.Parent = node
+	curCtx.LeftSibling = nil
+	node.Children = append(node.Children, _textOrEmpty(s[textStart:textEnd], curCtx))
+	markerStart := lineStart + len(s[lineStart:lineEnd]) - len(strings.TrimLeft(s[lineStart:lineEnd], " "))
+	node.addSyntax(TokenTrivia, _rangeIn(s, ctx.P, 0, textStart))
+	node.addSyntax(TokenTrivia, _rangeIn(s, ctx.P, lineStart, markerStart))
+	node.addSyntax(TokenMarker, _rangeIn(s, ctx.P, markerStart, markerStart+len(underline[1])))
+	node.addSyntax(TokenTrivia, _rangeIn(s, ctx.P, markerStart+len(underline[1]), lineEnd))
+	return node
+}
+
+// lines indented by a tab stop, blank lines between them included
+func parseIndentedCode(s string, ctx ParseContext) *AstNode {
+	if len(s) == 0 || _inParagraph(ctx) || !_isIndentedLine(s[:_lineEnd(s, 0)], 0) {
+		return nil
+	}
+	end := 0
+	for lineStart := 0; lineStart < len(s); {
+		lineEnd := _lineEnd(s, lineStart)
+		line := s[lineStart:lineEnd]
+		// the first line is after the indentation of the nested blocks
+		indent := ctx.Indent
+		if lineStart == 0 {
+			indent = 0
+		}
+		if _isIndentedLine(line, indent) {
+			end = min(lineEnd+1, len(s))
+		} else if !_isBlankLine(line) {
+			break
+		}
+		lineStart = lineEnd + 1
+	}
+	endPos := ctx.P
+	endPos.ConsumeStr(s[:end])
+	return &AstNode{
+		Type: &CodeBlock{
+			SuffixRange: Range{Start: ctx.P, End: ctx.P},
+			BodyRange:   _rangeIn(s, ctx.P, 0, end),
+			Indent:      uint32(ctx.Indent + tabStop),
+		},
+		Start:       ctx.P,
+		End:         endPos,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+}
+
+// body with indent columns of indentation removed from every line, the first line starts at column col
+func _stripIndent(body string, col int, indent int) string {
+	var builder strings.Builder
+	for len(body) > 0 {
+		lineEnd := _lineEnd(body, 0)
+		if lineEnd < len(body) {
+			lineEnd += 1
+		}
+		line := body[:lineEnd]
+		i := 0
+		for ; i < len(line) && col < indent; i++ {
+			if line[i] == '\t' {
+				col += tabStop - col%tabStop
+			} else if line[i] == ' ' {
+				col += 1
+			} else {
+				break
+			}
+		}
+		builder.WriteString(line[i:])
+		body = body[lineEnd:]
+		col = 0
+	}
+	return builder.String()
+}
+
+func _isSpaceRune(c rune) bool {
+	return c == 0 || unicode.IsSpace(c)
+}
+
+func _isPunctRune(c rune) bool {
+	return unicode.IsPunct(c) || unicode.IsSymbol(c)
+}
+
+// whether a delimiter run between prev and next is left-flanking and right-flanking,
+// the start and the end of the text count as spaces
+func _flanking(prev rune, next rune) (bool, bool) {
+	left := !_isSpaceRune(next) && (!_isPunctRune(next) || _isSpaceRune(prev) || _isPunctRune(prev))
+	right := !_isSpaceRune(prev) && (!_isPunctRune(prev) || _isSpaceRune(next) || _isPunctRune(next))
+	return left, right
+}
+
+// whether a run of symbol between prev and next can open and close emphasis, '_' doesn't work inside words
+func _delimiterRun(symbol byte, prev rune, next rune) (bool, bool) {
+	left, right := _flanking(prev, next)
+	if symbol == '*' {
+		return left, right
+	}
+	return left && (!right || _isPunctRune(prev)), right && (!left || _isPunctRune(next))
+}
+
+// a run of '*' or '_' in the text of a paragraph
+type delimiterRun struct {
+	start, size int
+	// delimiters not taken by the nodes closed so far
+	left              int
+	canOpen, canClose bool
+	// index of the previous run still able to open
+	prev int
+}
+
+// whether the opener can be closed by closer, the sum of their sizes can't be a multiple
+// of 3 if one of them can both open and close, unless both sizes are
+func (opener *delimiterRun) matches(closer *delimiterRun) bool {
+	if !(opener.canOpen && opener.canClose) && !(closer.canOpen && closer.canClose) {
+		return true
+	}
+	return (opener.size+closer.size)%3 != 0 || (opener.size%3 == 0 && closer.size%3 == 0)
+}
+
+// emphasis node of n delimiters around the text, the offsets are in the cached text
+type emphasisMatch struct {
+	n, end int
+}
+
+// delimiter runs of the text outside code spans
+func (scan *scanCache) delimiterRuns() []delimiterRun {
+	var runs []delimiterRun
+	s := scan.s
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '\\':
+			i += 2
+			continue
+		case '`':
+			run, end := scan.findBacktickRun(s[i:], scan.base+i)
+			if end >= 0 {
+				run += end
+			}
+			i += run
+			continue
+		case '*', '_':
+			size := len(s[i:]) - len(strings.TrimLeft(s[i:], s[i:i+1]))
+			before, after := rune(0), rune(0)
+			if i > 0 {
+				before, _ = utf8.DecodeLastRuneInString(s[:i])
+			}
+			if i+size < len(s) {
+				after, _ = utf8.DecodeRuneInString(s[i+size:])
+			}
+			canOpen, canClose := _delimiterRun(s[i], before, after)
+			if canOpen || canClose {
+				runs = append(runs, delimiterRun{start: i, size: size, left: size, canOpen: canOpen, canClose: canClose})
+			}
+			i += size
+			continue
+		}
+		i += 1
+	}
+	return runs
+}
+
+// emphasis nodes of the text by the delimiter algorithm of CommonMark, by their starts.
+// A closer takes delimiters from the closest opener it matches, 2 if both have 2 or more,
+// and the runs between them can't open any more.
+func (scan *scanCache) emphasisMatches() map[int]emphasisMatch {
+	if scan.emphasis != nil {
+		return scan.emphasis
+	}
+	scan.emphasis = make(map[int]emphasisMatch)
+	runs := scan.delimiterRuns()
+	// openers before bottom can't be matched by closers of the key: the symbol,
+	// whether the closer can open and its size modulo 3
+	bottom := map[[3]int]int{}
+	lastOpener := -1
+	for i := range runs {
+		closer := &runs[i]
+		closer.prev = lastOpener
+		if closer.canClose {
+			key := [3]int{int(scan.s[closer.start]), 0, closer.size % 3}
+			if closer.canOpen {
+				key[1] = 1
+			}
+			floor, ok := bottom[key]
+			if !ok {
+				floor = -1
+			}
+			for closer.left > 0 {
+				j := closer.prev
+				for j > floor && (scan.s[runs[j].start] != scan.s[closer.start] || !runs[j].matches(closer)) {
+					j = runs[j].prev
+				}
+				if j <= floor {
+					bottom[key] = closer.prev
+					break
+				}
+				opener := &runs[j]
+				n := 1
+				if opener.left >= 2 && closer.left >= 2 {
+					n = 2
+				}
+				opener.left -= n
+				start := opener.start + opener.left
+				end := closer.start + closer.size - closer.left + n
+				scan.emphasis[start] = emphasisMatch{n: n, end: end}
+				closer.left -= n
+				// the openers between can't open any more
+				closer.prev = j
+				if opener.left == 0 {
+					closer.prev = opener.prev
+				}
+			}
+		}
+		if closer.canOpen && closer.left > 0 {
+			lastOpener = i
+		} else {
+			lastOpener = closer.prev
+		}
+	}
+	return scan.emphasis
+}
+
+/*
+ * *italic* **emphasis** ***both***
+ * Delimiter runs open and close by the flanking rules of CommonMark, a run of more than 2
+ * delimiters is a node of 1 or 2 of them around nodes of the rest.
+ */
+func parseFlankingEmphasis(s string, ctx ParseContext) *AstNode {
+	if len(s) < 3 || (s[0] != '*' && s[0] != '_') {
+		return nil
+	}
+	scan := ctx.scan
+	i := scan.index(s, ctx.P.Offset)
+	if i < 0 {
+		scan, i = newScanCache(s, ctx.P.Offset), 0
+	}
+	match, ok := scan.emphasisMatches()[i]
+	if !ok {
+		return nil
+	}
+	end := match.end - i
+	endPos := ctx.P
+	endPos.ConsumeStr(s[:end])
+	node := &AstNode{
+		Type:        &Italic{},
+		Start:       ctx.P,
+		End:         endPos,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+	if match.n == 2 {
+		node.Type = &Emphasis{}
+	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, match.n))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(endPos, match.n))
+	curCtx := ctx
+	curCtx.P.ConsumeStr(s[:match.n])
+	curCtx.Parent = node
+	curCtx.LeftSibling = nil
+	node.Children = append(node.Children, _textOrEmpty(s[match.n:end-match.n], curCtx))
+	return node
+}
+
+// spaces and tabs from s[i], at most one line break among them
+func _skipLinkSpace(s string, i int) int {
+	lineBreak := false
+	for ; i < len(s); i++ {
+		if s[i] == '\n' && !lineBreak {
+			lineBreak = true
+		} else if s[i] != ' ' && s[i] != '\t' {
+			break
+		}
+	}
+	return i
+}
+
+const maxLinkParenDepth = 32
+
+// <destination> "title" ) | destination 'title' ) | destination (title) ) like CommonMark, s follows the '('.
+// The offsets of the destination and the title are those of _parseLinkTitleOffsets, the destination
+// may contain balanced parentheses. The last result is the index of the closing ')'.
+func _parseLinkDestination(s string) (bool, [4]int, int) {
+	var offsets [4]int
+	i := _skipLinkSpace(s, 0)
+	if i < len(s) && s[i] == '<' {
+		j := i + 1
+		for ; j < len(s) && s[j] != '>'; j++ {
+			if s[j] == '\n' || s[j] == '<' {
+				return false, offsets, -1
+			} else if s[j] == '\\' {
+				j += 1
+			}
+		}
+		if j >= len(s) {
+			return false, offsets, -1
+		}
+		offsets[0], offsets[1] = i+1, j
+		i = j + 1
+	} else {
+		depth, j := 0, i
+		for ; j < len(s); j++ {
+			if s[j] == '\\' && j+1 < len(s) && s[j+1] > ' ' {
+				j += 1
+			} else if s[j] == '(' {
+				// nesting is limited like cmark, the destination can't run over the rest of the text
+				if depth += 1; depth > maxLinkParenDepth {
+					return false, offsets, -1
+				}
+			} else if s[j] == ')' && depth > 0 {
+				depth -= 1
+			} else if s[j] == ')' || s[j] <= ' ' {
+				break
+			}
+		}
+		if depth > 0 {
+			return false, offsets, -1
+		}
+		offsets[0], offsets[1] = i, j
+		i = j
+	}
+	offsets[2], offsets[3] = offsets[1], offsets[1]
+	j := _skipLinkSpace(s, i)
+	if j > i && j < len(s) && (s[j] == '"' || s[j] == '\'' || s[j] == '(') {
+		quote := s[j]
+		if quote == '(' {
+			quote = ')'
+		}
+		k := j + 1
+		for ; k < len(s) && s[k] != quote; k++ {
+			if s[k] == '\\' {
+				k += 1
+			} else if quote == ')' && s[k] == '(' {
+				return false, offsets, -1
+			}
+		}
+		if k >= len(s) || strings.Contains(s[j:k], "\n\n") {
+			return false, offsets, -1
+		}
+		offsets[2], offsets[3] = j+1, k
+		j = _skipLinkSpace(s, k+1)
+	}
+	if j >= len(s) || s[j] != ')' {
+		return false, offsets, -1
+	}
+	return true, offsets, j
+}
+
+// label of references compared case-insensitively with whitespace collapsed
+func _normalizeLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// split the leaf text node at the [label]s of defined references, nil if there is none
+func _splitShortcutReferences(node *AstNode, s string, definitions map[string]bool) []*AstNode {
+	text := s[node.Start.Offset:node.End.Offset]
+	var pieces []*AstNode
+	curPos := node.Start
+	textStart := 0
+	fAddText := func(end int) {
+		if textStart < end {
+			endPos := curPos
+			endPos.ConsumeStr(text[textStart:end])
+			pieces = append(pieces, &AstNode{Type: &Text{}, Start: curPos, End: endPos})
+			curPos = endPos
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' {
+			i += 1
+			continue
+		}
+		if text[i] != '[' || (i > 0 && text[i-1] == '!') {
+			continue
+		}
+		rbr := _findInLine(text[i+1:], "]")
+		if rbr < 0 {
+			continue
+		}
+		label := text[i+1 : i+1+rbr]
+		if _isBlankLine(label) || strings.Contains(label, "[") || !definitions[_normalizeLabel(label)] {
+			continue
+		}
+		fAddText(i)
+		endPos := curPos
+		endPos.ConsumeStr(text[i : i+rbr+2])
+		link := &AstNode{Type: &ReferenceLink{Index: label}, Start: curPos, End: endPos}
+		link.addSyntax(TokenMarker, _asciiRange(curPos, 1))
+		link.addSyntax(TokenMarker, _asciiRangeBefore(endPos, 1))
+		textEnd := endPos
+		textEnd.Back(']')
+		link.Children = append(link.Children, &AstNode{Type: &Text{}, Start: _asciiRange(curPos, 1).End, End: textEnd, Parent: link})
+		pieces = append(pieces, link)
+		curPos = endPos
+		i += rbr + 1
+		textStart = i + 1
+	}
+	if len(pieces) == 0 {
+		return nil
+	}
+	fAddText(len(text))
+	return pieces
+}
+
+// [label] of a defined reference is a link like CommonMark
+func applyShortcutReferences(ast *Ast, s string) {
+	definitions := map[string]bool{}
+	ast.Root.PreVisit(func(node *AstNode) {
+		if index, ok := node.Type.(*ReferenceLinkIndex); ok {
+			definitions[_normalizeLabel(index.Index)] = true
+		}
+	})
+	if len(definitions) == 0 {
+		return
+	}
+	_visitPlainText(&ast.Root, func(node *AstNode) {
+		if pieces := _splitShortcutReferences(node, s, definitions); pieces != nil {
+			_spliceText(node, pieces)
+		}
+	})
+}
+
+var autolinkRegex = regexp.MustCompile(`(?:https?://|ftp://|www\.)[^\s<]*|[a-zA-Z0-9.+_-]+@[a-zA-Z0-9_-]+(?:\.[a-zA-Z0-9_-]+)+`)
+
+var entitySuffixRegex = regexp.MustCompile(`&[a-zA-Z0-9]+;$`)
+
+// length of the extended autolink at the beginning of link like GFM, 0 if it's not a link
+func _autolinkLen(link string) int {
+	if at := strings.IndexByte(link, '@'); at >= 0 && !strings.Contains(link, "://") && !strings.HasPrefix(link, "www.") {
+		if last := link[len(link)-1]; last == '-' || last == '_' {
+			return 0
+		}
+		return len(link)
+	}
+	domainStart := 0
+	if !strings.HasPrefix(link, "www.") {
+		domainStart = strings.Index(link, "://") + len("://")
+	}
+	domainEnd := domainStart
+	for domainEnd < len(link) && (_isWordRune(rune(link[domainEnd])) || link[domainEnd] == '-' || link[domainEnd] == '.') {
+		domainEnd += 1
+	}
+	segments := strings.Split(strings.TrimRight(link[domainStart:domainEnd], "."), ".")
+	if len(segments) < 2 {
+		return 0
+	}
+	for _, segment := range segments[len(segments)-2:] {
+		if len(segment) == 0 || strings.Contains(segment, "_") {
+			return 0
+		}
+	}
+	// trailing punctuation, unbalanced ')' and entity references aren't a part of the link
+	for len(link) > domainStart {
+		last := link[len(link)-1]
+		if strings.IndexByte("?!.,:*_~'\"", last) >= 0 {
+			link = link[:len(link)-1]
+		} else if last == ')' && strings.Count(link, ")") > strings.Count(link, "(") {
+			link = link[:len(link)-1]
+		} else if loc := entitySuffixRegex.FindStringIndex(link); last == ';' && loc != nil {
+			link = link[:loc[0]]
+		} else {
+			break
+		}
+	}
+	if len(link) <= domainStart {
+		return 0
+	}
+	return len(link)
+}
+
+// split the leaf text node at the urls, www. links and emails like the autolinks of GFM, nil if there is none
+func _splitAutolinks(node *AstNode, s string) []*AstNode {
+	text := s[node.Start.Offset:node.End.Offset]
+	var pieces []*AstNode
+	curPos := node.Start
+	fAddPiece := func(tp AstNodeType, str string) *AstNode {
+		endPos := curPos
+		endPos.ConsumeStr(str)
+		piece := &AstNode{Type: tp, Start: curPos, End: endPos}
+		pieces = append(pieces, piece)
+		curPos = endPos
+		return piece
+	}
+	textStart := 0
+	for _, loc := range autolinkRegex.FindAllStringIndex(text, -1) {
+		if loc[0] < textStart {
+			continue
+		}
+		prev, _ := utf8.DecodeLastRuneInString(text[:loc[0]])
+		if loc[0] > 0 && !_isSpaceRune(prev) && strings.IndexRune("*_~(", prev) < 0 {
+			continue
+		}
+		n := _autolinkLen(text[loc[0]:loc[1]])
+		if n == 0 {
+			continue
+		}
+		if textStart < loc[0] {
+			fAddPiece(&Text{}, text[textStart:loc[0]])
+		}
+		fAddPiece(&SimpleLink{Link: text[loc[0] : loc[0]+n]}, text[loc[0]:loc[0]+n])
+		textStart = loc[0] + n
+	}
+	if len(pieces) == 0 {
+		return nil
+	}
+	if textStart < len(text) {
+		fAddPiece(&Text{}, text[textStart:])
+	}
+	return pieces
+}
+
+// urls, www. links and emails in text are links like the autolinks extension of GFM
+func applyAutolinks(ast *Ast, s string) {
+	_visitPlainText(&ast.Root, func(node *AstNode) {
+		if pieces := _splitAutolinks(node, s); pieces != nil {
+			_spliceText(node, pieces)
+		}
+	})
+}
+
+// add the parsers of names, a parser of overrides is added in place of the default one of its name
+// for the same lookaheads
+func (parser *MKParser) addProfileInlineParsers(names []string, overrides map[string]InlineParser) {
+	for i := len(names) - 1; i >= 0; i-- {
+		method, ok := overrides[names[i]]
+		if !ok {
+			parser.addDefaultInlineParser(names[i])
+			continue
+		}
+		defaults := GetBaseParser()
+		defaults.addDefaultInlineParser(names[i])
+		lookAheads := make([]rune, 0, len(defaults.InlineParserSeq))
+		for lookAhead := range defaults.InlineParserSeq {
+			lookAheads = append(lookAheads, lookAhead)
+		}
+		sort.Slice(lookAheads, func(i, j int) bool { return lookAheads[i] < lookAheads[j] })
+		for _, lookAhead := range lookAheads {
+			parser.appendInlineParser(names[i], lookAhead, method)
+		}
+	}
+}
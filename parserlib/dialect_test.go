@@ -0,0 +1,135 @@
+package parserlib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetextHeader(t *testing.T) {
+	parser := GetProfileParser(ProfileCommonMark)
+	ast := parser.Parse("Foo *bar*\nbaz\n===\n\nqux\n---\n")
+	assert.Nil(t, ast.Validate())
+	assert.Equal(t, 2, len(ast.Root.Children))
+	header := ast.Root.Children[0].Type.(*Header)
+	assert.Equal(t, uint32(1), header.Level)
+	assert.Equal(t, "Foo *bar*\nbaz", ast.Root.Children[0].Children[0].Text())
+	assert.Equal(t, uint32(2), ast.Root.Children[1].Type.(*Header).Level)
+	assert.Equal(t, "<h1>Foo <em>bar</em>\nbaz</h1>\n<h2>qux</h2>\n", RenderHTML(&ast))
+
+	// a thematic break or a list item interrupts the paragraph, not the heading
+	ast = parser.Parse("# Foo\n---\n")
+	assert.Equal(t, "<h1>Foo</h1>\n<hr />\n", RenderHTML(&ast))
+	ast = parser.Parse("Foo\n\n===\n")
+	assert.Equal(t, "<p>Foo</p>\n<p>===</p>\n", RenderHTML(&ast))
+
+	classic := GetProfileParser(ProfileClassic)
+	ast = classic.Parse("Foo\n===\n")
+	assert.Equal(t, 0, strings.Count(RenderHTML(&ast), "<h1>"))
+}
+
+func TestIndentedCode(t *testing.T) {
+	parser := GetProfileParser(ProfileCommonMark)
+	ast := parser.Parse("    a\n      b\n\n\tc\n\n\npara\n")
+	assert.Nil(t, ast.Validate())
+	code := ast.Root.Children[0]
+	assert.Equal(t, uint32(4), code.Type.(*CodeBlock).Indent)
+	assert.Equal(t, "a\n  b\n\nc\n", code.Literal())
+	assert.Equal(t, "<pre><code>a\n  b\n\nc\n</code></pre>\n<p>para</p>\n", RenderHTML(&ast))
+
+	// indented lines continue a paragraph
+	ast = parser.Parse("para\n    not code\n")
+	assert.Equal(t, "<p>para\nnot code</p>\n", RenderHTML(&ast))
+}
+
+func TestFlankingEmphasis(t *testing.T) {
+	parser := GetProfileParser(ProfileCommonMark)
+	for _, c := range [][2]string{
+		{"*foo bar*", "<p><em>foo bar</em></p>\n"},
+		{"a * foo bar*", "<p>a * foo bar*</p>\n"},
+		{"foo_bar_", "<p>foo_bar_</p>\n"},
+		{"foo*bar*", "<p>foo<em>bar</em></p>\n"},
+		{"**foo*", "<p>*<em>foo</em></p>\n"},
+		{"***strong emph***", "<p><em><strong>strong emph</strong></em></p>\n"},
+		{"***foo** bar*", "<p><em><strong>foo</strong> bar</em></p>\n"},
+		{"*foo**bar**baz*", "<p><em>foo<strong>bar</strong>baz</em></p>\n"},
+		{"*a `*`*", "<p><em>a <code>*</code></em></p>\n"},
+	} {
+		ast := parser.Parse(c[0])
+		assert.Nil(t, ast.Validate(), c[0])
+		assert.Equal(t, c[1], RenderHTML(&ast), c[0])
+	}
+
+	// runs are matched once per paragraph
+	ast := parser.Parse(strings.Repeat("*a* **", 2000))
+	assert.Nil(t, ast.Validate())
+	assert.Equal(t, 2000, strings.Count(RenderHTML(&ast), "<em>"))
+}
+
+func TestStrictLink(t *testing.T) {
+	parser := GetProfileParser(ProfileCommonMark)
+	for _, c := range [][2]string{
+		{"[a](/u(r)l \"t\")", "<p><a href=\"/u(r)l\" title=\"t\">a</a></p>\n"},
+		{"[a](</my uri>)", "<p><a href=\"/my%20uri\">a</a></p>\n"},
+		{"[a](/url (title))", "<p><a href=\"/url\" title=\"title\">a</a></p>\n"},
+		{"[a](/my uri)", "<p>[a](/my uri)</p>\n"},
+		{"[a](\\(foo\\))", "<p><a href=\"(foo)\">a</a></p>\n"},
+		{"[a][B c]\n\n[b  C]: /url\n", "<p><a href=\"/url\">a</a></p>\n"},
+		{"[foo][]\n\n[foo]: /url\n", "<p><a href=\"/url\">foo</a></p>\n"},
+		{"[foo] [bar]\n\n[foo]: /url\n", "<p><a href=\"/url\">foo</a> [bar]</p>\n"},
+		{"\\[foo]\n\n[foo]: /url\n", "<p>[foo]</p>\n"},
+	} {
+		ast := parser.Parse(c[0])
+		assert.Nil(t, ast.Validate(), c[0])
+		assert.Equal(t, c[1], RenderHTML(&ast), c[0])
+	}
+	ast := parser.Parse(strings.Repeat("[a](", 2000))
+	assert.Nil(t, ast.Validate())
+}
+
+func TestAutolink(t *testing.T) {
+	parser := GetProfileParser(ProfileGFM)
+	for _, c := range [][2]string{
+		{"Visit www.commonmark.org/help.", "<p>Visit <a href=\"http://www.commonmark.org/help\">www.commonmark.org/help</a>.</p>\n"},
+		{"(https://a.b/c(d))", "<p>(<a href=\"https://a.b/c(d)\">https://a.b/c(d)</a>)</p>\n"},
+		{"mail foo@bar.baz.", "<p>mail <a href=\"mailto:foo@bar.baz\">foo@bar.baz</a>.</p>\n"},
+		{"www.a_b.c", "<p>www.a_b.c</p>\n"},
+		{"`www.a.b`", "<p><code>www.a.b</code></p>\n"},
+	} {
+		ast := parser.Parse(c[0])
+		assert.Nil(t, ast.Validate(), c[0])
+		assert.Equal(t, c[1], RenderHTML(&ast), c[0])
+	}
+
+	commonMark := GetProfileParser(ProfileCommonMark)
+	ast := commonMark.Parse("www.a.b")
+	assert.Equal(t, "<p>www.a.b</p>\n", RenderHTML(&ast))
+}
+
+func TestStrictTable(t *testing.T) {
+	parser := GetProfileParser(ProfileGFM)
+	ast := parser.Parse("| a | b |\n| :- | -: |\n| 1 | 2 |\n> quote\n")
+	assert.Nil(t, ast.Validate())
+	assert.Equal(t, 2, len(ast.Root.Children))
+	assert.Equal(t, "Table", GetNodeTypeName(ast.Root.Children[0].Type))
+	assert.Equal(t, 1, strings.Count(RenderHTML(&ast), "<td>1</td>"))
+	assert.Equal(t, "QuoteBlock", GetNodeTypeName(ast.Root.Children[1].Type))
+
+	// the delimiter row must be made of dashes
+	ast = parser.Parse("| a | b |\n| x | - |\n")
+	assert.Equal(t, 0, strings.Count(RenderHTML(&ast), "<table>"))
+
+	// no caption in GFM
+	ast = parser.Parse("| a |\n| - |\n| 1 |\n[caption]\n")
+	assert.Equal(t, 0, strings.Count(RenderHTML(&ast), "<caption>"))
+}
+
+func TestTaskListGroup(t *testing.T) {
+	parser := GetProfileParser(ProfileGFM)
+	ast := parser.Parse("- [ ] a\n- [x] b\n")
+	assert.Nil(t, ast.Validate())
+	assert.Equal(t, 1, len(ast.Root.Children))
+	assert.Equal(t, true, ast.Root.Children[0].Type.(*List).IsTask)
+	assert.Equal(t, true, ast.Root.Children[0].Children[1].Type.(*ListItem).IsFinished)
+}
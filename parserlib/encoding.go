@@ -0,0 +1,213 @@
+package parserlib
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+const (
+	// detect the encoding from the BOM, then try UTF-8, GBK and Windows-1252 in order
+	EncodingAuto uint32 = iota
+	EncodingUTF8
+	EncodingUTF16LE
+	EncodingUTF16BE
+	EncodingLatin1
+	EncodingWindows1252
+	EncodingGBK
+)
+
+// Decoding maps the offsets of the UTF-8 text decoded from the input back to the input
+type Decoding struct {
+	// the encoding of the input, detected if EncodingAuto is given
+	Encoding uint32
+	// input offsets of the invalid sequences replaced by utf8.RuneError
+	Invalid []int
+	// decoded and input offsets of the runes after which the bytes of both are the same
+	decoded []int
+	input   []int
+	// bytes of the input
+	size int
+}
+
+// InputOffset returns the input offset of offset of the decoded text,
+// offsets out of the decoded text are clamped
+func (d *Decoding) InputOffset(offset int) int {
+	k := sort.SearchInts(d.decoded, offset+1) - 1
+	if k < 0 {
+		return 0
+	}
+	inputOffset := d.input[k] + offset - d.decoded[k]
+	if k+1 < len(d.input) && inputOffset > d.input[k+1] {
+		// inside a rune of a different size
+		return d.input[k+1]
+	}
+	if inputOffset > d.size {
+		return d.size
+	}
+	return inputOffset
+}
+
+// the next rune of the non-empty b and its bytes, false if b starts with an invalid sequence
+type runeDecoder func(b []byte) (rune, int, bool)
+
+func _decodeUTF8(b []byte) (rune, int, bool) {
+	r, size := utf8.DecodeRune(b)
+	return r, size, r != utf8.RuneError || size > 1
+}
+
+func _utf16Decoder(bigEndian bool) runeDecoder {
+	unit := func(b []byte) rune {
+		if bigEndian {
+			return rune(b[0])<<8 | rune(b[1])
+		}
+		return rune(b[1])<<8 | rune(b[0])
+	}
+	return func(b []byte) (rune, int, bool) {
+		if len(b) < 2 {
+			return utf8.RuneError, len(b), false
+		}
+		r := unit(b)
+		if !utf16.IsSurrogate(r) {
+			return r, 2, true
+		}
+		if len(b) >= 4 {
+			if pair := utf16.DecodeRune(r, unit(b[2:])); pair != utf8.RuneError {
+				return pair, 4, true
+			}
+		}
+		return utf8.RuneError, 2, false
+	}
+}
+
+func _decodeLatin1(b []byte) (rune, int, bool) {
+	return rune(b[0]), 1, true
+}
+
+func _decodeWindows1252(b []byte) (rune, int, bool) {
+	r := charmap.Windows1252.DecodeByte(b[0])
+	return r, 1, r != utf8.RuneError
+}
+
+// bytes of the GBK character b starts with, 0 if b doesn't start with a valid one
+func _gbkSize(b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	if b[0] < 0x80 || b[0] == 0x80 {
+		// ascii and the euro sign
+		return 1
+	}
+	if b[0] == 0xFF || len(b) < 2 || b[1] < 0x40 || b[1] == 0x7F || b[1] == 0xFF {
+		return 0
+	}
+	return 2
+}
+
+func _gbkDecoder() runeDecoder {
+	decoder := simplifiedchinese.GBK.NewDecoder()
+	var buf [utf8.UTFMax]byte
+	return func(b []byte) (rune, int, bool) {
+		if b[0] < 0x80 {
+			return rune(b[0]), 1, true
+		}
+		size := _gbkSize(b)
+		if size == 0 {
+			return utf8.RuneError, 1, false
+		}
+		decoder.Reset()
+		n, _, err := decoder.Transform(buf[:], b[:size], true)
+		r, runeSize := utf8.DecodeRune(buf[:n])
+		if err != nil || n == 0 || runeSize != n || r == utf8.RuneError {
+			return utf8.RuneError, 1, false
+		}
+		return r, size, true
+	}
+}
+
+func _isGBK(b []byte) bool {
+	for i := 0; i < len(b); {
+		size := _gbkSize(b[i:])
+		if size == 0 {
+			return false
+		}
+		i += size
+	}
+	return true
+}
+
+func _detectEncoding(b []byte) uint32 {
+	switch {
+	case bytes.HasPrefix(b, []byte(utf8BOM)):
+		return EncodingUTF8
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	case utf8.Valid(b):
+		return EncodingUTF8
+	case _isGBK(b):
+		return EncodingGBK
+	default:
+		return EncodingWindows1252
+	}
+}
+
+// DecodeBytes decodes b from encoding to UTF-8, EncodingAuto to detect it.
+// Invalid sequences are replaced by utf8.RuneError, the BOM is decoded as U+FEFF.
+func DecodeBytes(b []byte, encoding uint32) (string, *Decoding, error) {
+	if encoding == EncodingAuto {
+		encoding = _detectEncoding(b)
+	}
+	var next runeDecoder
+	switch encoding {
+	case EncodingUTF8:
+		next = _decodeUTF8
+	case EncodingUTF16LE:
+		next = _utf16Decoder(false)
+	case EncodingUTF16BE:
+		next = _utf16Decoder(true)
+	case EncodingLatin1:
+		next = _decodeLatin1
+	case EncodingWindows1252:
+		next = _decodeWindows1252
+	case EncodingGBK:
+		next = _gbkDecoder()
+	default:
+		return "", nil, fmt.Errorf("encoding %d is not supported", encoding)
+	}
+
+	d := &Decoding{Encoding: encoding, decoded: []int{0}, input: []int{0}, size: len(b)}
+	var text strings.Builder
+	text.Grow(len(b))
+	for i := 0; i < len(b); {
+		r, size, ok := next(b[i:])
+		if !ok {
+			r = utf8.RuneError
+			d.Invalid = append(d.Invalid, i)
+		}
+		n, _ := text.WriteRune(r)
+		i += size
+		if n != size {
+			d.decoded = append(d.decoded, text.Len())
+			d.input = append(d.input, i)
+		}
+	}
+	return text.String(), d, nil
+}
+
+// ParseBytes decodes b like DecodeBytes and parses the text.
+// Positions are in the decoded text, Decoding.InputOffset maps them back to b.
+func (parser *MKParser) ParseBytes(b []byte, encoding uint32) (Ast, *Decoding, error) {
+	text, d, err := DecodeBytes(b, encoding)
+	if err != nil {
+		return Ast{Root: AstNode{Type: &Document{}}}, nil, err
+	}
+	return parser.Parse(text), d, nil
+}
@@ -0,0 +1,87 @@
+package parserlib
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestParseBytes(t *testing.T) {
+	parser := GetFullMKParser()
+	text := "# 标题\n\n正文 *强调* café\n"
+	want := parser.Parse(text)
+
+	gbk, err := simplifiedchinese.GBK.NewEncoder().String(text)
+	assert.Nil(t, err)
+	units := utf16.Encode([]rune(utf8BOM + text))
+	utf16LE := make([]byte, 0, 2*len(units))
+	utf16BE := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		utf16LE = append(utf16LE, byte(u), byte(u>>8))
+		utf16BE = append(utf16BE, byte(u>>8), byte(u))
+	}
+
+	for name, tc := range map[string]struct {
+		input    []byte
+		encoding uint32
+		bom      bool
+	}{
+		"utf8":     {[]byte(text), EncodingUTF8, false},
+		"gbk":      {[]byte(gbk), EncodingGBK, false},
+		"utf16le":  {utf16LE, EncodingUTF16LE, true},
+		"utf16be":  {utf16BE, EncodingUTF16BE, true},
+		"utf8 bom": {[]byte(utf8BOM + text), EncodingUTF8, true},
+	} {
+		ast, d, err := parser.ParseBytes(tc.input, EncodingAuto)
+		assert.Nil(t, err, name)
+		assert.Equal(t, tc.encoding, d.Encoding, name)
+		assert.Empty(t, d.Invalid, name)
+		assert.Nil(t, ast.Validate(), name)
+		src := ast.Source()
+		if tc.bom {
+			assert.True(t, strings.HasPrefix(src, utf8BOM), name)
+			src = src[len(utf8BOM):]
+		}
+		assert.Equal(t, text, src, name)
+		assert.Equal(t, len(want.Root.Children), len(ast.Root.Children), name)
+
+		// offsets map to the same text in the input
+		decoded, _, _ := DecodeBytes(tc.input, tc.encoding)
+		ast.Root.PreVisit(func(node *AstNode) {
+			start, end := d.InputOffset(node.Start.Offset), d.InputOffset(node.End.Offset)
+			part, _, _ := DecodeBytes(tc.input[start:end], tc.encoding)
+			assert.Equal(t, decoded[node.Start.Offset:node.End.Offset], part, name)
+		})
+		assert.Equal(t, len(tc.input), d.InputOffset(len(ast.Source())), name)
+	}
+
+	// Windows-1252 and Latin-1 differ in 0x80-0x9F
+	_, d, err := parser.ParseBytes([]byte("caf\xe9 \x80"), EncodingAuto)
+	assert.Nil(t, err)
+	assert.Equal(t, EncodingWindows1252, d.Encoding)
+	ast, d, err := parser.ParseBytes([]byte("caf\xe9 \x80\n"), EncodingWindows1252)
+	assert.Nil(t, err)
+	assert.Equal(t, "café €\n", ast.Source())
+	assert.Equal(t, 5, d.InputOffset(len("café ")))
+	ast, _, err = parser.ParseBytes([]byte("caf\xe9 \x80\n"), EncodingLatin1)
+	assert.Nil(t, err)
+	assert.Equal(t, "café \u0080\n", ast.Source())
+
+	// invalid sequences are replaced and recorded
+	ast, d, err = parser.ParseBytes([]byte("a\xffb\xc3"), EncodingUTF8)
+	assert.Nil(t, err)
+	assert.Equal(t, "a"+string(utf8.RuneError)+"b"+string(utf8.RuneError), ast.Source())
+	assert.Equal(t, []int{1, 3}, d.Invalid)
+	assert.Equal(t, 2, d.InputOffset(4))
+	assert.Equal(t, Pos{Line: 0, Col: 4, Offset: 8}, ast.Root.End)
+	_, d, err = parser.ParseBytes([]byte{'a', 0, 0x00, 0xD8, 'b'}, EncodingUTF16LE)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 4}, d.Invalid)
+
+	_, _, err = parser.ParseBytes([]byte("a"), 100)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,125 @@
+package parserlib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// FrozenParser is a snapshot of an MKParser that can't be changed anymore,
+// so it's safe for concurrent use. MKParser acts as its builder.
+type FrozenParser struct {
+	parser MKParser
+}
+
+// Freeze copies the parsers added so far, later changes of parser don't affect the result
+func (parser *MKParser) Freeze() *FrozenParser {
+	frozen := &FrozenParser{}
+	frozen.parser.BlockParserSeq = append([]BlockParser{}, parser.BlockParserSeq...)
+	frozen.parser.InlineParserSeq = make(map[rune][]InlineParser, len(parser.InlineParserSeq))
+	for c, parsers := range parser.InlineParserSeq {
+		frozen.parser.InlineParserSeq[c] = append([]InlineParser{}, parsers...)
+	}
+	frozen.parser.PostParserSeq = append([]PostParser{}, parser.PostParserSeq...)
+	frozen.parser.postParserNames = parser.PostParserNames()
+	frozen.parser.tracer = parser.tracer
+	// both are copies in the order of the sequences
+	frozen.parser.blockParserNames = _reversedNames(parser.BlockParserNames())
+	frozen.parser.inlineParserNames = make(map[rune][]string, len(parser.InlineParserSeq))
+	for c := range parser.InlineParserSeq {
+		frozen.parser.inlineParserNames[c] = _reversedNames(parser.InlineParserNames(c))
+	}
+	return frozen
+}
+
+func (frozen *FrozenParser) BlockParserNames() []string {
+	return frozen.parser.BlockParserNames()
+}
+
+func (frozen *FrozenParser) InlineParserNames(lookAhead rune) []string {
+	return frozen.parser.InlineParserNames(lookAhead)
+}
+
+func (frozen *FrozenParser) PostParserNames() []string {
+	return frozen.parser.PostParserNames()
+}
+
+func (frozen *FrozenParser) Parse(s string) Ast {
+	return frozen.parser.Parse(s)
+}
+
+func (frozen *FrozenParser) ParseWithDiagnostics(s string) (Ast, []Diagnostic, error) {
+	return frozen.parser.ParseWithDiagnostics(s)
+}
+
+func (frozen *FrozenParser) ParseWithLimits(ctx context.Context, s string, limits Limits) (Ast, error) {
+	return frozen.parser.ParseWithLimits(ctx, s, limits)
+}
+
+func (frozen *FrozenParser) ParseLazy(s string) Ast {
+	return frozen.parser.ParseLazy(s)
+}
+
+func (frozen *FrozenParser) ParseCompact(s string) (*CompactAst, error) {
+	return frozen.parser.ParseCompact(s)
+}
+
+func (frozen *FrozenParser) ParseBytes(b []byte, encoding uint32) (Ast, *Decoding, error) {
+	return frozen.parser.ParseBytes(b, encoding)
+}
+
+func (frozen *FrozenParser) ParseReader(r io.Reader, emit func(*AstNode) error) error {
+	return frozen.parser.ParseReader(r, emit)
+}
+
+func (frozen *FrozenParser) ParseParallel(s string, workers int) Ast {
+	return frozen.parser.ParseParallel(s, workers)
+}
+
+// ParseMany parses docs on workers goroutines, GOMAXPROCS if workers <= 0, the i-th ast is parsed from docs[i].
+// It stops when ctx is done and returns ctx.Err(), asts of the documents not parsed are empty.
+// Otherwise the error is the first internal failure reported by ParseWithDiagnostics.
+func (frozen *FrozenParser) ParseMany(ctx context.Context, docs []string, workers int) ([]Ast, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	asts := make([]Ast, len(docs))
+	errs := make([]error, len(docs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				asts[i], _, errs[i] = frozen.parser.ParseWithDiagnostics(docs[i])
+			}
+		}()
+	}
+	for i := range docs {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return asts, err
+	}
+	for i, err := range errs {
+		if err != nil {
+			return asts, fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+	return asts, nil
+}
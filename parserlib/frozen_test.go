@@ -0,0 +1,49 @@
+package parserlib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrozenParser(t *testing.T) {
+	parser := GetFullMKParser()
+	frozen := parser.Freeze()
+	parser.AddExtensionBlockParser(func(s string, ctx ParseContext) *AstNode {
+		if strings.HasPrefix(s, "!!") {
+			panic("extension failed")
+		}
+		return nil
+	})
+
+	docs := make([]string, 100)
+	for i := range docs {
+		docs[i] = fmt.Sprintf("# doc %d\n\n!!text *%d*\n\n- a\n- b\n", i, i)
+	}
+	asts, err := frozen.ParseMany(context.Background(), docs, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(docs), len(asts))
+	for i, ast := range asts {
+		expected := frozen.Parse(docs[i])
+		assert.Equal(t, expected.String(), ast.String())
+		assert.Equal(t, docs[i], ast.Source())
+	}
+
+	single, err := frozen.ParseMany(context.Background(), docs, 1)
+	assert.Equal(t, nil, err)
+	for i, ast := range single {
+		assert.Equal(t, asts[i].String(), ast.String())
+	}
+
+	_, err = parser.Freeze().ParseMany(context.Background(), docs, 0)
+	assert.NotNil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	asts, err = frozen.ParseMany(ctx, docs, 2)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, len(docs), len(asts))
+}
@@ -0,0 +1,304 @@
+package parserlib
+
+import (
+	"fmt"
+	"strings"
+)
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+func _escapeHTML(s string) string {
+	return htmlEscaper.Replace(s)
+}
+
+// remove backslashes escaping ascii punctuations
+func _unescapeMarkdown(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", s[i+1]) >= 0 {
+			i += 1
+		}
+		builder.WriteByte(s[i])
+	}
+	return builder.String()
+}
+
+// percent-encode the characters of a url that aren't safe in html, existing %XX are kept
+func _encodeHref(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '%' && i+2 < len(s) && _isHexDigit(s[i+1]) && _isHexDigit(s[i+2]) {
+			builder.WriteByte(c)
+		} else if c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(";/?:@&=+$,-_.!~*'()#", c) >= 0) {
+			builder.WriteByte(c)
+		} else {
+			builder.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return builder.String()
+}
+
+func _isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+type htmlRenderer struct {
+	// definitions of references by their normalized labels
+	refs    map[string]*ReferenceLinkIndex
+	builder strings.Builder
+}
+
+// RenderHTML renders the ast as HTML in the style of the CommonMark spec examples
+func RenderHTML(ast *Ast) string {
+	renderer := &htmlRenderer{refs: map[string]*ReferenceLinkIndex{}}
+	ast.Root.PreVisit(func(node *AstNode) {
+		if index, ok := node.Type.(*ReferenceLinkIndex); ok {
+			if _, ok := renderer.refs[_normalizeLabel(index.Index)]; !ok {
+				renderer.refs[_normalizeLabel(index.Index)] = index
+			}
+		}
+	})
+	renderer.blocks(ast.Root.Children)
+	return renderer.builder.String()
+}
+
+// inline content of a paragraph, heading or cell without surrounding spaces
+func (renderer *htmlRenderer) paragraph(node *AstNode) string {
+	lines := strings.Split(renderer.inline(node), "\n")
+	for i := range lines {
+		lines[i] = strings.Trim(lines[i], " \t")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+func (renderer *htmlRenderer) inlines(nodes []*AstNode) string {
+	var builder strings.Builder
+	for _, node := range nodes {
+		builder.WriteString(renderer.inline(node))
+	}
+	return builder.String()
+}
+
+func (renderer *htmlRenderer) inline(node *AstNode) string {
+	switch tp := node.Type.(type) {
+	case *Text:
+		if children := node.Inlines(); len(children) > 0 {
+			return renderer.inlines(children)
+		}
+		return _escapeHTML(_unescapeMarkdown(node.Text()))
+	case *Emphasis:
+		return "<strong>" + renderer.delimited(node, 2) + "</strong>"
+	case *Italic:
+		return "<em>" + renderer.delimited(node, 1) + "</em>"
+	case *StrikeThrough:
+		return "<del>" + renderer.inlines(node.Children) + "</del>"
+	case *Code:
+		code := node.Literal()
+		code = strings.ReplaceAll(code, "\n", " ")
+		if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && len(strings.Trim(code, " ")) > 0 {
+			code = code[1 : len(code)-1]
+		}
+		return "<code>" + _escapeHTML(code) + "</code>"
+	case *Math:
+		return `<span class="math">` + _escapeHTML(node.Literal()) + "</span>"
+	case *Link:
+		return renderer.link(_unescapeMarkdown(tp.Link), _unescapeMarkdown(tp.Title), renderer.inlines(node.Children))
+	case *SimpleLink:
+		href := tp.Link
+		if strings.HasPrefix(href, "www.") {
+			href = "http://" + href
+		} else if !strings.Contains(href, "://") && strings.Contains(href, "@") {
+			href = "mailto:" + href
+		}
+		return renderer.link(href, "", _escapeHTML(tp.Link))
+	case *ReferenceLink:
+		index, ok := renderer.refs[_normalizeLabel(tp.Index)]
+		if !ok {
+			return _escapeHTML(node.Text())
+		}
+		return renderer.link(index.Link, index.Title, renderer.inlines(node.Children))
+	case *Image:
+		var alt strings.Builder
+		for _, ch := range node.Children {
+			if _, ok := ch.Type.(*Label); !ok {
+				alt.WriteString(_unescapeMarkdown(ch.Text()))
+			}
+		}
+		img := `<img src="` + _escapeHTML(_encodeHref(_unescapeMarkdown(tp.Link))) + `" alt="` + _escapeHTML(alt.String()) + `"`
+		if len(tp.Title) > 0 {
+			img += ` title="` + _escapeHTML(_unescapeMarkdown(tp.Title)) + `"`
+		}
+		return img + " />"
+	case *HtmlStartTag, *HtmlEndTag:
+		return node.Text()
+	case *FootNote:
+		return fmt.Sprintf(`<sup class="footnote-ref"><a href="#fn-%s">%s</a></sup>`, _escapeHTML(tp.Index), _escapeHTML(tp.Index))
+	case *InlineFootNote:
+		return `<sup class="footnote">` + renderer.inlines(node.Children) + "</sup>"
+	case *Abbreviation:
+		return `<abbr title="` + _escapeHTML(tp.Definition) + `">` + _escapeHTML(tp.Abbr) + "</abbr>"
+	case *Label:
+		return ""
+	}
+	if len(node.Children) > 0 {
+		return renderer.inlines(node.Children)
+	}
+	return _escapeHTML(node.Text())
+}
+
+// content between delimiters of length n, it's not parsed if the node has no children
+func (renderer *htmlRenderer) delimited(node *AstNode, n int) string {
+	if len(node.Children) > 0 {
+		return renderer.inlines(node.Children)
+	}
+	text := node.Text()
+	return _escapeHTML(_unescapeMarkdown(text[n : len(text)-n]))
+}
+
+func (renderer *htmlRenderer) link(href string, title string, content string) string {
+	a := `<a href="` + _escapeHTML(_encodeHref(href)) + `"`
+	if len(title) > 0 {
+		a += ` title="` + _escapeHTML(title) + `"`
+	}
+	return a + ">" + content + "</a>"
+}
+
+func (renderer *htmlRenderer) blocks(nodes []*AstNode) {
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[i]
+		if quote, ok := node.Type.(*QuoteBlock); ok {
+			// every line of a quote is a QuoteBlock
+			var lines []string
+			for ; i < len(nodes); i++ {
+				next, ok := nodes[i].Type.(*QuoteBlock)
+				if !ok || next.Level != quote.Level {
+					break
+				}
+				lines = append(lines, renderer.paragraph(nodes[i]))
+			}
+			i -= 1
+			renderer.builder.WriteString(strings.Repeat("<blockquote>\n", int(quote.Level)))
+			if content := strings.Trim(strings.Join(lines, "\n"), "\n"); len(content) > 0 {
+				renderer.builder.WriteString("<p>" + content + "</p>\n")
+			}
+			renderer.builder.WriteString(strings.Repeat("</blockquote>\n", int(quote.Level)))
+			continue
+		}
+		renderer.block(node)
+	}
+}
+
+func (renderer *htmlRenderer) block(node *AstNode) {
+	out := &renderer.builder
+	switch tp := node.Type.(type) {
+	case *Text:
+		if content := renderer.paragraph(node); len(content) > 0 {
+			out.WriteString("<p>" + content + "</p>\n")
+		}
+	case *Header:
+		out.WriteString(fmt.Sprintf("<h%d>%s</h%d>\n", tp.Level, renderer.paragraph(node), tp.Level))
+	case *HorizontalRule:
+		out.WriteString("<hr />\n")
+	case *CodeBlock:
+		content := node.Literal()
+		out.WriteString("<pre><code")
+		if info := strings.Fields(tp.Suffix); len(info) > 0 {
+			out.WriteString(` class="language-` + _escapeHTML(_unescapeMarkdown(info[0])) + `"`)
+		}
+		out.WriteString(">" + _escapeHTML(content) + "</code></pre>\n")
+	case *MathBlock:
+		body := strings.TrimSpace(node.Text())
+		body = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(body, "$$"), "$$"))
+		out.WriteString(`<div class="math">` + _escapeHTML(body) + "</div>\n")
+	case *List:
+		tag := "ul"
+		if tp.IsOrdered {
+			tag = "ol"
+		}
+		out.WriteString("<" + tag)
+		if len(node.Children) > 0 {
+			if item := node.Children[0].Type.(*ListItem); item.IsOrdered && item.Order != 1 {
+				out.WriteString(fmt.Sprintf(` start="%d"`, item.Order))
+			}
+		}
+		out.WriteString(">\n")
+		for _, ch := range node.Children {
+			item := ch.Type.(*ListItem)
+			out.WriteString("<li>")
+			if item.IsTask {
+				if item.IsFinished {
+					out.WriteString(`<input checked="" disabled="" type="checkbox"> `)
+				} else {
+					out.WriteString(`<input disabled="" type="checkbox"> `)
+				}
+			}
+			out.WriteString(renderer.paragraph(ch) + "</li>\n")
+		}
+		out.WriteString("</" + tag + ">\n")
+	case *Table:
+		renderer.table(node)
+	case *FootNoteIndex:
+		out.WriteString(`<div class="footnote" id="fn-` + _escapeHTML(tp.Index) + `">` + "\n")
+		if len(node.Children) > 0 {
+			if content := renderer.paragraph(node.Children[0]); len(content) > 0 {
+				out.WriteString("<p>" + content + "</p>\n")
+			}
+			renderer.blocks(node.Children[1:])
+		}
+		out.WriteString("</div>\n")
+	case *ReferenceLinkIndex, *AbbreviationIndex:
+	default:
+		out.WriteString(renderer.inline(node))
+	}
+}
+
+func (renderer *htmlRenderer) table(node *AstNode) {
+	out := &renderer.builder
+	var aligns []uint32
+	for _, ch := range node.Children {
+		if align, ok := ch.Type.(*TableAlign); ok {
+			aligns = align.Aligns
+		}
+	}
+	fRow := func(row *AstNode, tag string) {
+		out.WriteString("<tr>\n")
+		for i, cell := range row.Children {
+			out.WriteString("<" + tag)
+			if i < len(aligns) && aligns[i] == AlignMiddle {
+				out.WriteString(` align="center"`)
+			} else if i < len(aligns) && aligns[i] == AlignRight {
+				out.WriteString(` align="right"`)
+			}
+			out.WriteString(">" + renderer.paragraph(cell) + "</" + tag + ">\n")
+		}
+		out.WriteString("</tr>\n")
+	}
+
+	out.WriteString("<table>\n")
+	for _, ch := range node.Children {
+		// the caption may be placed after the table in markdown
+		if _, ok := ch.Type.(*TableCaption); ok {
+			out.WriteString("<caption>" + renderer.paragraph(ch.Children[0]) + "</caption>\n")
+		}
+	}
+	bodyStarted := false
+	for _, ch := range node.Children {
+		switch ch.Type.(type) {
+		case *TableHead:
+			out.WriteString("<thead>\n")
+			fRow(ch, "th")
+			out.WriteString("</thead>\n")
+		case *TableLine:
+			if !bodyStarted {
+				out.WriteString("<tbody>\n")
+				bodyStarted = true
+			}
+			fRow(ch, "td")
+		}
+	}
+	if bodyStarted {
+		out.WriteString("</tbody>\n")
+	}
+	out.WriteString("</table>\n")
+}
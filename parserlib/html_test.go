@@ -0,0 +1,19 @@
+package parserlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderHTML(t *testing.T) {
+	parser := GetFullMKParser()
+	ast := parser.Parse("# title\n\n**a** *b* ~~c~~ `d<e`\n\n> quote\n> next\n\n- [x] done\n- [ ] todo\n\n[ref][1] and [^n]\n\n[1]: /url \"t\"\n[^n]: note\n")
+	t.Logf(ast.String())
+	assert.Equal(t, "<h1>title</h1>\n"+
+		"<p><strong>a</strong> <em>b</em> <del>c</del> <code>d&lt;e</code></p>\n"+
+		"<blockquote>\n<p>quote\nnext</p>\n</blockquote>\n"+
+		"<ul>\n<li><input checked=\"\" disabled=\"\" type=\"checkbox\"> done</li>\n<li><input disabled=\"\" type=\"checkbox\"> todo</li>\n</ul>\n"+
+		"<p><a href=\"/url\" title=\"t\">ref</a> and <sup class=\"footnote-ref\"><a href=\"#fn-n\">n</a></sup></p>\n"+
+		"<div class=\"footnote\" id=\"fn-n\">\n<p>note</p>\n</div>\n", RenderHTML(&ast))
+}
@@ -0,0 +1,96 @@
+package parserlib
+
+import (
+	"strings"
+	"sync"
+)
+
+// inline children of a text node parsed on demand
+type lazyInlines struct {
+	once sync.Once
+	// text of the node and the context it's parsed in
+	s    string
+	ctx  ParseContext
+	text *lazyTextParser
+}
+
+// ParseText of ParseLazy
+type lazyTextParser struct {
+	parser *MKParser
+	// maps the positions back to the source, it's set once the ast is remapped, so
+	// inlines parsed by post parsers stay in the normalized source like the rest of the ast
+	m *sourceMap
+}
+
+// the text node parseText would return, without its children
+func (lazy *lazyTextParser) parseText(s string, ctx ParseContext) *AstNode {
+	node := &AstNode{
+		Type:        &Text{},
+		Start:       ctx.P,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+	text := strings.TrimLeft(s, "\n")
+	if len(text) == 0 {
+		return nil
+	}
+	node.Start.ConsumeStr(s[:len(s)-len(text)])
+	// inline elements don't span paragraphs
+	if idx := strings.Index(text, "\n\n"); idx >= 0 {
+		text = text[:idx+1]
+	}
+	node.End = node.Start
+	node.End.ConsumeStr(text)
+
+	ctx.P = node.Start
+	ctx.ParseText = lazy.parser.parseText
+	ctx.diag = nil
+	ctx.limit = nil
+	ctx.scan = nil
+	node.lazy = &lazyInlines{s: text, ctx: ctx, text: lazy}
+	return node
+}
+
+// Inlines returns the children of the node. The inline children of text nodes parsed by
+// ParseLazy are parsed on the first call, which is safe for concurrent use.
+// Children stays empty until then.
+func (astnode *AstNode) Inlines() []*AstNode {
+	lazy := astnode.lazy
+	if lazy == nil {
+		return astnode.Children
+	}
+	lazy.once.Do(func() {
+		parsed := lazy.ctx.ParseText(lazy.s, lazy.ctx)
+		if parsed == nil {
+			return
+		}
+		for _, ch := range parsed.Children {
+			ch.Parent = astnode
+			if lazy.text.m != nil {
+				lazy.text.m.remap(ch, 0)
+			}
+			_setSource(ch, astnode.src)
+		}
+		astnode.Children = parsed.Children
+	})
+	return astnode.Children
+}
+
+// PreVisit that parses pending inline children on the way
+func (astnode *AstNode) preVisitInlines(f func(*AstNode)) {
+	if astnode == nil {
+		return
+	}
+	f(astnode)
+	for _, ch := range astnode.Inlines() {
+		ch.preVisitInlines(f)
+	}
+}
+
+// ParseLazy parses s like Parse, but text nodes of blocks keep their inline children
+// unparsed until node.Inlines() is called, which makes extracting the block structure
+// much cheaper. Post parsers run on the block structure and may parse inlines themselves.
+// Blocks reparsed by Update are parsed eagerly.
+func (parser *MKParser) ParseLazy(s string) Ast {
+	return parser.parse(s, nil, nil, true)
+}
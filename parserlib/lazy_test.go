@@ -0,0 +1,77 @@
+package parserlib
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// types and ranges of the nodes with their inline children parsed
+func _inlineShape(node *AstNode) string {
+	var builder strings.Builder
+	node.preVisitInlines(func(node *AstNode) {
+		builder.WriteString(node.Type.String() + node.Start.String() + node.End.String() + "\n")
+	})
+	return builder.String()
+}
+
+func TestParseLazy(t *testing.T) {
+	parser := GetFullMKParser()
+	s := "# Title *a*\n\nSome **text** and [link](url)\nnext line\n\nother paragraph ![cap](a.png){#fig:a}\n\n" +
+		"> quote `code`\n\n- item ~~del~~\n\n| a | *b* |\n| - | - |\n| c | d |\n\nsee @fig:a\n"
+	want := parser.Parse(s)
+	ast := parser.ParseLazy(s)
+	t.Logf(ast.String())
+	assert.Nil(t, ast.Validate())
+
+	// blocks are there but inlines aren't parsed yet
+	assert.Equal(t, len(want.Root.Children), len(ast.Root.Children))
+	header := ast.Root.Children[0]
+	assert.Equal(t, "Header(1)", header.Type.String())
+	assert.Equal(t, 1, len(header.Children))
+	assert.Empty(t, header.Children[0].Children)
+	assert.Equal(t, 2, len(header.Children[0].Inlines()))
+	assert.Equal(t, header.Children[0], header.Children[0].Inlines()[0].Parent)
+
+	assert.Equal(t, _inlineShape(&want.Root), _inlineShape(&ast.Root))
+	rendered := parser.ParseLazy(s)
+	assert.Equal(t, RenderHTML(&want), RenderHTML(&rendered))
+	refs := parser.ParseLazy(s)
+	crossRefs := ResolveCrossRefs(&refs)
+	assert.Empty(t, crossRefs.Dangling)
+	assert.Equal(t, 1, len(crossRefs.Refs))
+
+	// concurrent access parses once
+	ast = parser.ParseLazy(s)
+	var wg sync.WaitGroup
+	results := make([]string, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = _inlineShape(&ast.Root)
+		}(i)
+	}
+	wg.Wait()
+	for _, result := range results {
+		assert.Equal(t, _inlineShape(&want.Root), result)
+	}
+
+	// positions of the inlines point into the original source
+	crlf := strings.ReplaceAll(s, "\n", "\r\n")
+	want = parser.Parse(crlf)
+	ast = parser.ParseLazy(crlf)
+	assert.Equal(t, _inlineShape(&want.Root), _inlineShape(&ast.Root))
+	assert.Nil(t, ast.Validate())
+
+	// inlines parsed by post parsers are mapped back with the rest of the ast
+	for _, s := range []string{"x\r\n*emph* HTML\n\n*[HTML]: H\n", "\uFEFFa *b* HTML\r\n\r\n*[HTML]: Hyper\r\n"} {
+		want = parser.Parse(s)
+		ast = parser.ParseLazy(s)
+		assert.Nil(t, ast.Validate())
+		assert.Equal(t, _inlineShape(&want.Root), _inlineShape(&ast.Root))
+		assert.Contains(t, ast.String(), "Abbreviation(")
+	}
+}
@@ -0,0 +1,148 @@
+package parserlib
+
+import (
+	"context"
+	"fmt"
+)
+
+// Limits bounds the resources spent on parsing untrusted input, zero fields are unlimited
+type Limits struct {
+	// bytes of the input
+	MaxInputSize int
+	// depth of the nodes, children of the document are at depth 1
+	MaxDepth int
+	// nodes of the ast, the document excluded
+	MaxNodes int
+}
+
+const (
+	LimitInputSize uint32 = iota
+	LimitDepth
+	LimitNodes
+	// the context.Context is done
+	LimitTime
+)
+
+// LimitError reports the limit parsing is aborted by
+type LimitError struct {
+	Limit uint32
+	// where parsing is aborted
+	Pos Pos
+	// the error of the context for LimitTime
+	Err error
+}
+
+func (err *LimitError) Error() string {
+	switch err.Limit {
+	case LimitInputSize:
+		return "input is too large"
+	case LimitDepth:
+		return fmt.Sprintf("nodes are nested too deeply at %s", err.Pos)
+	case LimitNodes:
+		return fmt.Sprintf("too many nodes at %s", err.Pos)
+	default:
+		return fmt.Sprintf("parsing is aborted at %s: %v", err.Pos, err.Err)
+	}
+}
+
+func (err *LimitError) Unwrap() error {
+	return err.Err
+}
+
+// checks between the polls of the context
+const limitPollInterval = 256
+
+type limitState struct {
+	limits Limits
+	ctx    context.Context
+	nodes  int
+	polls  int
+}
+
+func (limit *limitState) abort(kind uint32, pos Pos, err error) {
+	panic(&LimitError{Limit: kind, Pos: pos, Err: err})
+}
+
+// poll the context once in a while
+func (limit *limitState) check(pos Pos) {
+	if limit == nil {
+		return
+	}
+	limit.polls += 1
+	if limit.polls%limitPollInterval != 0 {
+		return
+	}
+	if err := limit.ctx.Err(); err != nil {
+		limit.abort(LimitTime, pos, err)
+	}
+}
+
+// start parsing text or blocks nested in the ones being parsed
+func (limit *limitState) enter(ctx *ParseContext) {
+	if limit == nil {
+		return
+	}
+	limit.check(ctx.P)
+	ctx.depth += 1
+	limit.nest(ctx.depth, ctx.P)
+}
+
+func (limit *limitState) nest(depth int, pos Pos) {
+	if limit != nil && limit.limits.MaxDepth > 0 && depth > limit.limits.MaxDepth {
+		limit.abort(LimitDepth, pos, nil)
+	}
+}
+
+// n nodes are created
+func (limit *limitState) add(n int, pos Pos) {
+	if limit == nil {
+		return
+	}
+	limit.nodes += n
+	if limit.limits.MaxNodes > 0 && limit.nodes > limit.limits.MaxNodes {
+		limit.abort(LimitNodes, pos, nil)
+	}
+}
+
+// ParseWithLimits parses s like Parse, but gives up with a *LimitError once a limit
+// is exceeded or ctx is done. While parsing, the nodes and the nesting of text and blocks
+// parsed recursively are counted to abort early, then the complete ast is checked
+// against MaxNodes and MaxDepth.
+// Internal failures are recovered and returned like ParseWithDiagnostics.
+func (parser *MKParser) ParseWithLimits(ctx context.Context, s string, limits Limits) (ast Ast, err error) {
+	if limits.MaxInputSize > 0 && len(s) > limits.MaxInputSize {
+		return Ast{Root: AstNode{Type: &Document{}}}, &LimitError{Limit: LimitInputSize}
+	}
+	if err := ctx.Err(); err != nil {
+		return Ast{Root: AstNode{Type: &Document{}}}, &LimitError{Limit: LimitTime, Err: err}
+	}
+	sink := &diagnosticSink{}
+	defer func() {
+		if r := recover(); r != nil {
+			ast = Ast{Root: AstNode{Type: &Document{}}}
+			if limitErr, ok := r.(*LimitError); ok {
+				err = limitErr
+			} else {
+				err = sink.recovered(r, s)
+			}
+		}
+	}()
+
+	limit := &limitState{limits: limits, ctx: ctx}
+	ast = parser.parse(s, sink, limit, false)
+	// structural nodes created by block and inline parsers themselves aren't counted above
+	limit.nodes = 0
+	var fCheck func(node *AstNode, depth int)
+	fCheck = func(node *AstNode, depth int) {
+		for _, ch := range node.Children {
+			limit.add(1, ch.Start)
+			limit.nest(depth+1, ch.Start)
+			fCheck(ch, depth+1)
+		}
+	}
+	fCheck(&ast.Root, 0)
+	if err := ctx.Err(); err != nil {
+		limit.abort(LimitTime, ast.Root.End, err)
+	}
+	return ast, nil
+}
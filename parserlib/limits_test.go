@@ -0,0 +1,96 @@
+package parserlib
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWithLimits(t *testing.T) {
+	parser := GetFullMKParser()
+	s := "# title\n\n- a *b*\n- c\n\n> [link](/url) `code`\n"
+	expected := parser.Parse(s)
+	ast, err := parser.ParseWithLimits(context.Background(), s, Limits{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected.String(), ast.String())
+	assert.Nil(t, ast.Validate())
+
+	fLimit := func(s string, limits Limits) uint32 {
+		_, err := parser.ParseWithLimits(context.Background(), s, limits)
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("expect a LimitError, got %v", err)
+		}
+		t.Logf(limitErr.Error())
+		return limitErr.Limit
+	}
+	assert.Equal(t, LimitInputSize, fLimit(s, Limits{MaxInputSize: 10}))
+	assert.Equal(t, LimitNodes, fLimit(s, Limits{MaxNodes: 5}))
+	assert.Equal(t, LimitNodes, fLimit(strings.Repeat("*a* ", 1000), Limits{MaxNodes: 100}))
+	assert.Equal(t, LimitDepth, fLimit(strings.Repeat("[", 50)+"a"+strings.Repeat("](/url)", 50), Limits{MaxDepth: 20}))
+	nested := ""
+	for i := 0; i < 10; i++ {
+		nested += strings.Repeat("    ", i) + "[^a]: a\n\n"
+	}
+	assert.Equal(t, LimitDepth, fLimit(nested, Limits{MaxDepth: 5}))
+	_, err = parser.ParseWithLimits(context.Background(), nested, Limits{MaxDepth: 20})
+	assert.Equal(t, nil, err)
+	_, err = parser.ParseWithLimits(context.Background(), s, Limits{MaxInputSize: len(s), MaxNodes: 100, MaxDepth: 4})
+	assert.Equal(t, nil, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = parser.ParseWithLimits(ctx, s, Limits{})
+	assert.True(t, errors.Is(err, context.Canceled))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = parser.ParseWithLimits(ctx, strings.Repeat("*a* [b](c) `d`\n\n", 200000), Limits{})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestPathologicalInput(t *testing.T) {
+	fInputs := func(n int) []string {
+		return []string{
+			strings.Repeat("[", n),
+			strings.Repeat("[a](", n),
+			strings.Repeat("\\\\[", n),
+			strings.Repeat("`", n) + strings.Repeat("a", n),
+			strings.Repeat("`a``", n),
+			strings.Repeat("<", n),
+			strings.Repeat("<b>", n),
+			strings.Repeat("[a][", n),
+			strings.Repeat("[^", n),
+			strings.Repeat("^[", n),
+			strings.Repeat("[@a ", n),
+			strings.Repeat("```a\n", n),
+			strings.Repeat("$$a\n", n),
+			strings.Repeat("1\n", n),
+			strings.Repeat("[^a\n", n),
+			strings.Repeat("*[a\n", n),
+		}
+	}
+	// parsers tried while parsing s, it grows with the work of the parser
+	fTries := func(s string) int {
+		parser := GetFullMKParser()
+		stats := NewParserStats()
+		parser.SetTracer(stats)
+		ast := parser.Parse(s)
+		assert.Equal(t, len(s), ast.Root.End.Offset)
+		tries := 0
+		for _, stat := range stats.Report() {
+			tries += stat.Tried
+		}
+		return tries
+	}
+	n := 10000
+	small, large := fInputs(n), fInputs(2*n)
+	for i := range small {
+		tries, doubled := fTries(small[i]), fTries(large[i])
+		t.Logf("%q...: %d, %d", small[i][:8], tries, doubled)
+		assert.LessOrEqual(t, float64(doubled), 2.5*float64(tries), "%q... isn't parsed in linear time", small[i][:8])
+	}
+}
@@ -0,0 +1,214 @@
+package parserlib
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// LineIndex converts between byte offsets of a source and line/column positions.
+// Lines are separated by "\n", "\r\n" or "\r" and start at 0. Columns start at 0 and are
+// counted in runes like Pos.Col, in UTF-16 code units like LSP, or in display cells with
+// tabs expanded. An invalid UTF-8 byte counts as a rune like ranging over the source does.
+// The UTF-8 BOM and line endings aren't parts of lines, columns past the end of a line
+// are clamped to the end of the line.
+type LineIndex struct {
+	src        string
+	lineStarts []int
+	// offsets of the line endings
+	lineEnds []int
+	// bytes of the BOM
+	bom int
+	// whether the line is ascii only, columns of such lines are byte offsets
+	ascii []bool
+}
+
+func NewLineIndex(src string) *LineIndex {
+	index := &LineIndex{src: src, lineStarts: []int{0}}
+	if strings.HasPrefix(src, utf8BOM) {
+		index.bom = len(utf8BOM)
+	}
+	ascii := true
+	for i := index.bom; i < len(src); i++ {
+		if c := src[i]; c == '\n' || c == '\r' {
+			index.lineEnds = append(index.lineEnds, i)
+			index.ascii = append(index.ascii, ascii)
+			if c == '\r' && i+1 < len(src) && src[i+1] == '\n' {
+				i += 1
+			}
+			index.lineStarts = append(index.lineStarts, i+1)
+			ascii = true
+		} else if c >= utf8.RuneSelf {
+			ascii = false
+		}
+	}
+	index.lineEnds = append(index.lineEnds, len(src))
+	index.ascii = append(index.ascii, ascii)
+	return index
+}
+
+// LineIndex indexes the source of the ast
+func (ast *Ast) LineIndex() *LineIndex {
+	return NewLineIndex(ast.src)
+}
+
+func (index *LineIndex) LineCount() int {
+	return len(index.lineStarts)
+}
+
+func (index *LineIndex) checkLine(line int) {
+	if line < 0 || line >= len(index.lineStarts) {
+		log.Panicf("line %d is out of the %d lines", line, len(index.lineStarts))
+	}
+}
+
+// LineStart returns the offset of the beginning of line
+func (index *LineIndex) LineStart(line int) int {
+	index.checkLine(line)
+	return index.lineStarts[line]
+}
+
+// LineEnd returns the offset of the end of line, its line ending excluded
+func (index *LineIndex) LineEnd(line int) int {
+	index.checkLine(line)
+	return index.lineEnds[line]
+}
+
+// offset of the first column of line
+func (index *LineIndex) contentStart(line int) int {
+	if line == 0 {
+		return index.bom
+	}
+	return index.lineStarts[line]
+}
+
+// Line returns the line containing offset, offsets out of the source are clamped
+func (index *LineIndex) Line(offset int) int {
+	return sort.SearchInts(index.lineStarts, offset+1) - 1
+}
+
+// columns between the beginning of line and offset, width returns the columns of a rune
+func (index *LineIndex) col(line int, offset int, width func(c rune, col int) int) int {
+	start := index.contentStart(line)
+	if offset < start {
+		return 0
+	} else if offset > index.lineEnds[line] {
+		offset = index.lineEnds[line]
+	}
+	col := 0
+	for _, c := range index.src[start:offset] {
+		col += width(c, col)
+	}
+	return col
+}
+
+// offset of col of line, width returns the columns of a rune
+func (index *LineIndex) offset(line int, col int, width func(c rune, col int) int) int {
+	index.checkLine(line)
+	start, end := index.contentStart(line), index.lineEnds[line]
+	cur := 0
+	for i, c := range index.src[start:end] {
+		if cur >= col {
+			return start + i
+		}
+		cur += width(c, cur)
+	}
+	return end
+}
+
+func _runeWidth(c rune, col int) int {
+	return 1
+}
+
+func _utf16Width(c rune, col int) int {
+	if c >= 0x10000 && c <= utf8.MaxRune {
+		return 2
+	}
+	return 1
+}
+
+func (index *LineIndex) clamp(offset int) int {
+	if offset < 0 {
+		return 0
+	} else if offset > len(index.src) {
+		return len(index.src)
+	}
+	return offset
+}
+
+// Pos returns the position of offset with the column in runes, the same as Pos.Consume counts
+func (index *LineIndex) Pos(offset int) Pos {
+	offset = index.clamp(offset)
+	line := index.Line(offset)
+	return Pos{Line: line, Col: index.runeCol(line, offset), Offset: offset}
+}
+
+func (index *LineIndex) runeCol(line int, offset int) int {
+	if !index.ascii[line] {
+		return index.col(line, offset, _runeWidth)
+	}
+	start := index.contentStart(line)
+	if offset < start {
+		return 0
+	} else if offset > index.lineEnds[line] {
+		return index.lineEnds[line] - start
+	}
+	return offset - start
+}
+
+// Offset returns the offset of the rune column col of line
+func (index *LineIndex) Offset(line int, col int) int {
+	index.checkLine(line)
+	if !index.ascii[line] {
+		return index.offset(line, col, _runeWidth)
+	}
+	offset := index.contentStart(line) + col
+	if offset > index.lineEnds[line] {
+		return index.lineEnds[line]
+	}
+	return offset
+}
+
+// UTF16Col returns the line and the column in UTF-16 code units of offset
+func (index *LineIndex) UTF16Col(offset int) (int, int) {
+	offset = index.clamp(offset)
+	line := index.Line(offset)
+	if index.ascii[line] {
+		return line, index.runeCol(line, offset)
+	}
+	return line, index.col(line, offset, _utf16Width)
+}
+
+// OffsetFromUTF16 returns the offset of the UTF-16 column col of line.
+// A column in the middle of a surrogate pair is moved to the end of the pair.
+func (index *LineIndex) OffsetFromUTF16(line int, col int) int {
+	index.checkLine(line)
+	if index.ascii[line] {
+		return index.Offset(line, col)
+	}
+	return index.offset(line, col, _utf16Width)
+}
+
+func _displayWidth(tabWidth int) func(c rune, col int) int {
+	return func(c rune, col int) int {
+		if c == '\t' && tabWidth > 0 {
+			return tabWidth - col%tabWidth
+		}
+		return 1
+	}
+}
+
+// DisplayCol returns the line and the display column of offset, tabs move to the next
+// multiple of tabWidth and other runes take one cell
+func (index *LineIndex) DisplayCol(offset int, tabWidth int) (int, int) {
+	offset = index.clamp(offset)
+	line := index.Line(offset)
+	return line, index.col(line, offset, _displayWidth(tabWidth))
+}
+
+// OffsetFromDisplay returns the offset of the display column col of line.
+// A column inside the cells of a tab is moved to the end of the tab.
+func (index *LineIndex) OffsetFromDisplay(line int, col int, tabWidth int) int {
+	return index.offset(line, col, _displayWidth(tabWidth))
+}
@@ -0,0 +1,64 @@
+package parserlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineIndex(t *testing.T) {
+	src := "ab\n\t中x\n😀y\xffz\n"
+	index := NewLineIndex(src)
+	assert.Equal(t, 4, index.LineCount())
+	assert.Equal(t, 3, index.LineStart(1))
+	assert.Equal(t, 8, index.LineEnd(1))
+	assert.Equal(t, len(src), index.LineStart(3))
+	assert.Equal(t, len(src), index.LineEnd(3))
+
+	// every position Pos.Consume reaches maps back and forth
+	pos := Pos{}
+	for i, c := range src {
+		assert.Equal(t, pos, index.Pos(i))
+		assert.Equal(t, i, index.Offset(pos.Line, pos.Col))
+		pos.consume(c, _runeSize(src, i, c))
+	}
+	assert.Equal(t, pos, index.Pos(len(src)))
+
+	// "😀" takes 2 UTF-16 code units
+	line, col := index.UTF16Col(9 + 4)
+	assert.Equal(t, 2, line)
+	assert.Equal(t, 2, col)
+	line, col = index.UTF16Col(9 + 6)
+	assert.Equal(t, 4, col)
+	assert.Equal(t, 9+4, index.OffsetFromUTF16(2, 2))
+	assert.Equal(t, 9+4, index.OffsetFromUTF16(2, 1))
+	assert.Equal(t, 9+6, index.OffsetFromUTF16(2, 4))
+	assert.Equal(t, 9+7, index.OffsetFromUTF16(2, 100))
+
+	// the tab takes 4 cells
+	line, col = index.DisplayCol(4, 4)
+	assert.Equal(t, 1, line)
+	assert.Equal(t, 4, col)
+	line, col = index.DisplayCol(7, 4)
+	assert.Equal(t, 5, col)
+	assert.Equal(t, 4, index.OffsetFromDisplay(1, 2, 4))
+	assert.Equal(t, 7, index.OffsetFromDisplay(1, 5, 4))
+
+	// offsets out of the source are clamped
+	assert.Equal(t, Pos{}, index.Pos(-1))
+	assert.Equal(t, index.Pos(len(src)), index.Pos(len(src)+10))
+	assert.Panics(t, func() { index.Offset(4, 0) })
+
+	parser := GetFullMKParser()
+	ast := parser.Parse("# 标题\n正文")
+	assert.Equal(t, ast.Root.Children[1].Start, ast.LineIndex().Pos(ast.Root.Children[1].Start.Offset))
+}
+
+func TestPosBack(t *testing.T) {
+	pos := Pos{}
+	pos.ConsumeStr("a中")
+	pos.Back('中')
+	assert.Equal(t, Pos{Line: 0, Col: 1, Offset: 1}, pos)
+	pos.ConsumeStr("\n")
+	assert.Panics(t, func() { pos.Back('\n') })
+}
@@ -1,12 +1,10 @@
 package parserlib
 
 import (
-	"log"
 	"net/mail"
 	"regexp"
 	"strconv"
 	"strings"
-	"unicode/utf8"
 )
 
 /*
@@ -14,11 +12,29 @@ import (
  */
 type InlineParser func(string, ParseContext) *AstNode
 type BlockParser func(string, ParseContext) *AstNode
+
+// PostParser runs on the whole ast after parsing, s is the parsed source
+type PostParser func(*Ast, string)
 type ParseContext struct {
 	P           Pos
 	Parent      *AstNode
 	LeftSibling *AstNode
 	ParseText   func(string, ParseContext) *AstNode
+	ParseBlocks func(string, ParseContext) []*AstNode
+	// columns of indentation every line of nested blocks starts with
+	Indent int
+	// rune right before P for inline parsers, 0 if P starts the text
+	Prev rune
+	// nil unless parsing with diagnostics
+	diag *diagnosticSink
+	// nil unless parsing with limits
+	limit *limitState
+	// nesting of the text and blocks being parsed
+	depth int
+	// scans of the text being parsed
+	scan *scanCache
+	// nil unless tracing
+	trace *traceState
 }
 
 // strings.Index() that take escape symbol \ into account
@@ -46,10 +62,127 @@ func _findInLine(s string, pattern string) int {
 	}
 }
 
+// columns between tab stops
+const tabStop = 4
+
+// bytes of the indentation at the beginning of s, at most indent columns.
+// A tab moves to the next tab stop and is consumed as a whole even if it goes past
+// the indentation. The second result reports whether the whole indentation is present.
+func _indentPrefix(s string, indent int) (int, bool) {
+	i, col := 0, 0
+	for i < len(s) && col < indent {
+		if s[i] == '\t' {
+			col += tabStop - col%tabStop
+		} else if s[i] == ' ' {
+			col += 1
+		} else {
+			return i, false
+		}
+		i += 1
+	}
+	return i, col >= indent
+}
+
+// index of the ']' matching the leading '[' of s in the same line
+func _findRightBracket(s string) int {
+	leftBracketMet := 0
+	lastEscape := false
+	for i, c := range s[1:] {
+		if c == '\n' {
+			return -1
+		}
+		if !lastEscape && c == '[' {
+			leftBracketMet += 1
+		}
+		if !lastEscape && c == ']' {
+			if leftBracketMet == 0 {
+				return i + 1 // don't omit the [ at the beginning
+			}
+			leftBracketMet -= 1
+		}
+		if c == '\\' {
+			lastEscape = true
+		} else {
+			lastEscape = false
+		}
+	}
+	return -1
+}
+
+var labelRegex = regexp.MustCompile(`^\{#([^\s{}]+)\}`)
+
+// {#label} at the beginning of s
+func _parseLabel(s string, ctx ParseContext) *AstNode {
+	match := labelRegex.FindStringSubmatch(s)
+	if match == nil {
+		return nil
+	}
+	endPos := ctx.P
+	endPos.ConsumeStr(match[0])
+	node := &AstNode{
+		Type:        &Label{Name: match[1]},
+		Start:       ctx.P,
+		End:         endPos,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 2))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(endPos, 1))
+	return node
+}
+
+// index of the first run of exactly n backticks in s
+func _findBacktickRun(s string, n int) int {
+	for i := 0; i < len(s); {
+		if s[i] != '`' {
+			i += 1
+			continue
+		}
+		run := 1
+		for i+run < len(s) && s[i+run] == '`' {
+			run += 1
+		}
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+// index of the next table cell separator '|', escaped ones and
+// the ones inside code spans are skipped
+func _findCellSep(s string) int {
+	lastEscape := false
+	for i := 0; i < len(s); i++ {
+		if lastEscape {
+			lastEscape = false
+			continue
+		}
+		switch s[i] {
+		case '\\':
+			lastEscape = true
+		case '|':
+			return i
+		case '`':
+			run := 1
+			for i+run < len(s) && s[i+run] == '`' {
+				run += 1
+			}
+			if end := _findBacktickRun(s[i+run:], run); end >= 0 {
+				i += run + end + run - 1
+			} else {
+				i += run - 1
+			}
+		}
+	}
+	return -1
+}
+
+var urlRegex = regexp.MustCompile(`^\w+://[\w\.]+(:[0-9]+)?(/\w+)*(\?(\w+=\w+\&)*\w+=\w+)?(#\w+)?$`)
+
 func _matchUrl(s string) bool {
-	urlRegex := regexp.MustCompile(`^\w+://[\w\.]+(:[0-9]+)?(/\w+)*(\?(\w+=\w+\&)*\w+=\w+)?(#\w+)?$`)
-	res := urlRegex.MatchString(s)
-	return res
+	return urlRegex.MatchString(s)
 }
 
 func _matchEmail(s string) bool {
@@ -69,7 +202,9 @@ func _textOrEmpty(text string, ctx ParseContext) *AstNode {
 	} else {
 		textnode := ctx.ParseText(text, ctx)
 		if textnode == nil {
-			log.Panicf("Failed to parse text: %s", text)
+			end := ctx.P
+			end.ConsumeStr(text)
+			_bug(ctx.P, end, "failed to parse text: %s", text)
 		}
 		return textnode
 	}
@@ -77,6 +212,16 @@ func _textOrEmpty(text string, ctx ParseContext) *AstNode {
 
 /* Block parsers */
 func parseHeader(s string, ctx ParseContext) *AstNode {
+	return _parseHeader(s, ctx, 0)
+}
+
+// at most 6 levels like CommonMark
+func parseStrictHeader(s string, ctx ParseContext) *AstNode {
+	return _parseHeader(s, ctx, 6)
+}
+
+// no limit of levels if maxLevel is 0
+func _parseHeader(s string, ctx ParseContext, maxLevel uint32) *AstNode {
 	if len(s) == 0 || s[0] != '#' {
 		return nil
 	}
@@ -95,9 +240,13 @@ func parseHeader(s string, ctx ParseContext) *AstNode {
 		ctx.P.Consume(rune(s[i]))
 		i = i + 1
 	}
+	if maxLevel > 0 && head.Level > maxLevel {
+		return nil
+	}
 	if i < len(s) && s[i] != ' ' && s[i] != '\n' {
 		return nil
 	}
+	node.addSyntax(TokenMarker, _asciiRange(node.Start, i))
 	j := strings.Index(s, "\n")
 	var text string
 	endPos := ctx.P
@@ -108,7 +257,13 @@ func parseHeader(s string, ctx ParseContext) *AstNode {
 		text = s[i:j]
 		endPos.ConsumeStr(s[i : j+1])
 	}
-	textnode := _textOrEmpty(text, ctx)
+	textStart, textEnd := _headerTextRange(text)
+	head.TextRange = _rangeIn(text, ctx.P, textStart, textEnd)
+	curCtx := ctx
+	curCtx.Parent = node
+	curCtx.LeftSibling = nil
+	textnode := _textOrEmpty(text, curCtx)
+	_addHeaderSyntax(textnode, text, ctx.P, textStart, textEnd)
 	node.Children = append(node.Children, textnode)
 
 	node.Type = &head
@@ -117,10 +272,57 @@ func parseHeader(s string, ctx ParseContext) *AstNode {
 	return node
 }
 
-func _parseWithPrefix(s string, notation string, allowSuffix bool, pos Pos) (bool, Pos, Pos, string) {
-	if pos.Col != 0 {
-		log.Panicf("_parseWithPrefix should be invoked at the beginning of a line: %s", pos)
-		return false, Pos{}, Pos{}, ""
+// offsets of the header text without the surrounding spaces and the closing '#'s
+func _headerTextRange(text string) (int, int) {
+	start := len(text) - len(strings.TrimLeft(text, " \t"))
+	end := start + len(strings.TrimRight(text[start:], " \t"))
+	if closing := strings.TrimRight(text[start:end], "#"); len(closing) == 0 {
+		end = start
+	} else if last := closing[len(closing)-1]; len(closing) < end-start && (last == ' ' || last == '\t') {
+		end = start + len(strings.TrimRight(closing, " \t"))
+	}
+	return start, end
+}
+
+// the spaces around the header text and the closing '#'s are syntax of its text node
+func _addHeaderSyntax(textnode *AstNode, text string, pos Pos, start int, end int) {
+	line := text[:max(len(strings.TrimRight(text, " \t")), end)]
+	closing := end + len(line[end:]) - len(strings.TrimLeft(line[end:], " \t"))
+	textnode.addSyntax(TokenTrivia, _rangeIn(text, pos, 0, start))
+	textnode.addSyntax(TokenTrivia, _rangeIn(text, pos, end, closing))
+	textnode.addSyntax(TokenMarker, _rangeIn(text, pos, closing, len(line)))
+	textnode.addSyntax(TokenTrivia, _rangeIn(text, pos, len(line), len(text)))
+}
+
+// the opening and the closing notation of the block s[:size] parsed by _parseWithPrefix
+func _addFenceSyntax(node *AstNode, s string, size int, notation string) {
+	block := s[:size]
+	closing := strings.LastIndexByte(strings.TrimSuffix(block, "\n"), '\n') + 1
+	closing += strings.Index(block[closing:], notation)
+	node.addSyntax(TokenMarker, _asciiRange(node.Start, len(notation)))
+	node.addSyntax(TokenMarker, _rangeIn(s, node.Start, closing, closing+len(notation)))
+}
+
+// lines between the opening and the closing line of the block s[:size] parsed by
+// _parseWithPrefix and the suffix after the opening notation
+func _fencedRanges(s string, size int, notation string, pos Pos) (Range, Range) {
+	block := s[:size]
+	open := strings.IndexByte(block, '\n')
+	closing := strings.LastIndexByte(strings.TrimSuffix(block, "\n"), '\n') + 1
+	return _rangeIn(s, pos, len(notation), open), _rangeIn(s, pos, open+1, closing)
+}
+
+// the closing line may be followed by a suffix accepted by closeSuffix
+func _parseWithPrefix(s string, notation string, allowSuffix bool, ctx ParseContext, closeSuffix func(string) bool) (bool, Pos, Pos, string) {
+	pos, indent := ctx.P, ctx.Indent
+	fIsClose := func(line string) bool {
+		if line == notation {
+			return true
+		}
+		return closeSuffix != nil && strings.HasPrefix(line, notation) && closeSuffix(line[len(notation):])
+	}
+	if pos.Col > indent {
+		_bug(pos, pos, "_parseWithPrefix should be invoked at the beginning of a line")
 	}
 	// two notation + '\n'
 	if len(s) < len(notation)*2+1 {
@@ -146,25 +348,32 @@ func _parseWithPrefix(s string, notation string, allowSuffix bool, pos Pos) (boo
 	suffix := newS[:newLineIdx]
 	curPos.ConsumeStr(newS[:newLineIdx+1])
 
+	// the content of every block opened after a failed one is a part of the failed one's
+	key := notation + strconv.Itoa(indent)
+	content := len(notation) + newLineIdx + 1
+	if ctx.scan.isUnclosed(key, s, pos.Offset, content) {
+		return false, Pos{}, Pos{}, suffix
+	}
 	newS = newS[newLineIdx+1:]
 	for len(newS) > 0 {
 		if curPos.Col != 0 {
-			panic("The 'start of the line' invariance is broken")
+			_bug(pos, curPos, "the 'start of the line' invariance is broken")
 		}
 		if len(newS) < len(notation) {
-			return false, Pos{}, Pos{}, ""
+			break
 		}
 		newLineIdx = strings.Index(newS, "\n")
+		lineIndent, _ := _indentPrefix(newS, indent)
 		if newLineIdx < 0 {
-			if newS != notation {
-				return false, Pos{}, Pos{}, ""
+			if !fIsClose(newS[lineIndent:]) {
+				break
 			}
 			curPos.ConsumeStr(newS)
 			endPos = curPos
 			found = true
 			break
 		} else {
-			if newS[:newLineIdx] == notation {
+			if fIsClose(newS[lineIndent:newLineIdx]) {
 				curPos.ConsumeStr(newS[:newLineIdx+1])
 				endPos = curPos
 				found = true
@@ -176,47 +385,99 @@ func _parseWithPrefix(s string, notation string, allowSuffix bool, pos Pos) (boo
 	}
 
 	if !found {
+		ctx.scan.setUnclosed(key, s, pos.Offset, content)
 		return false, Pos{}, Pos{}, suffix
 	}
 	if endPos.Offset <= pos.Offset {
-		panic("endPos should be after pos")
+		_bug(pos, endPos, "endPos should be after pos")
 	}
 	return true, pos, endPos, suffix
 }
 
+func _isLabelSuffix(s string) bool {
+	s = strings.TrimLeft(s, " ")
+	loc := labelRegex.FindStringIndex(s)
+	return loc != nil && len(strings.TrimRight(s[loc[1]:], " ")) == 0
+}
+
+// $$ ... $$ {#eq:label}
 func parseMathBlock(s string, ctx ParseContext) *AstNode {
-	ret, start, end, _ := _parseWithPrefix(s, "$$", true, ctx.P)
+	ret, start, end, _ := _parseWithPrefix(s, "$$", true, ctx, _isLabelSuffix)
 	if ret {
+		_, bodyRange := _fencedRanges(s, end.Offset-start.Offset, "$$", start)
 		node := &AstNode{
-			Type:        &MathBlock{},
+			Type:        &MathBlock{BodyRange: bodyRange},
 			Start:       start,
 			End:         end,
 			Parent:      ctx.Parent,
 			LeftSibling: ctx.LeftSibling,
 		}
+		_addFenceSyntax(node, s, end.Offset-start.Offset, "$$")
+		body := strings.TrimRight(s[:end.Offset-start.Offset], "\n ")
+		if labelStart := strings.LastIndex(body, "{#"); labelStart >= 0 && strings.HasSuffix(body, "}") {
+			curCtx := ctx
+			curCtx.P.ConsumeStr(s[:labelStart])
+			curCtx.Parent = node
+			curCtx.LeftSibling = nil
+			if label := _parseLabel(body[labelStart:], curCtx); label != nil && label.End.Offset-start.Offset == len(body) {
+				node.Children = append(node.Children, label)
+				spaces := len(strings.TrimRight(body[:labelStart], " "))
+				node.addSyntax(TokenTrivia, _asciiRangeBefore(curCtx.P, labelStart-spaces))
+			}
+		}
 		return node
 	} else {
+		_warnUnclosed(s, "$$", ctx)
 		return nil
 	}
 }
 
+// warn if s opens a block with notation that is never closed
+func _warnUnclosed(s string, notation string, ctx ParseContext) {
+	if !strings.HasPrefix(s, notation) {
+		return
+	}
+	line := s[:_lineEnd(s, 0)]
+	if strings.Contains(line[len(notation):], notation[:1]) {
+		// inline code or math
+		return
+	}
+	end := ctx.P
+	end.ConsumeStr(line)
+	ctx.Warn(ctx.P, end, "unclosed %s block", notation)
+}
+
 func parseCodeBlock(s string, ctx ParseContext) *AstNode {
-	ret, start, end, suffix := _parseWithPrefix(s, "```", true, ctx.P)
+	ret, start, end, suffix := _parseWithPrefix(s, "```", true, ctx, nil)
 	if ret {
+		suffixRange, body := _fencedRanges(s, end.Offset-start.Offset, "```", start)
 		node := &AstNode{
-			Type:        &CodeBlock{Suffix: suffix},
+			Type:        &CodeBlock{Suffix: suffix, SuffixRange: suffixRange, BodyRange: body},
 			Start:       start,
 			End:         end,
 			Parent:      ctx.Parent,
 			LeftSibling: ctx.LeftSibling,
 		}
+		_addFenceSyntax(node, s, end.Offset-start.Offset, "```")
 		return node
 	} else {
+		_warnUnclosed(s, "```", ctx)
 		return nil
 	}
 }
 
 func parseTable(s string, ctx ParseContext) *AstNode {
+	return _parseTable(s, ctx, false)
+}
+
+// no captions and the rows end before a line starting another block like GFM
+func parseStrictTable(s string, ctx ParseContext) *AstNode {
+	return _parseTable(s, ctx, true)
+}
+
+var tableAlignRegex = regexp.MustCompile(`^:?-+:?$`)
+
+func _parseTable(s string, ctx ParseContext, strict bool) *AstNode {
 	type LineResult struct {
 		valid     bool
 		hasOrMark bool
@@ -224,6 +485,8 @@ func parseTable(s string, ctx ParseContext) *AstNode {
 		start     Pos
 		end       Pos
 		texts     []*AstNode
+		// the pipes and the spaces around the cells
+		syntax []SyntaxRange
 	}
 
 	parseTableLine := func(s string, ctx ParseContext) LineResult {
@@ -249,10 +512,11 @@ func parseTable(s string, ctx ParseContext) *AstNode {
 		if s[0] == '|' {
 			cur = 1
 			result.hasOrMark = true
+			result.syntax = _appendSyntax(result.syntax, TokenMarker, _asciiRange(ctx.P, 1))
 			ctx.P.Consume('|')
 		}
 		for cur < len(s) {
-			curSep := _findInLine(s[cur:], "|")
+			curSep := _findCellSep(s[cur:])
 			var textStr string
 			nextP := ctx.P
 			if curSep < 0 {
@@ -278,6 +542,14 @@ func parseTable(s string, ctx ParseContext) *AstNode {
 					break
 				}
 			}
+			result.syntax = _appendSyntax(result.syntax, TokenTrivia, _asciiRangeBefore(ctx.P, leadingSpace))
+			if curSep < sep {
+				pipe := _asciiRangeBefore(nextP, 1)
+				if tailingSpace >= leadingSpace {
+					result.syntax = _appendSyntax(result.syntax, TokenTrivia, _asciiRangeBefore(pipe.Start, len(textStr)-1-tailingSpace))
+				}
+				result.syntax = _appendSyntax(result.syntax, TokenMarker, pipe)
+			}
 			var curText *AstNode
 			if tailingSpace < leadingSpace {
 				curText = &AstNode{
@@ -291,7 +563,7 @@ func parseTable(s string, ctx ParseContext) *AstNode {
 				curText = ctx.ParseText(textStr[leadingSpace:tailingSpace+1], ctx)
 			}
 			if curText == nil {
-				log.Panicf("Failed to parse table line: %s", s)
+				_bug(ctx.P, nextP, "failed to parse table line: %s", s)
 			}
 			ctx.LeftSibling = curText
 			ctx.P = nextP
@@ -303,11 +575,11 @@ func parseTable(s string, ctx ParseContext) *AstNode {
 		}
 		result.valid = true
 		if result.end != ctx.P {
-			log.Panicf("Should agree on the end position: %s, %s", result.end.String(), ctx.P.String())
+			_bug(result.start, ctx.P, "should agree on the end position: %s, %s", result.end.String(), ctx.P.String())
 		}
 		endSep := result.end.Offset - result.start.Offset
 		if endSep != result.sep {
-			log.Panicf("End and sep should agree on the end position: %d, %d", endSep, result.sep)
+			_bug(result.start, result.end, "end and sep should agree on the end position: %d, %d", endSep, result.sep)
 		}
 		return result
 	}
@@ -324,6 +596,28 @@ func parseTable(s string, ctx ParseContext) *AstNode {
 	curCtx.LeftSibling = nil
 	curRear := 0
 
+	// the caption may be placed before the table and separated by a blank line
+	var leadingCaption *AstNode
+	if !strict {
+		leadingCaption = _parseTableCaption(s, curCtx)
+	}
+	if leadingCaption != nil {
+		curCtx.P = leadingCaption.End
+		curCtx.LeftSibling = leadingCaption
+		curRear = leadingCaption.End.Offset - ctx.P.Offset
+		indent, _ := _indentPrefix(s[curRear:], ctx.Indent)
+		if curRear+indent < len(s) && s[curRear+indent] == '\n' {
+			curCtx.P.ConsumeStr(s[curRear : curRear+indent+1])
+			curRear += indent + 1
+		}
+		indent, _ = _indentPrefix(s[curRear:], ctx.Indent)
+		curCtx.P.ConsumeStr(s[curRear : curRear+indent])
+		curRear += indent
+		if curRear >= len(s) {
+			return nil
+		}
+	}
+
 	headerNode := &AstNode{
 		Type:        &TableHead{},
 		Start:       curCtx.P,
@@ -334,18 +628,23 @@ func parseTable(s string, ctx ParseContext) *AstNode {
 	curCtx.Parent = headerNode
 	curCtx.LeftSibling = nil
 
-	headResult := parseTableLine(s, curCtx)
+	headResult := parseTableLine(s[curRear:], curCtx)
 	if !headResult.valid || !headResult.hasOrMark {
 		return nil
 	}
 	headerNode.Children = append(headerNode.Children, headResult.texts...)
+	headerNode.Syntax = headResult.syntax
 	headerNode.End = headResult.end
 	curCtx.P = headResult.end
-	curRear = headResult.sep
+	curRear += headResult.sep
 	if curRear >= len(s) {
 		return nil
 	}
 
+	indent, _ := _indentPrefix(s[curRear:], ctx.Indent)
+	curCtx.P.ConsumeStr(s[curRear : curRear+indent])
+	curRear += indent
+
 	alignType := TableAlign{}
 	alignNode := &AstNode{
 		Type:        &TableAlign{},
@@ -357,39 +656,41 @@ func parseTable(s string, ctx ParseContext) *AstNode {
 	curCtx.Parent = alignNode
 	curCtx.LeftSibling = nil
 	alignResult := parseTableLine(s[curRear:], curCtx)
-	if !alignResult.valid || len(alignResult.texts) != len(headResult.texts) {
+	// a delimiter row that doesn't fit the header is most likely a typo
+	fMalformed := func() *AstNode {
+		line := s[curRear:_lineEnd(s, curRear)]
+		if strings.Contains(line, "|") && strings.Contains(line, "-") && len(strings.Trim(line, "|:- ")) == 0 {
+			end := curCtx.P
+			end.ConsumeStr(line)
+			ctx.Warn(curCtx.P, end, "malformed table: delimiter row doesn't match the header")
+		}
 		return nil
 	}
+	if !alignResult.valid || len(alignResult.texts) != len(headResult.texts) {
+		return fMalformed()
+	}
 	// convert texts to aligns
 	for _, textnode := range alignResult.texts {
 		startOff := textnode.Start.Offset - ctx.P.Offset
 		endOff := textnode.End.Offset - ctx.P.Offset
 		sAlign := s[startOff:endOff]
-		isLeft, isRight := false, false
-		if len(sAlign) == 0 {
-			return nil
-		} else if len(sAlign) == 1 && sAlign[0] != '-' {
-			return nil
+		if !tableAlignRegex.MatchString(sAlign) {
+			return fMalformed()
+		}
+		isLeft, isRight := sAlign[0] == ':', sAlign[len(sAlign)-1] == ':'
+		if isLeft && isRight {
+			alignType.Aligns = append(alignType.Aligns, AlignMiddle)
+		} else if isRight {
+			alignType.Aligns = append(alignType.Aligns, AlignRight)
 		} else {
-			if sAlign[0] == ':' {
-				isLeft = true
-				if sAlign[1] != '-' {
-					return nil
-				}
-			}
-			if sAlign[len(sAlign)-1] == ':' {
-				isRight = true
-			}
-			if isLeft && isRight {
-				alignType.Aligns = append(alignType.Aligns, AlignMiddle)
-			} else if isRight {
-				alignType.Aligns = append(alignType.Aligns, AlignRight)
-			} else {
-				alignType.Aligns = append(alignType.Aligns, AlignLeft)
-			}
+			alignType.Aligns = append(alignType.Aligns, AlignLeft)
 		}
 	}
 	alignNode.Type = &alignType
+	alignNode.Syntax = alignResult.syntax
+	for _, textnode := range alignResult.texts {
+		alignNode.addSyntax(TokenMarker, Range{Start: textnode.Start, End: textnode.End})
+	}
 	alignNode.End = alignResult.end
 	curCtx.P = alignResult.end
 	curRear += alignResult.sep
@@ -397,11 +698,37 @@ func parseTable(s string, ctx ParseContext) *AstNode {
 	curCtx.Parent = tableNode
 	curCtx.LeftSibling = alignNode
 	lineNodes := []*AstNode{}
+	var captionNode *AstNode
 	for curRear < len(s) {
-		if s[curRear] == '\n' {
-			curCtx.P.Consume('\n')
+		indent, _ := _indentPrefix(s[curRear:], ctx.Indent)
+		if curRear+indent >= len(s) {
 			break
 		}
+		if s[curRear+indent] == '\n' {
+			curCtx.P.ConsumeStr(s[curRear : curRear+indent+1])
+			curRear += indent + 1
+			// the caption may be separated by a blank line
+			indent, _ = _indentPrefix(s[curRear:], ctx.Indent)
+			captionCtx := curCtx
+			captionCtx.P.ConsumeStr(s[curRear : curRear+indent])
+			if leadingCaption == nil && !strict {
+				if captionNode = _parseTableCaption(s[curRear+indent:], captionCtx); captionNode != nil {
+					curCtx.P = captionNode.End
+				}
+			}
+			break
+		}
+		if strict && paragraphInterruptRegex.MatchString(s[curRear+indent:_lineEnd(s, curRear)]) {
+			break
+		}
+		curCtx.P.ConsumeStr(s[curRear : curRear+indent])
+		curRear += indent
+		if leadingCaption == nil && !strict {
+			if captionNode = _parseTableCaption(s[curRear:], curCtx); captionNode != nil {
+				curCtx.P = captionNode.End
+				break
+			}
+		}
 		lineNode := &AstNode{
 			Type:        &TableLine{},
 			Start:       curCtx.P,
@@ -409,24 +736,124 @@ func parseTable(s string, ctx ParseContext) *AstNode {
 			Parent:      tableNode,
 			LeftSibling: curCtx.LeftSibling,
 		}
-		lineResult := parseTableLine(s[curRear:], curCtx)
+		lineCtx := curCtx
+		lineCtx.Parent = lineNode
+		lineCtx.LeftSibling = nil
+		lineResult := parseTableLine(s[curRear:], lineCtx)
 		if !lineResult.valid {
 			return nil
 		}
-		lineNode.Children = append(lineNode.Children, lineResult.texts...)
+		// pad the row to the number of header cells, the excess is ignored like GFM and
+		// its source is left to the row
+		texts := lineResult.texts
+		if len(texts) > len(headResult.texts) {
+			extra := texts[len(headResult.texts)]
+			ctx.Warn(extra.Start, texts[len(texts)-1].End, "table row has %d cells, more than the %d of the header",
+				len(texts), len(headResult.texts))
+			texts = texts[:len(headResult.texts)]
+		}
+		for len(texts) < len(headResult.texts) {
+			padPos := lineNode.Start
+			var leftSib *AstNode
+			if len(texts) > 0 {
+				leftSib = texts[len(texts)-1]
+				padPos = leftSib.End
+			}
+			texts = append(texts, &AstNode{
+				Type:        &Text{},
+				Start:       padPos,
+				End:         padPos,
+				Parent:      lineNode,
+				LeftSibling: leftSib,
+			})
+		}
+		lineNode.Children = append(lineNode.Children, texts...)
+		lineNode.Syntax = lineResult.syntax
 		lineNode.End = lineResult.end
 		curCtx.P = lineResult.end
 		curCtx.LeftSibling = lineNode
 		curRear += lineResult.sep
 		lineNodes = append(lineNodes, lineNode)
 	}
+	if leadingCaption != nil {
+		tableNode.Children = append(tableNode.Children, leadingCaption)
+	}
 	tableNode.Children = append(tableNode.Children, headerNode)
 	tableNode.Children = append(tableNode.Children, alignNode)
 	tableNode.Children = append(tableNode.Children, lineNodes...)
+	if captionNode != nil {
+		tableNode.Children = append(tableNode.Children, captionNode)
+	}
 	tableNode.End = curCtx.P
 	return tableNode
 }
 
+// ": caption {#tbl:label}" or "Table: caption {#tbl:label}"
+func _parseTableCaption(s string, ctx ParseContext) *AstNode {
+	prefix := 0
+	if strings.HasPrefix(s, ": ") {
+		prefix = len(":")
+	} else if strings.HasPrefix(s, "Table: ") {
+		prefix = len("Table:")
+	} else {
+		return nil
+	}
+	lineEnd := _lineEnd(s, 0)
+	end := lineEnd + 1
+	if end > len(s) {
+		end = len(s)
+	}
+	endPos := ctx.P
+	endPos.ConsumeStr(s[:end])
+	node := &AstNode{
+		Type:        &TableCaption{},
+		Start:       ctx.P,
+		End:         endPos,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+
+	textStart := prefix
+	for textStart < lineEnd && s[textStart] == ' ' {
+		textStart += 1
+	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, prefix))
+	node.addSyntax(TokenTrivia, _rangeIn(s, ctx.P, prefix, textStart))
+	textEnd := len(strings.TrimRight(s[:lineEnd], " "))
+	if textEnd < textStart {
+		// empty caption
+		textEnd = textStart
+	}
+	labelStart := strings.LastIndex(s[textStart:textEnd], "{#")
+	if labelStart >= 0 {
+		labelStart += textStart
+		if !_isLabelSuffix(s[labelStart:textEnd]) {
+			labelStart = -1
+		}
+	}
+	if labelStart >= 0 {
+		textEnd = len(strings.TrimRight(s[:labelStart], " "))
+		if textEnd < textStart {
+			textEnd = textStart
+		}
+	}
+
+	curCtx := ctx
+	curCtx.P.ConsumeStr(s[:textStart])
+	curCtx.Parent = node
+	curCtx.LeftSibling = nil
+	textnode := _textOrEmpty(s[textStart:textEnd], curCtx)
+	node.Children = append(node.Children, textnode)
+	if labelStart >= 0 {
+		curCtx.P = ctx.P
+		curCtx.P.ConsumeStr(s[:labelStart])
+		curCtx.LeftSibling = textnode
+		node.addSyntax(TokenTrivia, _asciiRangeBefore(curCtx.P, labelStart-textEnd))
+		node.Children = append(node.Children, _parseLabel(s[labelStart:], curCtx))
+	}
+	return node
+}
+
 func parseQuoteBlock(s string, ctx ParseContext) *AstNode {
 	if len(s) < 1 || s[0] != '>' {
 		return nil
@@ -460,6 +887,8 @@ func parseQuoteBlock(s string, ctx ParseContext) *AstNode {
 		i += 1
 	}
 	node.Type = &blkType
+	node.addSyntax(TokenMarker, _asciiRange(node.Start, i))
+	level := i
 	for i < len(s) {
 		if s[i] != ' ' {
 			break
@@ -467,6 +896,7 @@ func parseQuoteBlock(s string, ctx ParseContext) *AstNode {
 		i += 1
 		curCtx.P.Consume(' ')
 	}
+	node.addSyntax(TokenTrivia, _asciiRangeBefore(curCtx.P, i-level))
 	var textnode *AstNode
 	if i >= end {
 		textnode = &AstNode{
@@ -480,7 +910,7 @@ func parseQuoteBlock(s string, ctx ParseContext) *AstNode {
 		textnode = ctx.ParseText(s[i:end], curCtx)
 	}
 	if textnode == nil {
-		log.Panicf("Failed to parse quote: %s", s)
+		_bug(curCtx.P, curCtx.P, "failed to parse quote: %s", s)
 	}
 	node.Children = append(node.Children, textnode)
 	return node
@@ -496,8 +926,8 @@ func parseHorizontalRule(s string, ctx ParseContext) *AstNode {
 	if symbol != '*' && symbol != '-' {
 		return nil
 	}
-	for _, c := range s {
-		pos.Consume(c)
+	for i, c := range s {
+		pos.consume(c, _runeSize(s, i, c))
 		if c == '\n' {
 			break
 		} else if c != symbol {
@@ -516,10 +946,20 @@ func parseHorizontalRule(s string, ctx ParseContext) *AstNode {
 		Parent:      ctx.Parent,
 		LeftSibling: ctx.LeftSibling,
 	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, symbolCnt))
 	return node
 }
 
 func parseList(s string, ctx ParseContext) *AstNode {
+	return _parseList(s, ctx, true)
+}
+
+// "- [ ]" is plain text like CommonMark
+func parsePlainList(s string, ctx ParseContext) *AstNode {
+	return _parseList(s, ctx, false)
+}
+
+func _parseList(s string, ctx ParseContext, allowTask bool) *AstNode {
 	if len(s) == 0 {
 		return nil
 	}
@@ -538,14 +978,14 @@ func parseList(s string, ctx ParseContext) *AstNode {
 		}
 		start := 1
 		taskPrefixLen := len("- [ ]")
-		if len(s) >= taskPrefixLen && s[:taskPrefixLen] == "- [ ]" {
+		if allowTask && len(s) >= taskPrefixLen && s[:taskPrefixLen] == "- [ ]" {
 			itemType = ListItem{IsTask: true, IsFinished: false}
 			start = taskPrefixLen
-		} else if len(s) >= taskPrefixLen && s[:taskPrefixLen] == "- [x]" {
+		} else if allowTask && len(s) >= taskPrefixLen && s[:taskPrefixLen] == "- [x]" {
 			itemType = ListItem{IsTask: true, IsFinished: true}
 			start = taskPrefixLen
 		} else if s[0] != '-' {
-			dotPos := _findInLine(s, ".")
+			dotPos := _findInLine(s[:_lineEnd(s, 0)], ".")
 			if dotPos <= 0 {
 				return nil
 			}
@@ -559,6 +999,15 @@ func parseList(s string, ctx ParseContext) *AstNode {
 		node.End.ConsumeStr(s[:start])
 		contentStart := node.End
 		node.Type = &itemType
+		if itemType.IsTask {
+			box := _asciiRangeBefore(contentStart, len("[ ]"))
+			space := _asciiRangeBefore(box.Start, 1)
+			node.addSyntax(TokenMarker, _asciiRangeBefore(space.Start, 1))
+			node.addSyntax(TokenTrivia, space)
+			node.addSyntax(TokenMarker, box)
+		} else {
+			node.addSyntax(TokenMarker, _asciiRange(node.Start, start))
+		}
 
 		lineBrk := -1
 		text := ""
@@ -580,6 +1029,7 @@ func parseList(s string, ctx ParseContext) *AstNode {
 		curCtx.Parent = node
 		curCtx.LeftSibling = nil
 		textnode := _textOrEmpty(text, curCtx)
+		textnode.addSyntax(TokenTrivia, _asciiRange(contentStart, len(text)-len(strings.TrimLeft(text, " \t"))))
 		node.Children = append(node.Children, textnode)
 		return node
 	}
@@ -602,7 +1052,7 @@ func parseList(s string, ctx ParseContext) *AstNode {
 	}
 	curOrder := fstListItem.Type.(*ListItem).Order + 1
 	listType.IsOrdered = fstListItem.Type.(*ListItem).IsOrdered
-	listType.IsTask = fstListItem.Type.(*ListItem).IsFinished
+	listType.IsTask = fstListItem.Type.(*ListItem).IsTask
 	listnode.Type = &listType
 	listnode.Children = append(listnode.Children, fstListItem)
 	curCtx.LeftSibling = fstListItem
@@ -610,7 +1060,10 @@ func parseList(s string, ctx ParseContext) *AstNode {
 
 	curIdx := curCtx.P.Offset - ctx.P.Offset
 	for curIdx < len(s) {
-		lstItem := fParseListLine(s[curIdx:], curCtx)
+		indent, _ := _indentPrefix(s[curIdx:], ctx.Indent)
+		itemCtx := curCtx
+		itemCtx.P.ConsumeStr(s[curIdx : curIdx+indent])
+		lstItem := fParseListLine(s[curIdx+indent:], itemCtx)
 
 		if lstItem == nil {
 			break
@@ -648,8 +1101,8 @@ func parseEmphasis(s string, ctx ParseContext) *AstNode {
 	curCtx := ctx
 	curCtx.P.Consume(rune(symbol))
 	curCtx.P.Consume(rune(symbol))
-	for _, c := range s[2:] {
-		curCtx.P.Consume(c)
+	for i, c := range s[2:] {
+		curCtx.P.consume(c, _runeSize(s, i+2, c))
 		if c == rune(symbol) && lastSymbol {
 			foundEnd = true
 			break
@@ -675,6 +1128,8 @@ func parseEmphasis(s string, ctx ParseContext) *AstNode {
 		Parent:      ctx.Parent,
 		LeftSibling: ctx.LeftSibling,
 	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 2))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(curCtx.P, 2))
 	return node
 }
 
@@ -689,8 +1144,8 @@ func parseItalic(s string, ctx ParseContext) *AstNode {
 	foundEnd := false
 	curCtx := ctx
 	curCtx.P.Consume(rune(symbol))
-	for _, c := range s[1:] {
-		curCtx.P.Consume(c)
+	for i, c := range s[1:] {
+		curCtx.P.consume(c, _runeSize(s, i+1, c))
 		if c == rune(symbol) {
 			foundEnd = true
 			break
@@ -712,6 +1167,8 @@ func parseItalic(s string, ctx ParseContext) *AstNode {
 		Parent:      ctx.Parent,
 		LeftSibling: ctx.LeftSibling,
 	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 1))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(curCtx.P, 1))
 	return node
 }
 
@@ -750,14 +1207,13 @@ func parseStrikeThrough(s string, ctx ParseContext) *AstNode {
 		Parent:      ctx.Parent,
 		LeftSibling: ctx.LeftSibling,
 	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 2))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(endPos, 2))
 	curCtx := ctx
 	curCtx.P.ConsumeStr("~~")
 	curCtx.Parent = node
 	curCtx.LeftSibling = nil
-	textnode := ctx.ParseText(s[2:end-1], curCtx)
-	if textnode == nil {
-		log.Panicf("Failed to parse text: %s", s[2:end-1])
-	}
+	textnode := _textOrEmpty(s[2:end-1], curCtx)
 	node.Children = append(node.Children, textnode)
 	return node
 }
@@ -766,47 +1222,21 @@ func parseCode(s string, ctx ParseContext) *AstNode {
 	if len(s) < 2 {
 		return nil
 	}
-	curCtx := ctx
-	foundEnd := false
-	inSeq := 0
-	leadingBackticks := 0
-	newS := s
-
-	for len(newS) > 0 {
-		if newS[0] == '`' {
-			curCtx.P.Consume(rune('`'))
-			leadingBackticks += 1
-			newS = newS[1:]
-		} else {
-			break
-		}
-	}
-
-	fRightNotBacktick := func(i int) bool {
-		return i+1 >= len(newS) || !utf8.RuneStart(newS[i+1]) || newS[i+1] != '`'
-	}
-	for i, c := range newS {
-		curCtx.P.Consume(c)
-		if c == '`' {
-			inSeq += 1
-			if inSeq == leadingBackticks && fRightNotBacktick(i) {
-				foundEnd = true
-				break
-			}
-		} else {
-			inSeq = 0
-		}
-	}
-	if !foundEnd {
+	leadingBackticks, end := ctx.scan.findBacktickRun(s, ctx.P.Offset)
+	if end < 0 {
 		return nil
 	}
+	endPos := ctx.P
+	endPos.ConsumeStr(s[:end+leadingBackticks])
 	node := &AstNode{
-		Type:        &Code{},
+		Type:        &Code{BodyRange: _rangeIn(s, ctx.P, leadingBackticks, end)},
 		Start:       ctx.P,
-		End:         curCtx.P,
+		End:         endPos,
 		Parent:      ctx.Parent,
 		LeftSibling: ctx.LeftSibling,
 	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, leadingBackticks))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(endPos, leadingBackticks))
 	return node
 }
 
@@ -825,7 +1255,7 @@ func parseMath(s string, ctx ParseContext) *AstNode {
 	curCtx.P.Consume(rune('$'))
 	foundEnd := false
 	for i, c := range newS {
-		curCtx.P.Consume(c)
+		curCtx.P.consume(c, _runeSize(newS, i, c))
 		if c == '$' {
 			if i+1 >= len(newS) || newS[i+1] != '$' {
 				foundEnd = true
@@ -839,18 +1269,28 @@ func parseMath(s string, ctx ParseContext) *AstNode {
 		return nil
 	}
 	node := &AstNode{
-		Type:        &Math{},
+		Type:        &Math{BodyRange: _rangeIn(s, ctx.P, 1, curCtx.P.Offset-ctx.P.Offset-1)},
 		Start:       ctx.P,
 		End:         curCtx.P,
 		Parent:      ctx.Parent,
 		LeftSibling: ctx.LeftSibling,
 	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 1))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(curCtx.P, 1))
 	return node
 }
 
 // url "title" | <url> "title", input should contain no '\n'
 func _parseLinkTitle(s string) (bool, string, string) {
-	var link, title string
+	ok, offsets := _parseLinkTitleOffsets(s)
+	if !ok {
+		return false, "", ""
+	}
+	return true, s[offsets[0]:offsets[1]], s[offsets[2]:offsets[3]]
+}
+
+// offsets of the link and the title of _parseLinkTitle, the title is empty after the link if there is none
+func _parseLinkTitleOffsets(s string) (bool, [4]int) {
 	linkStart, linkEnd := -1, -1
 	for i, c := range s {
 		if linkStart < 0 {
@@ -865,109 +1305,133 @@ func _parseLinkTitle(s string) (bool, string, string) {
 		}
 	}
 	if linkStart < 0 || linkEnd < 0 {
-		return false, "", ""
+		return false, [4]int{}
 	}
-	link = s[linkStart:linkEnd]
 	titleStart, titleEnd := -1, -1
 	for i, c := range s[linkEnd:] {
 		if titleStart < 0 {
 			if c == '"' {
 				titleStart = i + linkEnd + 1
 			} else if c != ' ' {
-				return false, "", ""
+				return false, [4]int{}
 			}
 		} else if titleEnd < 0 {
 			if c == '"' {
 				titleEnd = i + linkEnd
 			}
 		} else if c != ' ' {
-			return false, "", ""
+			return false, [4]int{}
 		}
 	}
 	if titleStart >= 0 && titleEnd <= 0 {
-		return false, "", ""
+		return false, [4]int{}
 	}
-	if titleStart >= 0 && titleEnd >= 0 {
-		title = s[titleStart:titleEnd]
+	if titleStart < 0 {
+		titleStart, titleEnd = linkEnd, linkEnd
 	}
-	return true, link, title
+	return true, [4]int{linkStart, linkEnd, titleStart, titleEnd}
 }
 
-func _parseLinkLike(s string, pos Pos) (bool, string, string, string, Pos) {
+// the spaces and the quotes of the title around the offsets of _parseLinkTitleOffsets in s starting at pos
+func _linkTitleSyntax(syntax []SyntaxRange, s string, pos Pos, offsets [4]int) []SyntaxRange {
+	syntax = _appendSyntax(syntax, TokenTrivia, _rangeIn(s, pos, 0, offsets[0]))
+	if offsets[2] == offsets[1] {
+		return _appendSyntax(syntax, TokenTrivia, _rangeIn(s, pos, offsets[1], len(s)))
+	}
+	syntax = _appendSyntax(syntax, TokenTrivia, _rangeIn(s, pos, offsets[1], offsets[2]-1))
+	syntax = _appendSyntax(syntax, TokenMarker, _rangeIn(s, pos, offsets[2]-1, offsets[2]))
+	syntax = _appendSyntax(syntax, TokenMarker, _rangeIn(s, pos, offsets[3], offsets[3]+1))
+	return _appendSyntax(syntax, TokenTrivia, _rangeIn(s, pos, offsets[3]+1, len(s)))
+}
+
+// [name](link "title") parsed by _parseLinkLike
+type linkLike struct {
+	name, link, title                string
+	end                              Pos
+	nameRange, linkRange, titleRange Range
+	// the brackets, the parentheses and the title syntax
+	syntax []SyntaxRange
+}
+
+// the destination and the title follow the rules of CommonMark if strict, see _parseLinkDestination
+func _parseLinkLike(s string, pos Pos, scan *scanCache, strict bool) (bool, linkLike) {
 	if len(s) == 0 || s[0] != '[' {
-		return false, "", "", "", Pos{}
+		return false, linkLike{}
 	}
 	curPos := pos
-	newLineIdx := strings.Index(s, "\n")
-	rightIdx := -1
-	leftBracketMet := 0
-	lastEscape := false
-	for i, c := range s[1:] {
-		if newLineIdx >= 0 && i >= newLineIdx {
-			rightIdx = -1
-			break
-		}
-		if !lastEscape && c == '[' {
-			leftBracketMet += 1
-		}
-		if !lastEscape && c == ']' {
-			if leftBracketMet == 0 {
-				rightIdx = i + 1 // don't omit the [ at the beginning
-				break
-			}
-			leftBracketMet -= 1
-		}
-		if c == '\\' {
-			lastEscape = true
-		} else {
-			lastEscape = false
-		}
+	rightIdx := scan.findRightBracket(s, pos.Offset)
+	if rightIdx < 0 {
+		return false, linkLike{}
 	}
-	if rightIdx < 0 || (newLineIdx >= 0 && newLineIdx < rightIdx) {
-		return false, "", "", "", Pos{}
-	}
-	name := s[1:rightIdx]
+	link := linkLike{name: s[1:rightIdx], nameRange: _rangeIn(s, pos, 1, rightIdx)}
 	curPos.ConsumeStr(s[:rightIdx+1])
 
 	// focus on the (<url> "title"?) part
 	newS := s[rightIdx+1:]
 	if len(newS) < 2 || newS[0] != '(' {
-		return false, "", "", "", Pos{}
+		return false, linkLike{}
 	}
-	newLineIdx = strings.Index(newS, "\n")
-
-	rightIdx = _findInLine(newS, ")")
-	if rightIdx < 0 || (newLineIdx >= 0 && newLineIdx < rightIdx) {
-		return false, "", "", "", Pos{}
+	var ok bool
+	var offsets [4]int
+	if strict {
+		ok, offsets, rightIdx = _parseLinkDestination(newS[1:])
+		rightIdx += 1
+	} else {
+		rightIdx = scan.findByte(newS, pos.Offset+rightIdx+1, ')')
+		if rightIdx < 0 || strings.IndexByte(newS[:rightIdx], '\n') >= 0 {
+			return false, linkLike{}
+		}
+		ok, offsets = _parseLinkTitleOffsets(newS[1:rightIdx])
 	}
-
-	ok, link, title := _parseLinkTitle(newS[1:rightIdx])
 	if !ok {
-		return false, "", "", "", Pos{}
-	}
-
-	curPos.ConsumeStr(s[:rightIdx+1])
-	return true, name, link, title, curPos
+		return false, linkLike{}
+	}
+	link.link, link.title = newS[1+offsets[0]:1+offsets[1]], newS[1+offsets[2]:1+offsets[3]]
+	link.linkRange = _rangeIn(newS, curPos, 1+offsets[0], 1+offsets[1])
+	link.titleRange = _rangeIn(newS, curPos, 1+offsets[2], 1+offsets[3])
+	link.syntax = _appendSyntax(link.syntax, TokenMarker, _asciiRange(pos, 1))
+	link.syntax = _appendSyntax(link.syntax, TokenMarker, Range{Start: _asciiRangeBefore(curPos, 1).Start, End: _asciiRange(curPos, 1).End})
+	inner := curPos
+	inner.Consume('(')
+	link.syntax = _linkTitleSyntax(link.syntax, newS[1:rightIdx], inner, offsets)
+
+	curPos.ConsumeStr(newS[:rightIdx+1])
+	link.syntax = _appendSyntax(link.syntax, TokenMarker, _asciiRangeBefore(curPos, 1))
+	link.end = curPos
+	return true, link
 }
 
 func parseLink(s string, ctx ParseContext) *AstNode {
-	ret, name, link, title, pos := _parseLinkLike(s, ctx.P)
+	return _parseLink(s, ctx, false)
+}
+
+// destinations and titles like CommonMark
+func parseStrictLink(s string, ctx ParseContext) *AstNode {
+	return _parseLink(s, ctx, true)
+}
+
+func _parseLink(s string, ctx ParseContext, strict bool) *AstNode {
+	ret, link := _parseLinkLike(s, ctx.P, ctx.scan, strict)
 	if ret {
 		node := &AstNode{
-			Type:        &Link{Link: link, Title: title},
+			Type: &Link{
+				Link:       link.link,
+				Title:      link.title,
+				TextRange:  link.nameRange,
+				LinkRange:  link.linkRange,
+				TitleRange: link.titleRange,
+			},
 			Start:       ctx.P,
-			End:         pos,
+			End:         link.end,
 			Parent:      ctx.Parent,
 			LeftSibling: ctx.LeftSibling,
+			Syntax:      link.syntax,
 		}
 		curCtx := ctx
 		curCtx.LeftSibling = nil
 		curCtx.Parent = node
 		curCtx.P.Consume('[')
-		textnode := ctx.ParseText(name, curCtx)
-		if textnode == nil {
-			log.Panicf("Failed to parse link %s", s)
-		}
+		textnode := _textOrEmpty(link.name, curCtx)
 		node.Children = append(node.Children, textnode)
 		return node
 	} else {
@@ -979,7 +1443,7 @@ func parseSimpleLink(s string, ctx ParseContext) *AstNode {
 	if len(s) <= 2 || s[0] != '<' {
 		return nil
 	}
-	rightIdx := _findInLine(s, ">")
+	rightIdx := ctx.scan.findByte(s, ctx.P.Offset, '>')
 
 	if rightIdx <= 0 {
 		return nil
@@ -995,6 +1459,8 @@ func parseSimpleLink(s string, ctx ParseContext) *AstNode {
 			Parent:      ctx.Parent,
 			LeftSibling: ctx.LeftSibling,
 		}
+		node.addSyntax(TokenMarker, _asciiRange(ctx.P, 1))
+		node.addSyntax(TokenMarker, _asciiRangeBefore(endPos, 1))
 		return node
 	} else {
 		return nil
@@ -1002,6 +1468,15 @@ func parseSimpleLink(s string, ctx ParseContext) *AstNode {
 }
 
 func parseReferenceLink(s string, ctx ParseContext) *AstNode {
+	return _parseReferenceLink(s, ctx, false)
+}
+
+// [text][index] without a space between the brackets and the collapsed [text][] like CommonMark
+func parseStrictReferenceLink(s string, ctx ParseContext) *AstNode {
+	return _parseReferenceLink(s, ctx, true)
+}
+
+func _parseReferenceLink(s string, ctx ParseContext, strict bool) *AstNode {
 	lbr1, rbr1, lbr2, rbr2 := -1, -1, -1, -1
 	if len(s) <= 4 {
 		return nil
@@ -1010,30 +1485,42 @@ func parseReferenceLink(s string, ctx ParseContext) *AstNode {
 		return nil
 	}
 	lbr1 = 0
-	rbr1 = _findInLine(s[1:], "]") + 1
-	if rbr1 < 0 || rbr1+2 >= len(s) {
+	rbr1 = ctx.scan.findByte(s[1:], ctx.P.Offset+1, ']')
+	if rbr1 < 0 || rbr1+2 >= len(s) || (!strict && rbr1+3 >= len(s)) {
 		return nil
 	}
+	rbr1 += 1
 	lbr2 = rbr1 + 1
-	if s[lbr2] == ' ' {
+	if s[lbr2] == ' ' && !strict {
 		lbr2 += 1
 	}
 	if s[lbr2] != '[' {
 		return nil
 	}
-	rbr2 = _findInLine(s[lbr2:], "]") + lbr2
+	rbr2 = ctx.scan.findByte(s[lbr2:], ctx.P.Offset+lbr2, ']')
 	if rbr2 < 0 {
 		return nil
 	}
+	rbr2 += lbr2
+	index := s[lbr2+1 : rbr2]
+	if strict && len(index) == 0 {
+		index = s[lbr1+1 : rbr1]
+	}
 	endPos := ctx.P
 	endPos.ConsumeStr(s[:rbr2+1])
 	node := &AstNode{
-		Type:        &ReferenceLink{Index: s[lbr2+1 : rbr2]},
+		Type:        &ReferenceLink{Index: index},
 		Start:       ctx.P,
 		End:         endPos,
 		Parent:      ctx.Parent,
 		LeftSibling: ctx.LeftSibling,
 	}
+	second := _rangeIn(s, ctx.P, lbr2, rbr2+1)
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 1))
+	node.addSyntax(TokenMarker, _asciiRange(_asciiRangeBefore(second.Start, lbr2-rbr1).Start, 1))
+	node.addSyntax(TokenTrivia, _asciiRangeBefore(second.Start, lbr2-rbr1-1))
+	node.addSyntax(TokenMarker, _asciiRange(second.Start, 1))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(endPos, 1))
 	curCtx := ctx
 	curCtx.LeftSibling = nil
 	curCtx.Parent = node
@@ -1043,6 +1530,10 @@ func parseReferenceLink(s string, ctx ParseContext) *AstNode {
 		return nil
 	}
 	textnode := ctx.ParseText(text, curCtx)
+	if textnode == nil {
+		// only line breaks
+		return nil
+	}
 	node.Children = append(node.Children, textnode)
 	return node
 }
@@ -1052,7 +1543,7 @@ func parseReferenceLinkIndex(s string, ctx ParseContext) *AstNode {
 		return nil
 	}
 	lbr := 0
-	rbr := _findInLine(s, "]")
+	rbr := _findInLine(s[:_lineEnd(s, 0)], "]")
 	if rbr < 0 || rbr+1 >= len(s) || s[rbr+1] != ':' {
 		return nil
 	}
@@ -1069,12 +1560,13 @@ func parseReferenceLinkIndex(s string, ctx ParseContext) *AstNode {
 		pos.ConsumeStr(s[:newLineIndex+1])
 	}
 
-	ok, link, title := _parseLinkTitle(s[rbr+2 : newLineIndex])
+	linkTitle := s[rbr+2 : newLineIndex]
+	ok, offsets := _parseLinkTitleOffsets(linkTitle)
 	if !ok {
 		return nil
 	}
-	indexType.Link = link
-	indexType.Title = title
+	indexType.Link = linkTitle[offsets[0]:offsets[1]]
+	indexType.Title = linkTitle[offsets[2]:offsets[3]]
 
 	node := &AstNode{
 		Type:        &indexType,
@@ -1083,6 +1575,10 @@ func parseReferenceLinkIndex(s string, ctx ParseContext) *AstNode {
 		Parent:      ctx.Parent,
 		LeftSibling: ctx.LeftSibling,
 	}
+	linkTitleRange := _rangeIn(s, ctx.P, rbr+2, newLineIndex)
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 1))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(linkTitleRange.Start, 2))
+	node.Syntax = _linkTitleSyntax(node.Syntax, linkTitle, linkTitleRange.Start, offsets)
 	return node
 }
 
@@ -1090,7 +1586,7 @@ func parseFootNote(s string, ctx ParseContext) *AstNode {
 	if len(s) <= 3 || s[0] != '[' || s[1] != '^' {
 		return nil
 	}
-	rbr := _findInLine(s, "]")
+	rbr := ctx.scan.findByte(s, ctx.P.Offset, ']')
 	if rbr <= 2 {
 		return nil
 	}
@@ -1104,31 +1600,84 @@ func parseFootNote(s string, ctx ParseContext) *AstNode {
 		Parent:      ctx.Parent,
 		LeftSibling: ctx.LeftSibling,
 	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 2))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(endPos, 1))
 	return node
 }
 
+// columns of indentation of the continuation lines of a footnote
+const footNoteIndent = 4
+
+func _isBlankLine(line string) bool {
+	return len(strings.Trim(line, " \t")) == 0
+}
+
+// end of the line starting at s[start], '\n' not included
+func _lineEnd(s string, start int) int {
+	newLineIdx := strings.Index(s[start:], "\n")
+	if newLineIdx < 0 {
+		return len(s)
+	}
+	return start + newLineIdx
+}
+
+/*
+ * [^index]: first paragraph
+ *     continuation of the first paragraph
+ *
+ *     other blocks indented by footNoteIndent
+ */
 func parseFootNoteIndex(s string, ctx ParseContext) *AstNode {
 	if len(s) <= 4 || s[0] != '[' || s[1] != '^' {
 		return nil
 	}
-	rbr := _findInLine(s, "]")
+	rbr := _findInLine(s[:_lineEnd(s, 0)], "]")
 	if rbr <= 2 {
 		return nil
 	}
-	endPos := ctx.P
 	index := s[2:rbr]
 
 	if rbr+1 >= len(s) || s[rbr+1] != ':' {
 		return nil
 	}
 
-	endLine := strings.Index(s, "\n")
-	if endLine < 0 {
-		endLine = len(s)
-		endPos.ConsumeStr(s)
-	} else {
-		endPos.ConsumeStr(s[:endLine+1])
+	// the first paragraph ends at a blank or unindented line
+	paraEnd := _lineEnd(s, 0)
+	for paraEnd+1 < len(s) {
+		lineEnd := _lineEnd(s, paraEnd+1)
+		line := s[paraEnd+1 : lineEnd]
+		if _, ok := _indentPrefix(line, footNoteIndent); !ok || _isBlankLine(line) {
+			break
+		}
+		paraEnd = lineEnd
+	}
+	// the following blocks end before the first unindented line
+	blockStart := paraEnd + 1
+	blockEnd := blockStart
+	for lineStart := blockStart; lineStart < len(s); {
+		lineEnd := _lineEnd(s, lineStart)
+		line := s[lineStart:lineEnd]
+		if !_isBlankLine(line) {
+			if _, ok := _indentPrefix(line, footNoteIndent); !ok {
+				break
+			}
+			blockEnd = lineEnd + 1
+			if blockEnd > len(s) {
+				blockEnd = len(s)
+			}
+		}
+		lineStart = lineEnd + 1
+	}
+	end := paraEnd + 1
+	if blockEnd > blockStart {
+		end = blockEnd
+	}
+	if end > len(s) {
+		end = len(s)
 	}
+
+	endPos := ctx.P
+	endPos.ConsumeStr(s[:end])
 	node := &AstNode{
 		Type:        &FootNoteIndex{Index: index},
 		Start:       ctx.P,
@@ -1138,51 +1687,290 @@ func parseFootNoteIndex(s string, ctx ParseContext) *AstNode {
 	}
 	textStart := ctx.P
 	textStart.ConsumeStr(s[:rbr+2])
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 2))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(textStart, 2))
 	curCtx := ctx
 	curCtx.P = textStart
 	curCtx.Parent = node
 	curCtx.LeftSibling = nil
-	var textnode *AstNode
-	if rbr+2 < endLine {
-		textnode = ctx.ParseText(s[rbr+2:endLine], curCtx)
-		if textnode == nil {
-			log.Panicf("Failed to parse text %s", s[:endLine])
+	text := s[rbr+2 : paraEnd]
+	textnode := _textOrEmpty(text, curCtx)
+	textnode.addSyntax(TokenTrivia, _asciiRange(textStart, len(text)-len(strings.TrimLeft(text, " \t"))))
+	node.Children = append(node.Children, textnode)
+
+	if blockEnd > blockStart {
+		curCtx.P.ConsumeStr(s[rbr+2 : blockStart])
+		curCtx.LeftSibling = textnode
+		curCtx.Indent = ctx.Indent + footNoteIndent
+		node.Children = append(node.Children, ctx.ParseBlocks(s[blockStart:blockEnd], curCtx)...)
+	}
+	return node
+}
+
+// ^[inline footnote]
+func parseInlineFootNote(s string, ctx ParseContext) *AstNode {
+	if len(s) <= 3 || s[0] != '^' || s[1] != '[' {
+		return nil
+	}
+	rbr := ctx.scan.findRightBracket(s[1:], ctx.P.Offset+1) + 1
+	if rbr <= 2 {
+		return nil
+	}
+	endPos := ctx.P
+	endPos.ConsumeStr(s[:rbr+1])
+	node := &AstNode{
+		Type:        &InlineFootNote{},
+		Start:       ctx.P,
+		End:         endPos,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 2))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(endPos, 1))
+	curCtx := ctx
+	curCtx.LeftSibling = nil
+	curCtx.Parent = node
+	curCtx.P.ConsumeStr("^[")
+	textnode := _textOrEmpty(s[2:rbr], curCtx)
+	node.Children = append(node.Children, textnode)
+	return node
+}
+
+// *[abbr]: definition
+func parseAbbreviationIndex(s string, ctx ParseContext) *AstNode {
+	if len(s) <= 4 || s[0] != '*' || s[1] != '[' {
+		return nil
+	}
+	endLine := _lineEnd(s, 0)
+	rbr := _findInLine(s[:endLine], "]")
+	if rbr <= 2 || rbr+1 >= len(s) || s[rbr+1] != ':' {
+		return nil
+	}
+	end := endLine + 1
+	if end > len(s) {
+		end = len(s)
+	}
+	endPos := ctx.P
+	endPos.ConsumeStr(s[:end])
+	node := &AstNode{
+		Type: &AbbreviationIndex{
+			Abbr:       s[2:rbr],
+			Definition: strings.Trim(s[rbr+2:endLine], " \t"),
+		},
+		Start:       ctx.P,
+		End:         endPos,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+	definition := _rangeIn(s, ctx.P, rbr+2, endLine)
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 2))
+	node.addSyntax(TokenMarker, _asciiRangeBefore(definition.Start, 2))
+	spaces := len(s[rbr+2:endLine]) - len(strings.TrimLeft(s[rbr+2:endLine], " \t"))
+	node.addSyntax(TokenTrivia, _asciiRange(definition.Start, spaces))
+	return node
+}
+
+var citationKeyRegex = regexp.MustCompile(`^[\pL\pN_](?:[\pL\pN_:.#$%&+?<>~/-]*[\pL\pN_])?`)
+var citationLocatorRegex = regexp.MustCompile(`^(?:(?:p|pp|page|pages|chap|chapter|sec|section|fig|figure|vol|volume|no|line|lines|para|§|¶)\.?\s*)?[0-9ivxlcdmIVXLCDM][^,]*`)
+
+// split ", p. 33, suffix" into locator and suffix
+func _splitCitationLocator(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(strings.TrimPrefix(s, ","))
+	loc := citationLocatorRegex.FindString(s)
+	suffix := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s[len(loc):]), ","))
+	return strings.TrimSpace(loc), suffix
+}
+
+// prefix -@key locator, suffix
+func _parseCitationItem(s string, ctx ParseContext) *AstNode {
+	pos := ctx.P
+	trimmed := strings.TrimLeft(s, " ")
+	pos.ConsumeStr(s[:len(s)-len(trimmed)])
+	s = strings.TrimRight(trimmed, " ")
+
+	at := -1
+	for i, c := range s {
+		if c == '@' && (i == 0 || s[i-1] == ' ' || s[i-1] == '-') {
+			at = i
+			break
 		}
-	} else {
-		textnode = &AstNode{
-			Type:        &Text{},
-			Start:       textStart,
-			End:         textStart,
-			Parent:      node,
-			LeftSibling: nil,
+	}
+	if at < 0 {
+		return nil
+	}
+	item := CitationItem{}
+	prefixEnd := at
+	if at > 0 && s[at-1] == '-' {
+		item.SuppressAuthor = true
+		prefixEnd = at - 1
+	}
+	item.Key = citationKeyRegex.FindString(s[at+1:])
+	if len(item.Key) == 0 {
+		return nil
+	}
+	item.Prefix = strings.TrimSpace(s[:prefixEnd])
+	item.Locator, item.Suffix = _splitCitationLocator(s[at+1+len(item.Key):])
+
+	endPos := pos
+	endPos.ConsumeStr(s)
+	node := &AstNode{
+		Type:        &item,
+		Start:       pos,
+		End:         endPos,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+	node.addSyntax(TokenMarker, _rangeIn(s, pos, prefixEnd, at+1))
+	return node
+}
+
+// [see @key1, p. 33; -@key2]
+func parseCitation(s string, ctx ParseContext) *AstNode {
+	if len(s) < 3 || s[0] != '[' {
+		return nil
+	}
+	rbr := ctx.scan.findRightBracket(s, ctx.P.Offset)
+	if rbr < 0 {
+		return nil
+	}
+	if at := ctx.scan.findByte(s, ctx.P.Offset, '@'); at < 0 || at > rbr {
+		// every item has a key
+		return nil
+	}
+	endPos := ctx.P
+	endPos.ConsumeStr(s[:rbr+1])
+	node := &AstNode{
+		Type:        &Citation{},
+		Start:       ctx.P,
+		End:         endPos,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+	curCtx := ctx
+	curCtx.Parent = node
+	curCtx.LeftSibling = nil
+	node.addSyntax(TokenMarker, _asciiRange(ctx.P, 1))
+	curCtx.P.Consume('[')
+	content := s[1:rbr]
+	for {
+		sep := _findInLine(content, ";")
+		if sep < 0 {
+			sep = len(content)
 		}
+		item := _parseCitationItem(content[:sep], curCtx)
+		if item == nil {
+			return nil
+		}
+		node.Children = append(node.Children, item)
+		node.addSyntax(TokenTrivia, Range{Start: curCtx.P, End: item.Start})
+		node.addSyntax(TokenTrivia, _asciiRange(item.End, sep-len(strings.TrimRight(content[:sep], " "))))
+		curCtx.LeftSibling = item
+		if sep == len(content) {
+			break
+		}
+		curCtx.P.ConsumeStr(content[:sep+1])
+		node.addSyntax(TokenMarker, _asciiRangeBefore(curCtx.P, 1))
+		content = content[sep+1:]
 	}
-	node.Children = append(node.Children, textnode)
+	node.addSyntax(TokenMarker, _asciiRangeBefore(endPos, 1))
+	return node
+}
+
+// @key or @key [locator, suffix]
+func parseInTextCitation(s string, ctx ParseContext) *AstNode {
+	if len(s) < 2 || s[0] != '@' || _isWordRune(ctx.Prev) {
+		return nil
+	}
+	key := citationKeyRegex.FindString(s[1:])
+	if len(key) == 0 {
+		return nil
+	}
+	item := CitationItem{Key: key}
+	end := 1 + len(key)
+	// offset of the '[' of the locator
+	locator := -1
+	if strings.HasPrefix(s[end:], " [") {
+		rbr := ctx.scan.findRightBracket(s[end+1:], ctx.P.Offset+end+1)
+		if rbr > 0 && !strings.Contains(s[end+2:end+1+rbr], "@") {
+			item.Locator, item.Suffix = _splitCitationLocator(s[end+2 : end+1+rbr])
+			locator = end + 1
+			end += rbr + 2
+		}
+	}
+	endPos := ctx.P
+	endPos.ConsumeStr(s[:end])
+	itemNode := &AstNode{
+		Type:  &item,
+		Start: ctx.P,
+		End:   endPos,
+	}
+	itemNode.addSyntax(TokenMarker, _asciiRange(ctx.P, 1))
+	if locator >= 0 {
+		bracket := _rangeIn(s, ctx.P, locator, locator+1)
+		itemNode.addSyntax(TokenTrivia, _asciiRangeBefore(bracket.Start, 1))
+		itemNode.addSyntax(TokenMarker, bracket)
+		itemNode.addSyntax(TokenMarker, _asciiRangeBefore(endPos, 1))
+	}
+	node := &AstNode{
+		Type:        &Citation{InText: true},
+		Start:       ctx.P,
+		End:         endPos,
+		Parent:      ctx.Parent,
+		LeftSibling: ctx.LeftSibling,
+	}
+	itemNode.Parent = node
+	node.Children = append(node.Children, itemNode)
 	return node
 }
 
+// ![caption](link "title"){#fig:label}
 func parseImage(s string, ctx ParseContext) *AstNode {
-	if len(s) < 1 && s[0] != '!' {
+	return _parseImage(s, ctx, false)
+}
+
+// destinations and titles like CommonMark
+func parseStrictImage(s string, ctx ParseContext) *AstNode {
+	return _parseImage(s, ctx, true)
+}
+
+func _parseImage(s string, ctx ParseContext, strict bool) *AstNode {
+	if len(s) < 1 || s[0] != '!' {
 		return nil
 	}
-	ret, name, link, title, pos := _parseLinkLike(s[1:], ctx.P)
+	linkStart := ctx.P
+	linkStart.Consume('!')
+	ret, link := _parseLinkLike(s[1:], linkStart, ctx.scan, strict)
 	if ret {
+		pos := link.end
 		node := &AstNode{
-			Type:        &Image{Link: link, Title: title},
+			Type: &Image{
+				Link:       link.link,
+				Title:      link.title,
+				TextRange:  link.nameRange,
+				LinkRange:  link.linkRange,
+				TitleRange: link.titleRange,
+			},
 			Start:       ctx.P,
 			End:         pos,
 			Parent:      ctx.Parent,
 			LeftSibling: ctx.LeftSibling,
 		}
+		node.addSyntax(TokenMarker, _asciiRange(ctx.P, 1))
+		node.Syntax = append(node.Syntax, link.syntax...)
 		curCtx := ctx
 		curCtx.LeftSibling = nil
 		curCtx.Parent = node
 		curCtx.P.ConsumeStr("![")
-		textnode := ctx.ParseText(name, curCtx)
-		if textnode == nil {
-			log.Panicf("Failed to parse link %s", s)
-		}
+		textnode := _textOrEmpty(link.name, curCtx)
 		node.Children = append(node.Children, textnode)
+
+		curCtx.P = pos
+		curCtx.LeftSibling = textnode
+		if label := _parseLabel(s[pos.Offset-ctx.P.Offset:], curCtx); label != nil {
+			node.Children = append(node.Children, label)
+			node.End = label.End
+		}
 		return node
 	} else {
 		return nil
@@ -1199,7 +1987,7 @@ func parseHtml(s string, ctx ParseContext) *AstNode {
 		start = 2
 		isEnd = true
 	}
-	tagEnd := _findInLine(s, ">")
+	tagEnd := ctx.scan.findByte(s, ctx.P.Offset, '>')
 	if tagEnd < 0 {
 		return nil
 	}
@@ -1233,5 +2021,6 @@ func parseHtml(s string, ctx ParseContext) *AstNode {
 		}
 		node.Type = &HtmlEndTag{Tag: tag}
 	}
+	node.addSyntax(TokenMarker, Range{Start: ctx.P, End: pos})
 	return node
 }
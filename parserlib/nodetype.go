@@ -24,13 +24,18 @@ func (text Text) String() string {
 
 type Header struct {
 	Level uint32
+	// the text without the surrounding spaces and the closing '#'s
+	TextRange Range
 }
 
 func (header Header) String() string {
 	return fmt.Sprintf("Header(%d)", header.Level)
 }
 
-type MathBlock struct{}
+type MathBlock struct {
+	// the lines between the fences
+	BodyRange Range
+}
 
 func (math MathBlock) String() string {
 	return "MathBlock"
@@ -38,6 +43,11 @@ func (math MathBlock) String() string {
 
 type CodeBlock struct {
 	Suffix string
+	// the suffix after the opening fence and the lines between the fences
+	SuffixRange Range
+	BodyRange   Range
+	// columns of indentation removed from the lines of the body, set for indented code without fences
+	Indent uint32
 }
 
 func (code CodeBlock) String() string {
@@ -62,6 +72,7 @@ type TableAlign struct {
 	Aligns []uint32
 }
 type TableLine struct{}
+type TableCaption struct{}
 type Table struct{}
 
 func (head TableHead) String() string {
@@ -76,12 +87,27 @@ func (line TableLine) String() string {
 	return "TableLine"
 }
 
+func (caption TableCaption) String() string {
+	return "TableCaption"
+}
+
 func (table Table) String() string {
 	return "Table"
 }
 
 /* end Table */
 
+// {#kind:name} labels an Image, Table or MathBlock for cross references
+type Label struct {
+	Name string
+	// sequential number among the labels of the same kind, set by ResolveCrossRefs
+	Number uint32
+}
+
+func (label Label) String() string {
+	return fmt.Sprintf("Label(%s)", label.Name)
+}
+
 type QuoteBlock struct {
 	Level uint32
 }
@@ -142,13 +168,19 @@ func (text StrikeThrough) String() string {
 	return "StrikeThrough"
 }
 
-type Code struct{}
+type Code struct {
+	// the code between the backticks
+	BodyRange Range
+}
 
 func (text Code) String() string {
 	return "Code"
 }
 
-type Math struct{}
+type Math struct {
+	// the formula between the '$'s
+	BodyRange Range
+}
 
 func (text Math) String() string {
 	return "Math"
@@ -159,6 +191,11 @@ func (text Math) String() string {
 type Link struct {
 	Link  string
 	Title string
+	// the text in the brackets, the link and the title in the quotes,
+	// the title range is empty after the link if there is no title
+	TextRange  Range
+	LinkRange  Range
+	TitleRange Range
 }
 
 func (link Link) String() string {
@@ -208,17 +245,73 @@ func (footnote FootNoteIndex) String() string {
 	return fmt.Sprintf("FootNoteIndex(%s)", footnote.Index)
 }
 
+// ^[inline footnote]
+type InlineFootNote struct{}
+
+func (footnote InlineFootNote) String() string {
+	return "InlineFootNote"
+}
+
 /* end link */
 
 type Image struct {
 	Link  string
 	Title string
+	// ranges like the ones of Link
+	TextRange  Range
+	LinkRange  Range
+	TitleRange Range
 }
 
 func (image Image) String() string {
 	return "Image"
 }
 
+// *[abbr]: definition
+type AbbreviationIndex struct {
+	Abbr       string
+	Definition string
+}
+
+func (abbr AbbreviationIndex) String() string {
+	return fmt.Sprintf("AbbreviationIndex(%s)", abbr.Abbr)
+}
+
+type Abbreviation struct {
+	Abbr       string
+	Definition string
+}
+
+func (abbr Abbreviation) String() string {
+	return fmt.Sprintf("Abbreviation(%s)", abbr.Abbr)
+}
+
+/* Citation */
+// [prefix @key locator, suffix; -@key2] or @key [locator]
+type Citation struct {
+	InText bool
+}
+
+func (citation Citation) String() string {
+	return "Citation"
+}
+
+type CitationItem struct {
+	Key            string
+	Prefix         string
+	Locator        string
+	Suffix         string
+	SuppressAuthor bool
+	// order of the first citation of Key, set by ResolveCitations
+	Number uint32
+}
+
+func (item CitationItem) String() string {
+	return fmt.Sprintf("CitationItem(%s)", item.Key)
+}
+
+/* end Citation */
+
 type HtmlStartTag struct {
 	Tag     string
 	Content string
@@ -236,6 +329,55 @@ func (html HtmlEndTag) String() string {
 	return fmt.Sprintf("HtmlEndTag(%s)", html.Tag)
 }
 
+// node types holding ranges of the source besides the range of the node
+type rangedType interface {
+	ranges() []*Range
+}
+
+func (header *Header) ranges() []*Range {
+	return []*Range{&header.TextRange}
+}
+
+func (math *MathBlock) ranges() []*Range {
+	return []*Range{&math.BodyRange}
+}
+
+func (code *CodeBlock) ranges() []*Range {
+	return []*Range{&code.SuffixRange, &code.BodyRange}
+}
+
+func (text *Code) ranges() []*Range {
+	return []*Range{&text.BodyRange}
+}
+
+func (text *Math) ranges() []*Range {
+	return []*Range{&text.BodyRange}
+}
+
+func (link *Link) ranges() []*Range {
+	return []*Range{&link.TextRange, &link.LinkRange, &link.TitleRange}
+}
+
+func (image *Image) ranges() []*Range {
+	return []*Range{&image.TextRange, &image.LinkRange, &image.TitleRange}
+}
+
+// apply f to the positions of the node, its syntax and the ranges of its type
+func (node *AstNode) mapPos(f func(Pos) Pos) {
+	node.Start = f(node.Start)
+	node.End = f(node.End)
+	for i := range node.Syntax {
+		node.Syntax[i].Start = f(node.Syntax[i].Start)
+		node.Syntax[i].End = f(node.Syntax[i].End)
+	}
+	if ranged, ok := node.Type.(rangedType); ok {
+		for _, r := range ranged.ranges() {
+			r.Start = f(r.Start)
+			r.End = f(r.End)
+		}
+	}
+}
+
 var str2NodeType = map[string]AstNodeType{
 	"Document":           &Document{},
 	"Text":               &Text{},
@@ -264,6 +406,13 @@ var str2NodeType = map[string]AstNodeType{
 	"Image":              &Image{},
 	"HtmlStartTag":       &HtmlStartTag{},
 	"HtmlEndTag":         &HtmlEndTag{},
+	"InlineFootNote":     &InlineFootNote{},
+	"AbbreviationIndex":  &AbbreviationIndex{},
+	"Abbreviation":       &Abbreviation{},
+	"Citation":           &Citation{},
+	"CitationItem":       &CitationItem{},
+	"TableCaption":       &TableCaption{},
+	"Label":              &Label{},
 }
 
 var str2NodeID = map[string]int{
@@ -294,14 +443,78 @@ var str2NodeID = map[string]int{
 	"Image":              25,
 	"HtmlStartTag":       26,
 	"HtmlEndTag":         27,
+	"InlineFootNote":     28,
+	"AbbreviationIndex":  29,
+	"Abbreviation":       30,
+	"Citation":           31,
+	"CitationItem":       32,
+	"TableCaption":       33,
+	"Label":              34,
+}
+
+// guards str2NodeType, str2NodeID and type2NodeName, which grow by RegisterNodeType
+var nodeTypeLock sync.RWMutex
+
+// names of default and registered node types
+var type2NodeName = map[reflect.Type]string{}
+
+func init() {
+	for name, prototype := range str2NodeType {
+		type2NodeName[reflect.TypeOf(prototype)] = name
+	}
+}
+
+// ids below FirstExtensionNodeTypeId are kept for default node types
+const FirstExtensionNodeTypeId = 256
+
+// RegisterNodeType adds a node type of extension parsers under an id, so that it can be found by name and
+// serialized. prototype should be a pointer to a struct like the default node types. The id is given by the
+// extension, so that it's the same in every process whatever the order of registration, and it must be at
+// least FirstExtensionNodeTypeId and not taken by another name. Registering the same name, id and type
+// again does nothing. Default node types can't be registered under other names.
+func RegisterNodeType(name string, id int, prototype AstNodeType) error {
+	tp := reflect.TypeOf(prototype)
+	if len(name) == 0 || tp == nil || tp.Kind() != reflect.Ptr || tp.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("invalid node type %s: %v", name, tp)
+	}
+	if id < FirstExtensionNodeTypeId {
+		return fmt.Errorf("id %d of node type %s is kept for default node types", id, name)
+	}
+	nodeTypeLock.Lock()
+	defer nodeTypeLock.Unlock()
+	if registered, ok := str2NodeType[name]; ok {
+		if reflect.TypeOf(registered) != tp || str2NodeID[name] != id {
+			return fmt.Errorf("node type %s is already registered", name)
+		}
+		return nil
+	}
+	if registered, ok := type2NodeName[tp]; ok {
+		return fmt.Errorf("%v is already registered as %s", tp, registered)
+	}
+	for registered, registeredId := range str2NodeID {
+		if registeredId == id {
+			return fmt.Errorf("id %d of node type %s is taken by %s", id, name, registered)
+		}
+	}
+	str2NodeID[name] = id
+	str2NodeType[name] = prototype
+	type2NodeName[tp] = name
+	return nil
 }
-var str2NodeIDLock sync.RWMutex
 
 func GetNodeTypeName(nodeTp AstNodeType) string {
-	return reflect.TypeOf(nodeTp).Elem().Name()
+	tp := reflect.TypeOf(nodeTp)
+	nodeTypeLock.RLock()
+	defer nodeTypeLock.RUnlock()
+	if name, ok := type2NodeName[tp]; ok {
+		return name
+	}
+	return tp.Elem().Name()
 }
 
 func GetDefaultTypePtr(key string) AstNodeType {
+	nodeTypeLock.RLock()
+	defer nodeTypeLock.RUnlock()
 	res, ok := str2NodeType[key]
 	if ok {
 		return res
@@ -310,28 +523,22 @@ func GetDefaultTypePtr(key string) AstNodeType {
 	}
 }
 
+// GetNodeTypeIdFromStr returns the id of a default or registered node type, -1 for other names
 func GetNodeTypeIdFromStr(key string) int {
-	str2NodeIDLock.RLock()
-
-	val, ok := str2NodeID[key]
-	if !ok {
-		str2NodeIDLock.RUnlock()
-		str2NodeIDLock.Lock()
-		defer str2NodeIDLock.Unlock()
-		val, ok := str2NodeID[key]
-		if ok {
-			return val
-		}
-		id := len(str2NodeID) + 1
-		str2NodeID[key] = id
+	nodeTypeLock.RLock()
+	defer nodeTypeLock.RUnlock()
+	if id, ok := str2NodeID[key]; ok {
 		return id
-	} else {
-		str2NodeIDLock.RUnlock()
-		return val
 	}
+	return -1
 }
 
+// GetNodeTypeId returns the id of the type of a node, -1 if it's neither default nor registered
 func GetNodeTypeId(tp AstNodeType) int {
-	tpName := GetNodeTypeName(tp)
-	return GetNodeTypeIdFromStr(tpName)
+	nodeTypeLock.RLock()
+	defer nodeTypeLock.RUnlock()
+	if name, ok := type2NodeName[reflect.TypeOf(tp)]; ok {
+		return str2NodeID[name]
+	}
+	return -1
 }
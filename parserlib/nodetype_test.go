@@ -0,0 +1,74 @@
+package parserlib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type callout struct {
+	Kind string
+}
+
+func (c callout) String() string {
+	return "Callout(" + c.Kind + ")"
+}
+
+type otherCallout struct{}
+
+func (c otherCallout) String() string {
+	return "OtherCallout"
+}
+
+type unregistered struct{}
+
+func (c unregistered) String() string {
+	return "Unregistered"
+}
+
+func TestRegisterNodeType(t *testing.T) {
+	assert.Equal(t, 1, GetNodeTypeIdFromStr("Document"))
+	// unknown names don't get ids
+	assert.Equal(t, -1, GetNodeTypeIdFromStr("Unknown"))
+	assert.Equal(t, -1, GetNodeTypeIdFromStr("Unknown"))
+	assert.Equal(t, -1, GetNodeTypeId(&unregistered{}))
+
+	assert.Equal(t, nil, RegisterNodeType("Callout", 300, &callout{}))
+	assert.Equal(t, 300, GetNodeTypeIdFromStr("Callout"))
+	assert.Equal(t, nil, RegisterNodeType("Callout", 300, &callout{}))
+
+	assert.NotNil(t, RegisterNodeType("Callout", 301, &callout{}))
+	assert.NotNil(t, RegisterNodeType("Callout", 300, &otherCallout{}))
+	assert.NotNil(t, RegisterNodeType("Text", 302, &otherCallout{}))
+	assert.NotNil(t, RegisterNodeType("Another", 302, &callout{}))
+	assert.NotNil(t, RegisterNodeType("Value", 302, otherCallout{}))
+	// ids are kept for default node types and can't be shared
+	assert.NotNil(t, RegisterNodeType("Other", 35, &otherCallout{}))
+	assert.NotNil(t, RegisterNodeType("Other", 300, &otherCallout{}))
+	assert.Equal(t, -1, GetNodeTypeIdFromStr("Other"))
+	// default node types keep their names
+	assert.NotNil(t, RegisterNodeType("Foo", 302, &Text{}))
+	assert.Equal(t, "Text", GetNodeTypeName(&Text{}))
+	assert.Equal(t, 2, GetNodeTypeId(&Text{}))
+
+	node := &callout{Kind: "note"}
+	assert.Equal(t, "Callout", GetNodeTypeName(node))
+	assert.Equal(t, 300, GetNodeTypeId(node))
+	assert.Equal(t, &callout{}, GetDefaultTypePtr("Callout"))
+
+	parent := &AstNode{Type: &Document{}}
+	parent.Children = []*AstNode{
+		{Type: &Text{}, Parent: parent},
+		{Type: &unregistered{}, Parent: parent},
+		{Type: node, Parent: parent},
+	}
+	it := AstIterator{Cur: parent.Children[0], Ch: 0}
+	assert.Equal(t, parent.Children[2], it.AstFindRightFirstTypeByStr("Callout", nil).Cur)
+	assert.Equal(t, (*AstNode)(nil), it.AstFindRightFirstTypeByStr("Unknown", nil).Cur)
+
+	parser := GetFullMKParser()
+	ast := parser.Parse("text")
+	ast.Root.Children[0].Type = &unregistered{}
+	_, err := ast.Compact()
+	assert.NotNil(t, err)
+}
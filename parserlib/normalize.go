@@ -0,0 +1,94 @@
+package parserlib
+
+import (
+	"sort"
+	"strings"
+)
+
+const utf8BOM = "\uFEFF"
+
+// sourceMap normalizes a source for parsing and maps the positions in the normalized
+// source back to the original one: the UTF-8 BOM is removed and "\r\n" and "\r" line
+// endings are replaced by "\n". Lines and columns are the same in both sources,
+// the BOM isn't a part of the first line and the line endings aren't parts of lines.
+type sourceMap struct {
+	src  string
+	norm string
+	// bytes of the removed BOM
+	bom int
+	// offsets in norm of the '\n' replacing "\r\n", every one of them shifts
+	// the offsets after it by a byte
+	crlf []int
+}
+
+// whether s has a BOM or "\r" line endings to normalize
+func _needsNormalization(s string) bool {
+	return strings.HasPrefix(s, utf8BOM) || strings.IndexByte(s, '\r') >= 0
+}
+
+// bom reports whether src starts the document and a BOM may be removed
+func newSourceMap(src string, bom bool) *sourceMap {
+	m := &sourceMap{src: src, norm: src}
+	if bom && strings.HasPrefix(src, utf8BOM) {
+		m.bom = len(utf8BOM)
+	}
+	s := src[m.bom:]
+	if strings.IndexByte(s, '\r') < 0 {
+		m.norm = s
+		return m
+	}
+	var norm strings.Builder
+	norm.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\r' {
+			norm.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '\n' {
+			m.crlf = append(m.crlf, norm.Len())
+			i += 1
+		}
+		norm.WriteByte('\n')
+	}
+	m.norm = norm.String()
+	return m
+}
+
+// whether the normalized source differs from the original one
+func (m *sourceMap) changed() bool {
+	return m.norm != m.src
+}
+
+// offset in src of offset in norm, a line end is mapped before its "\r\n"
+func (m *sourceMap) offset(offset int) int {
+	return m.bom + offset + sort.SearchInts(m.crlf, offset)
+}
+
+// map pos in norm to src, base is the offset of both sources in the document
+func (m *sourceMap) pos(pos Pos, base int) Pos {
+	pos.Offset = base + m.offset(pos.Offset-base)
+	return pos
+}
+
+// map the positions of the nodes under root, root included
+func (m *sourceMap) remap(root *AstNode, base int) {
+	if len(m.crlf) == 0 && m.bom == 0 {
+		return
+	}
+	root.PreVisit(func(node *AstNode) {
+		node.mapPos(func(pos Pos) Pos {
+			return m.pos(pos, base)
+		})
+	})
+}
+
+// map the positions carried by a value recovered while parsing norm
+func (m *sourceMap) remapPanic(r interface{}, base int) {
+	switch err := r.(type) {
+	case *Diagnostic:
+		err.Start = m.pos(err.Start, base)
+		err.End = m.pos(err.End, base)
+	case *LimitError:
+		err.Pos = m.pos(err.Pos, base)
+	}
+}
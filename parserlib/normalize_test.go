@@ -0,0 +1,130 @@
+package parserlib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const normalizeDoc = `# Title
+
+Some *text* and ` + "`code`" + `
+over two lines
+
+` + "```go\nfunc main() {}\n```" + `
+
+- item
+	- nested
+
+| a | b |
+| - | - |
+| 1 | 2 |
+`
+
+// the node types and lines and columns of the nodes, and the source of the leaves
+func _normalizeShape(ast *Ast) []string {
+	shape := []string{}
+	ast.Root.PreVisit(func(node *AstNode) {
+		leaf := ""
+		if len(node.Children) == 0 {
+			leaf = ast.src[node.Start.Offset:node.End.Offset]
+		}
+		shape = append(shape, node.Type.String(), node.Start.String(), node.End.String(), leaf)
+	})
+	return shape
+}
+
+func TestNormalizeSource(t *testing.T) {
+	parser := GetFullMKParser()
+	want := parser.Parse(normalizeDoc)
+	assert.Nil(t, want.Validate())
+	wantShape := _normalizeShape(&want)
+
+	for name, src := range map[string]string{
+		"crlf":     strings.ReplaceAll(normalizeDoc, "\n", "\r\n"),
+		"cr":       strings.ReplaceAll(normalizeDoc, "\n", "\r"),
+		"bom+crlf": utf8BOM + strings.ReplaceAll(normalizeDoc, "\n", "\r\n"),
+	} {
+		ast := parser.Parse(src)
+		t.Logf("%s: %s", name, ast.String())
+		assert.Nil(t, ast.Validate(), name)
+		assert.Equal(t, src, ast.Source())
+		assert.Equal(t, len(src), ast.Root.End.Offset)
+
+		shape := _normalizeShape(&ast)
+		if !assert.Equal(t, len(wantShape), len(shape), name) {
+			continue
+		}
+		index := NewLineIndex(src)
+		for i := 0; i < len(shape); i += 4 {
+			assert.Equal(t, wantShape[i], shape[i], name)
+			// leaves keep their text, line endings aside
+			leaf := strings.ReplaceAll(strings.ReplaceAll(shape[i+3], "\r\n", "\n"), "\r", "\n")
+			assert.Equal(t, wantShape[i+3], strings.TrimPrefix(leaf, utf8BOM), name)
+		}
+		ast.Root.PreVisit(func(node *AstNode) {
+			assert.Equal(t, index.Pos(node.Start.Offset), node.Start, name)
+		})
+
+		parallel := parser.ParseParallel(src, 3)
+		assert.Nil(t, parallel.Validate(), name)
+		assert.Equal(t, shape, _normalizeShape(&parallel), name)
+
+		streamed := []string{}
+		err := parser.ParseReader(bytes.NewBufferString(src), func(node *AstNode) error {
+			streamed = append(streamed, node.Start.String(), node.End.String())
+			return nil
+		})
+		assert.Nil(t, err)
+		top := []string{}
+		for _, node := range ast.Root.Children {
+			top = append(top, node.Start.String(), node.End.String())
+		}
+		assert.Equal(t, top, streamed, name)
+	}
+
+	// the header doesn't end with "\r" and the fence is closed
+	ast := parser.Parse("# Title\r\n```\r\ncode\r\n```\r\n")
+	assert.Nil(t, ast.Validate())
+	assert.Equal(t, 2, len(ast.Root.Children))
+	header := ast.Root.Children[0]
+	assert.Equal(t, " Title", ast.Source()[header.Children[0].Start.Offset:header.Children[0].End.Offset])
+	assert.Equal(t, "CodeBlock()", ast.Root.Children[1].Type.String())
+
+	// a BOM doesn't hide the header
+	ast = parser.Parse(utf8BOM + "# Title")
+	assert.Nil(t, ast.Validate())
+	assert.Equal(t, "Header(1)", ast.Root.Children[0].Type.String())
+	assert.Equal(t, Pos{Line: 0, Col: 0, Offset: 3}, ast.Root.Children[0].Start)
+
+	// diagnostics point into the source too
+	_, diags, err := parser.ParseWithDiagnostics("a\r\n\r\n[^x]\r\n")
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(diags)) {
+		assert.Equal(t, Pos{Line: 2, Col: 0, Offset: 5}, diags[0].Start)
+	}
+
+	// edits of a normalized source reparse it as a whole
+	ast = parser.Parse("a\r\n\r\nb\r\n")
+	_, err = ast.Update(TextEdit{Start: 5, End: 6, Text: "# c"})
+	assert.Nil(t, err)
+	assert.Nil(t, ast.Validate())
+	assert.Equal(t, "Header(1)", ast.Root.Children[1].Type.String())
+}
+
+func TestIndentPrefixTabStops(t *testing.T) {
+	cnt, ok := _indentPrefix("\t\tx", 8)
+	assert.Equal(t, 2, cnt)
+	assert.True(t, ok)
+	cnt, ok = _indentPrefix("  \tx", 4)
+	assert.Equal(t, 3, cnt)
+	assert.True(t, ok)
+	cnt, ok = _indentPrefix("\tx", 2)
+	assert.Equal(t, 1, cnt)
+	assert.True(t, ok)
+	cnt, ok = _indentPrefix(" \tx", 8)
+	assert.Equal(t, 2, cnt)
+	assert.False(t, ok)
+}
@@ -0,0 +1,98 @@
+package parserlib
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// segments per worker, more segments balance the load better
+const segmentsPerWorker = 4
+
+// ParseParallel parses s like Parse, but the document is split into segments of whole chunks
+// (see ParseReader) that are parsed concurrently by workers goroutines, GOMAXPROCS if workers <= 0.
+// Post parsers run after all segments are stitched together.
+func (parser *MKParser) ParseParallel(s string, workers int) Ast {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	m := newSourceMap(s, true)
+	s = m.norm
+	ast := Ast{
+		Root: AstNode{
+			Type:  &Document{},
+			Start: Pos{Line: 0, Col: 0, Offset: 0},
+		},
+		src:    s,
+		parser: parser,
+	}
+
+	// merge chunks into segments of about the same size
+	segmentSize := len(s)/(workers*segmentsPerWorker) + 1
+	starts := []int{}
+	for _, offset := range _chunkBoundaries(s) {
+		if offset == len(s) {
+			break
+		}
+		if len(starts) == 0 || offset-starts[len(starts)-1] >= segmentSize {
+			starts = append(starts, offset)
+		}
+	}
+	startPos := make([]Pos, len(starts))
+	for i := range starts {
+		if i > 0 {
+			startPos[i] = startPos[i-1]
+			startPos[i].Line += strings.Count(s[starts[i-1]:starts[i]], "\n")
+			startPos[i].Offset = starts[i]
+		}
+	}
+
+	trace := parser.newTrace(m, 0)
+	results := make([][]*AstNode, len(starts))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				end := len(s)
+				if i+1 < len(starts) {
+					end = starts[i+1]
+				}
+				ctx := ParseContext{
+					P:           startPos[i],
+					Parent:      &ast.Root,
+					LeftSibling: nil,
+					ParseText:   parser.parseText,
+					ParseBlocks: parser.parseBlocks,
+					trace:       trace,
+				}
+				results[i] = parser.parseBlocks(s[starts[i]:end], ctx)
+			}
+		}()
+	}
+	for i := range starts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, nodes := range results {
+		if len(nodes) > 0 && len(ast.Root.Children) > 0 {
+			nodes[0].LeftSibling = ast.Root.Children[len(ast.Root.Children)-1]
+		}
+		ast.Root.Children = append(ast.Root.Children, nodes...)
+	}
+	ast.Root.End = ast.Root.Start
+	ast.Root.End.ConsumeStr(s)
+
+	for _, postParser := range parser.PostParserSeq {
+		postParser(&ast, s)
+	}
+	m.remap(&ast.Root, 0)
+	ast.Root.Start = Pos{}
+	ast.src = m.src
+	ast.setSource()
+	return ast
+}
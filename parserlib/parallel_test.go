@@ -0,0 +1,31 @@
+package parserlib
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseParallel(t *testing.T) {
+	content, err := os.ReadFile("../tests/largemk.md")
+	assert.Equal(t, nil, err)
+	mks := []string{
+		"",
+		"single line",
+		strings.Repeat("# h\n\ntext *a* `b`\n\n```\ncode\n\nmore\n```\n\n| a | b |\n| - | - |\n| 1 | 2 |\n\n- i\n- j\n\n", 50),
+		string(content),
+	}
+	parser := GetFullMKParser()
+	for _, mk := range mks {
+		expected := parser.Parse(mk)
+		for _, workers := range []int{0, 1, 3, 16} {
+			ast := parser.ParseParallel(mk, workers)
+			assert.Equal(t, true, expected.Eq(&ast))
+			assert.Equal(t, expected.String(), ast.String())
+			assert.Equal(t, expected.Root.End, ast.Root.End)
+			assert.Nil(t, ast.Validate())
+		}
+	}
+}
@@ -2,11 +2,21 @@ package parserlib
 
 import (
 	"log"
+	"strings"
+	"unicode/utf8"
 )
 
 type MKParser struct {
 	BlockParserSeq  []BlockParser
 	InlineParserSeq map[rune][]InlineParser
+	PostParserSeq   []PostParser
+	// names of the parsers in BlockParserSeq and InlineParserSeq, empty for anonymous extensions
+	blockParserNames  []string
+	inlineParserNames map[rune][]string
+	// names of the post parsers, a default block parser names the post parser it comes with
+	postParserNames []string
+	// nil unless tracing, see SetTracer
+	tracer Tracer
 }
 
 func (parser *MKParser) parseText(s string, ctx ParseContext) *AstNode {
@@ -16,31 +26,30 @@ func (parser *MKParser) parseText(s string, ctx ParseContext) *AstNode {
 		Parent:      ctx.Parent,
 		LeftSibling: ctx.LeftSibling,
 	}
-	curCtx := ParseContext{
-		P:           ctx.P,
-		Parent:      &node,
-		LeftSibling: nil,
-		ParseText:   ctx.ParseText,
-	}
+	ctx.limit.enter(&ctx)
+	curCtx := ctx
+	curCtx.Parent = &node
+	curCtx.LeftSibling = nil
 
 	textNodeAdded := false
 	textStartPos := curCtx.P
 	fAddPrevTextNode := func() bool {
 		if textNodeAdded {
 			if curCtx.P.Offset == textStartPos.Offset {
-				panic("Bug: should not add an empty text node")
+				_bug(textStartPos, curCtx.P, "should not add an empty text node")
 			}
 			if curCtx.LeftSibling == nil || curCtx.LeftSibling.Type.String() != "Text" {
-				panic("Bug: text node is not really added")
+				_bug(textStartPos, curCtx.P, "text node is not really added")
 			}
 			if curCtx.LeftSibling.End != curCtx.P {
-				panic("Bug: text node added is not complete")
+				_bug(textStartPos, curCtx.P, "text node added is not complete")
 			}
+			ctx.limit.add(1, textStartPos)
 			node.Children = append(node.Children, curCtx.LeftSibling)
 			return true
 		} else {
 			if curCtx.P.Offset != textStartPos.Offset {
-				panic("Bug: should add a new text node")
+				_bug(textStartPos, curCtx.P, "should add a new text node")
 			}
 			return false
 		}
@@ -61,10 +70,16 @@ func (parser *MKParser) parseText(s string, ctx ParseContext) *AstNode {
 	if curIdx == len(s) {
 		return nil
 	}
+	// inline elements don't span paragraphs
+	paraEnd := len(s)
+	if idx := strings.Index(s[curIdx:], "\n\n"); idx >= 0 {
+		paraEnd = curIdx + idx + 1
+	}
+	curCtx.scan = newScanCache(s[:paraEnd], ctx.P.Offset)
 	doubleEnter := false
 	for !doubleEnter {
 		lastEscape, lastEnter := false, false
-		for _, c := range s[curIdx:] {
+		for i, c := range s[curIdx:] {
 			var subnode *AstNode
 			if c == '\n' {
 				if lastEnter {
@@ -81,10 +96,22 @@ func (parser *MKParser) parseText(s string, ctx ParseContext) *AstNode {
 				if lastEscape {
 					lastEscape = false
 				} else if parsers, ok := parser.InlineParserSeq[c]; ok {
+					ctx.limit.check(curCtx.P)
+					offset := curCtx.P.Offset - ctx.P.Offset
+					curCtx.Prev = 0
+					if offset > 0 {
+						curCtx.Prev, _ = utf8.DecodeLastRuneInString(s[:offset])
+					}
 					// in reverse order
 					for i := len(parsers) - 1; i >= 0; i-- {
-						offset := curCtx.P.Offset - ctx.P.Offset
-						subnode = parsers[i](s[offset:], curCtx)
+						if curCtx.trace != nil {
+							name := _parserName(parser.inlineParserNames[c], i)
+							subnode = curCtx.trace.run(TraceInline, name, c, curCtx.P, func() *AstNode {
+								return parsers[i](s[offset:paraEnd], curCtx)
+							})
+						} else {
+							subnode = parsers[i](s[offset:paraEnd], curCtx)
+						}
 						if subnode != nil {
 							break
 						}
@@ -94,9 +121,10 @@ func (parser *MKParser) parseText(s string, ctx ParseContext) *AstNode {
 
 			if subnode != nil {
 				if subnode.End.Offset <= curCtx.P.Offset {
-					panic("Bug: subnode's offset should be larger")
+					_bug(curCtx.P, subnode.End, "subnode's offset should be larger")
 				}
 				fAddPrevTextNode()
+				ctx.limit.add(1, subnode.Start)
 				curCtx.LeftSibling = subnode
 				curCtx.P = subnode.End
 				textStartPos = curCtx.P
@@ -104,7 +132,7 @@ func (parser *MKParser) parseText(s string, ctx ParseContext) *AstNode {
 				node.Children = append(node.Children, subnode)
 				break
 			} else {
-				curCtx.P.Consume(c)
+				curCtx.P.consume(c, _runeSize(s, curIdx+i, c))
 				if textNodeAdded {
 					curCtx.LeftSibling.End = curCtx.P
 				} else {
@@ -134,66 +162,81 @@ func (parser *MKParser) parseText(s string, ctx ParseContext) *AstNode {
 	node.End = curCtx.P
 	if len(node.Children) == 1 && node.Children[0].Type.String() == "Text" {
 		if node.Children[0].LeftSibling != nil {
-			panic("Bug: children's left sibling must be nil")
+			_bug(node.Start, node.End, "children's left sibling must be nil")
 		}
 		if node.Children[0].Start != node.Start || node.Children[0].End != node.End {
-			panic("Bug: children's range mismatches with parent's")
+			_bug(node.Start, node.End, "children's range mismatches with parent's")
 		}
 		node.Start = node.Children[0].Start
 		node.End = node.Children[0].End
 		node.Children = nil
+	} else {
+		ctx.limit.add(1, node.Start)
 	}
 
 	if !doubleEnter && node.End.Offset-ctx.P.Offset != len(s) {
-		panic("Bug: Text should contain all characters of the string")
+		_bug(node.Start, node.End, "Text should contain all characters of the string")
 	}
 	return &node
 }
 
-func (parser *MKParser) Parse(s string) Ast {
-	ast := Ast{
-		Root: AstNode{
-			Type:  &Document{},
-			Start: Pos{Line: 0, Col: 0, Offset: 0},
-		},
-	}
-	ctx := ParseContext{
-		P:           Pos{Line: 0, Col: 0, Offset: 0},
-		Parent:      &ast.Root,
-		LeftSibling: nil,
-		ParseText:   parser.parseText,
+func (parser *MKParser) parseBlock(s string, ctx ParseContext) *AstNode {
+	for j := len(parser.BlockParserSeq) - 1; j >= 0; j-- {
+		var subnode *AstNode
+		if ctx.trace != nil {
+			subnode = ctx.trace.run(TraceBlock, _parserName(parser.blockParserNames, j), 0, ctx.P, func() *AstNode {
+				return parser.BlockParserSeq[j](s, ctx)
+			})
+		} else {
+			subnode = parser.BlockParserSeq[j](s, ctx)
+		}
+		if subnode != nil {
+			return subnode
+		}
 	}
+	return nil
+}
+
+// parse s as a sequence of blocks, s should start at the beginning of a line
+func (parser *MKParser) parseBlocks(s string, ctx ParseContext) []*AstNode {
+	var nodes []*AstNode
+	base := ctx.P.Offset
+	ctx.limit.enter(&ctx)
+	ctx.scan = newScanCache(s, base)
 
 	textStartPos := ctx.P
 	textNodeAdded := false
 	fAddTextNode := func() {
 		if textNodeAdded {
 			if textStartPos == ctx.P {
-				panic("Bug: should not add an empty text node")
+				_bug(textStartPos, ctx.P, "should not add an empty text node")
 			}
 			if ctx.LeftSibling == nil || ctx.LeftSibling.Type.String() != "Text" {
-				panic("Bug: text node is not really added")
+				_bug(textStartPos, ctx.P, "text node is not really added")
 			}
 			if ctx.LeftSibling.End != ctx.P {
-				panic("Bug: text node added is not complete")
+				_bug(textStartPos, ctx.P, "text node added is not complete")
 			}
 
 			// provide correct context for parsing text node
 			endPoint := ctx.P
 			ctx.LeftSibling = ctx.LeftSibling.LeftSibling
 			ctx.P = textStartPos
+			if ctx.diag != nil {
+				ctx.diag.blockStart = textStartPos
+			}
 
 			for ctx.P != endPoint {
 				if ctx.P.Offset > endPoint.Offset {
-					panic("Bug: ctx's offset should not exceed endPoint's")
+					_bug(endPoint, ctx.P, "ctx's offset should not exceed endPoint's")
 				}
-				textnode := ctx.ParseText(s[ctx.P.Offset:endPoint.Offset], ctx)
+				textnode := ctx.ParseText(s[ctx.P.Offset-base:endPoint.Offset-base], ctx)
 				if textnode == nil {
 					// trailing '\n'
 					ctx.P = endPoint
 					break
 				}
-				ast.Root.Children = append(ast.Root.Children, textnode)
+				nodes = append(nodes, textnode)
 				ctx.LeftSibling = textnode
 				ctx.P = textnode.End
 			}
@@ -201,44 +244,71 @@ func (parser *MKParser) Parse(s string) Ast {
 			textNodeAdded = false
 		} else {
 			if textStartPos != ctx.P {
-				panic("Bug: should add a new text node")
+				_bug(textStartPos, ctx.P, "should add a new text node")
 			}
 		}
 	}
 
 	isNewLine := true
+	skipIndent := 0
 	for {
-		for _, c := range s[ctx.P.Offset:] {
+		lineStart := ctx.P.Offset - base
+		for i, c := range s[lineStart:] {
+			size := _runeSize(s, lineStart+i, c)
 			var subnode *AstNode
-			for j := len(parser.BlockParserSeq) - 1; isNewLine && (j >= 0); j-- {
-				blkParser := parser.BlockParserSeq[j]
-				if subnode = blkParser(s[ctx.P.Offset:], ctx); subnode != nil {
-					break
+			if isNewLine {
+				// indentation of nested blocks doesn't belong to any node
+				cur := ctx.P.Offset - base
+				indent, _ := _indentPrefix(s[cur:], ctx.Indent)
+				ctx.limit.check(ctx.P)
+				blkCtx := ctx
+				blkCtx.P.ConsumeStr(s[cur : cur+indent])
+				if ctx.diag != nil {
+					ctx.diag.blockStart = blkCtx.P
+				}
+				subnode = parser.parseBlock(s[cur+indent:], blkCtx)
+				if subnode == nil && indent > 0 && textNodeAdded {
+					// a new paragraph starts after blank lines
+					pending := s[textStartPos.Offset-base : cur]
+					if strings.HasSuffix(pending, "\n\n") || len(strings.Trim(pending, "\n")) == 0 {
+						fAddTextNode()
+					}
+				}
+				if !textNodeAdded {
+					skipIndent = indent
 				}
 			}
 
 			if subnode != nil {
 				fAddTextNode()
 				if subnode.End.Offset <= ctx.P.Offset {
-					panic("Bug: subnode's offset should be larger")
+					_bug(ctx.P, subnode.End, "subnode's offset should be larger")
 				}
-				ast.Root.LeftSibling = subnode
-				ast.Root.Children = append(ast.Root.Children, subnode)
+				// the block parser only sees the phony text node
+				subnode.LeftSibling = ctx.LeftSibling
+				ctx.limit.add(1, subnode.Start)
+				nodes = append(nodes, subnode)
 				ctx.LeftSibling = subnode
 				ctx.P = subnode.End
 				textStartPos = ctx.P
 				break
+			} else if skipIndent > 0 {
+				// indentation is ascii
+				ctx.P.Consume(c)
+				textStartPos = ctx.P
+				skipIndent -= 1
+				isNewLine = false
 			} else {
 				if textNodeAdded {
-					ctx.P.Consume(c)
+					ctx.P.consume(c, size)
 					if ctx.LeftSibling.Type.String() != "Text" {
-						panic("Bug: should add a 'Text' node")
+						_bug(textStartPos, ctx.P, "should add a 'Text' node")
 					}
 					ctx.LeftSibling.End = ctx.P
 				} else {
 					// create phony text node to "simulate" the context
 					textStartPos = ctx.P
-					ctx.P.Consume(c)
+					ctx.P.consume(c, size)
 					ctx.LeftSibling = &AstNode{
 						Type:        &Text{},
 						Start:       textStartPos,
@@ -252,49 +322,134 @@ func (parser *MKParser) Parse(s string) Ast {
 			}
 		}
 		fAddTextNode()
-		if ctx.P.Offset >= len(s) {
+		if ctx.P.Offset-base >= len(s) {
 			break
 		}
 		if ctx.P.Col != 0 {
-			panic("Bug: should parse to a new line here")
+			_bug(ctx.P, ctx.P, "should parse to a new line here")
 		}
 		isNewLine = true
 	}
 
-	if ctx.P.Offset < len(s) {
-		panic("Bug: parser should read all characters")
+	if ctx.P.Offset-base < len(s) {
+		_bug(ctx.P, ctx.P, "parser should read all characters")
+	}
+	return nodes
+}
+
+// Parse parses s into an ast. A UTF-8 BOM is skipped and "\r\n" and "\r" line endings
+// are read as "\n", positions still point into s (see LineIndex).
+func (parser *MKParser) Parse(s string) Ast {
+	return parser.parse(s, nil, nil, false)
+}
+
+// parse the normalized s (see sourceMap), positions are mapped back to s afterwards.
+// lazy leaves the inline children of text nodes to AstNode.Inlines.
+func (parser *MKParser) parse(s string, sink *diagnosticSink, limit *limitState, lazy bool) Ast {
+	m := newSourceMap(s, true)
+	if m.changed() {
+		diagCnt := 0
+		if sink != nil {
+			diagCnt = len(sink.diags)
+		}
+		fRemapDiags := func() {
+			if sink == nil {
+				return
+			}
+			for i := diagCnt; i < len(sink.diags); i++ {
+				sink.diags[i].Start = m.pos(sink.diags[i].Start, 0)
+				sink.diags[i].End = m.pos(sink.diags[i].End, 0)
+			}
+			diagCnt = len(sink.diags)
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				fRemapDiags()
+				if sink != nil {
+					sink.blockStart = m.pos(sink.blockStart, 0)
+				}
+				m.remapPanic(r, 0)
+				panic(r)
+			}
+			fRemapDiags()
+		}()
+	}
+
+	ast := Ast{
+		Root: AstNode{
+			Type:  &Document{},
+			Start: Pos{Line: 0, Col: 0, Offset: 0},
+		},
+	}
+	ctx := ParseContext{
+		P:           Pos{Line: 0, Col: 0, Offset: 0},
+		Parent:      &ast.Root,
+		LeftSibling: nil,
+		ParseText:   parser.parseText,
+		ParseBlocks: parser.parseBlocks,
+		diag:        sink,
+		limit:       limit,
+		trace:       parser.newTrace(m, 0),
+	}
+	var textParser *lazyTextParser
+	if lazy {
+		textParser = &lazyTextParser{parser: parser}
+		ctx.ParseText = textParser.parseText
+	}
+	ast.Root.Children = parser.parseBlocks(m.norm, ctx)
+	ast.src = m.norm
+	ast.parser = parser
+	ast.Root.End = ast.Root.Start
+	ast.Root.End.ConsumeStr(m.norm)
+
+	for _, postParser := range parser.PostParserSeq {
+		postParser(&ast, m.norm)
 	}
-	ast.Root.End = ctx.P
 
+	m.remap(&ast.Root, 0)
+	if textParser != nil && m.changed() {
+		textParser.m = m
+	}
+	ast.Root.Start = Pos{}
+	ast.src = s
+	if !m.changed() {
+		ast.chunks = _chunkBoundaries(s)
+	}
+	ast.setSource()
 	return ast
 }
 
 func (parser *MKParser) addDefaultInlineParser(name string) {
 	switch name {
 	case "Emphasis":
-		parser.InlineParserSeq[rune('*')] = append(parser.InlineParserSeq[rune('*')], parseEmphasis)
-		parser.InlineParserSeq[rune('_')] = append(parser.InlineParserSeq[rune('_')], parseEmphasis)
+		parser.appendInlineParser(name, '*', parseEmphasis)
+		parser.appendInlineParser(name, '_', parseEmphasis)
 	case "Italic":
-		parser.InlineParserSeq[rune('*')] = append(parser.InlineParserSeq[rune('*')], parseItalic)
-		parser.InlineParserSeq[rune('_')] = append(parser.InlineParserSeq[rune('_')], parseItalic)
+		parser.appendInlineParser(name, '*', parseItalic)
+		parser.appendInlineParser(name, '_', parseItalic)
 	case "StrikeThrough":
-		parser.InlineParserSeq[rune('~')] = append(parser.InlineParserSeq[rune('~')], parseStrikeThrough)
+		parser.appendInlineParser(name, '~', parseStrikeThrough)
 	case "Code":
-		parser.InlineParserSeq[rune('`')] = append(parser.InlineParserSeq[rune('`')], parseCode)
+		parser.appendInlineParser(name, '`', parseCode)
 	case "Math":
-		parser.InlineParserSeq[rune('$')] = append(parser.InlineParserSeq[rune('$')], parseMath)
+		parser.appendInlineParser(name, '$', parseMath)
 	case "Link":
-		parser.InlineParserSeq[rune('[')] = append(parser.InlineParserSeq[rune('[')], parseLink)
+		parser.appendInlineParser(name, '[', parseLink)
 	case "SimpleLink":
-		parser.InlineParserSeq[rune('<')] = append(parser.InlineParserSeq[rune('<')], parseSimpleLink)
+		parser.appendInlineParser(name, '<', parseSimpleLink)
 	case "Image":
-		parser.InlineParserSeq[rune('!')] = append(parser.InlineParserSeq[rune('!')], parseImage)
+		parser.appendInlineParser(name, '!', parseImage)
 	case "Html":
-		parser.InlineParserSeq[rune('<')] = append(parser.InlineParserSeq[rune('<')], parseHtml)
+		parser.appendInlineParser(name, '<', parseHtml)
 	case "ReferenceLink":
-		parser.InlineParserSeq[rune('[')] = append(parser.InlineParserSeq[rune('[')], parseReferenceLink)
+		parser.appendInlineParser(name, '[', parseReferenceLink)
 	case "FootNote":
-		parser.InlineParserSeq[rune('[')] = append(parser.InlineParserSeq[rune('[')], parseFootNote)
+		parser.appendInlineParser(name, '[', parseFootNote)
+	case "Citation":
+		parser.appendInlineParser(name, '[', parseCitation)
+		parser.appendInlineParser(name, '@', parseInTextCitation)
+	case "InlineFootNote":
+		parser.appendInlineParser(name, '^', parseInlineFootNote)
 	default:
 		log.Panicf("%s is not supported", name)
 	}
@@ -302,9 +457,9 @@ func (parser *MKParser) addDefaultInlineParser(name string) {
 
 func _addAllDefaultInlineParsers(parser *MKParser) {
 	// Emphasis before Italic
-	// FootNote before ReferenceLink
+	// Link before Citation before FootNote before ReferenceLink
 	parser.AddDefaultInlineParsers([]string{
-		"Emphasis", "Italic", "StrikeThrough", "Code", "Math", "Link", "SimpleLink", "Image", "Html", "FootNote", "ReferenceLink",
+		"Emphasis", "Italic", "StrikeThrough", "Code", "Math", "Link", "SimpleLink", "Image", "Html", "Citation", "FootNote", "ReferenceLink", "InlineFootNote",
 	})
 }
 
@@ -316,29 +471,32 @@ func (parser *MKParser) AddDefaultInlineParsers(names []string) {
 }
 
 func (parser *MKParser) AddExtensionInlineParser(lookAhead rune, method InlineParser) {
-	parser.InlineParserSeq[lookAhead] = append(parser.InlineParserSeq[lookAhead], method)
+	parser.appendInlineParser("", lookAhead, method)
 }
 
 func (parser *MKParser) addDefaultBlockParser(name string) {
 	switch name {
 	case "Header":
-		parser.BlockParserSeq = append(parser.BlockParserSeq, parseHeader)
+		parser.appendBlockParser(name, parseHeader)
 	case "QuoteBlock":
-		parser.BlockParserSeq = append(parser.BlockParserSeq, parseQuoteBlock)
+		parser.appendBlockParser(name, parseQuoteBlock)
 	case "CodeBlock":
-		parser.BlockParserSeq = append(parser.BlockParserSeq, parseCodeBlock)
+		parser.appendBlockParser(name, parseCodeBlock)
 	case "MathBlock":
-		parser.BlockParserSeq = append(parser.BlockParserSeq, parseMathBlock)
+		parser.appendBlockParser(name, parseMathBlock)
 	case "Table":
-		parser.BlockParserSeq = append(parser.BlockParserSeq, parseTable)
+		parser.appendBlockParser(name, parseTable)
 	case "HorizontalRule":
-		parser.BlockParserSeq = append(parser.BlockParserSeq, parseHorizontalRule)
+		parser.appendBlockParser(name, parseHorizontalRule)
 	case "List":
-		parser.BlockParserSeq = append(parser.BlockParserSeq, parseList)
+		parser.appendBlockParser(name, parseList)
 	case "ReferenceLinkIndex":
-		parser.BlockParserSeq = append(parser.BlockParserSeq, parseReferenceLinkIndex)
+		parser.appendBlockParser(name, parseReferenceLinkIndex)
 	case "FootNoteIndex":
-		parser.BlockParserSeq = append(parser.BlockParserSeq, parseFootNoteIndex)
+		parser.appendBlockParser(name, parseFootNoteIndex)
+	case "AbbreviationIndex":
+		parser.appendBlockParser(name, parseAbbreviationIndex)
+		parser.appendPostParser(name, applyAbbreviations)
 	default:
 		log.Panicf("%s is not supported", name)
 	}
@@ -346,7 +504,7 @@ func (parser *MKParser) addDefaultBlockParser(name string) {
 
 func _addAllDefaultBlockParsers(parser *MKParser) {
 	parser.AddDefaultBlockParsers([]string{
-		"HorizontalRule", "Header", "QuoteBlock", "CodeBlock", "MathBlock", "Table", "List", "FootNoteIndex", "ReferenceLinkIndex",
+		"HorizontalRule", "Header", "QuoteBlock", "CodeBlock", "MathBlock", "Table", "List", "FootNoteIndex", "ReferenceLinkIndex", "AbbreviationIndex",
 	})
 }
 
@@ -357,12 +515,18 @@ func (parser *MKParser) AddDefaultBlockParsers(names []string) {
 }
 
 func (parser *MKParser) AddExtensionBlockParser(method BlockParser) {
-	parser.BlockParserSeq = append(parser.BlockParserSeq, method)
+	parser.appendBlockParser("", method)
+}
+
+// post parsers run in the order they are added
+func (parser *MKParser) AddExtensionPostParser(method PostParser) {
+	parser.appendPostParser("", method)
 }
 
 func GetBaseParser() MKParser {
 	parser := MKParser{}
 	parser.InlineParserSeq = make(map[rune][]InlineParser)
+	parser.inlineParserNames = make(map[rune][]string)
 	return parser
 }
 
@@ -384,3 +548,75 @@ func GetFullMKParser() MKParser {
 	_addAllDefaultInlineParsers(&parser)
 	return parser
 }
+
+// Profiles other than ProfileClassic parse the constructs of a dialect, the xxmk parsers are
+// replaced by those of dialect.go where the dialect spells a construct differently.
+// They are checked against the examples of the specs in tests/spec, the examples they
+// still fail are listed there.
+const (
+	// every parser of xxmk, same as GetFullMKParser
+	ProfileClassic uint32 = iota
+	// CommonMark: setext and at most 6 levels of atx headers, indented code, emphasis by the
+	// flanking rules, link destinations and titles of CommonMark and collapsed and shortcut
+	// references, no tables, strikethrough, math, footnotes, abbreviations, citations or task lists
+	ProfileCommonMark
+	// GitHub Flavored Markdown: ProfileCommonMark with tables without captions, strikethrough,
+	// task lists, footnotes and autolinks of urls, www. links and emails in text
+	ProfileGFM
+)
+
+// add the default block parsers of names, a parser of overrides is added in place of the default one of its name
+func (parser *MKParser) addProfileBlockParsers(names []string, overrides map[string]BlockParser) {
+	for i := len(names) - 1; i >= 0; i-- {
+		if method, ok := overrides[names[i]]; ok {
+			parser.appendBlockParser(names[i], method)
+		} else {
+			parser.addDefaultBlockParser(names[i])
+		}
+	}
+}
+
+// GetProfileParser returns a parser of the dialect of the profile
+func GetProfileParser(profile uint32) MKParser {
+	parser := GetBaseParser()
+	commonMarkBlocks := map[string]BlockParser{
+		"Header":       parseStrictHeader,
+		"SetextHeader": parseSetextHeader,
+		"IndentedCode": parseIndentedCode,
+		"List":         parsePlainList,
+	}
+	commonMarkInlines := map[string]InlineParser{
+		"Emphasis":      parseFlankingEmphasis,
+		"Link":          parseStrictLink,
+		"Image":         parseStrictImage,
+		"ReferenceLink": parseStrictReferenceLink,
+	}
+	switch profile {
+	case ProfileClassic:
+		_addAllDefaultBlockParsers(&parser)
+		_addAllDefaultInlineParsers(&parser)
+	case ProfileCommonMark:
+		parser.addProfileBlockParsers([]string{
+			"HorizontalRule", "Header", "SetextHeader", "QuoteBlock", "CodeBlock", "IndentedCode", "List", "ReferenceLinkIndex",
+		}, commonMarkBlocks)
+		parser.appendPostParser("ReferenceLinkIndex", applyShortcutReferences)
+		// parseFlankingEmphasis parses italics too
+		parser.addProfileInlineParsers([]string{
+			"Emphasis", "Code", "Link", "SimpleLink", "Image", "Html", "ReferenceLink",
+		}, commonMarkInlines)
+	case ProfileGFM:
+		commonMarkBlocks["List"] = parseList
+		commonMarkBlocks["Table"] = parseStrictTable
+		parser.addProfileBlockParsers([]string{
+			"HorizontalRule", "Header", "SetextHeader", "QuoteBlock", "CodeBlock", "IndentedCode", "Table", "List", "FootNoteIndex", "ReferenceLinkIndex",
+		}, commonMarkBlocks)
+		parser.appendPostParser("ReferenceLinkIndex", applyShortcutReferences)
+		parser.appendPostParser("Autolink", applyAutolinks)
+		parser.addProfileInlineParsers([]string{
+			"Emphasis", "StrikeThrough", "Code", "Link", "SimpleLink", "Image", "Html", "FootNote", "ReferenceLink",
+		}, commonMarkInlines)
+	default:
+		log.Panicf("profile %d is not supported", profile)
+	}
+	return parser
+}
@@ -1,25 +1,12 @@
 package parserlib
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func _astCheck(node *AstNode) bool {
-	var lastCh *AstNode
-	for _, ch := range node.Children {
-		if ch.LeftSibling != lastCh {
-			return false
-		}
-		lastCh = ch
-		if !_astCheck(ch) {
-			return false
-		}
-	}
-	return true
-}
-
 func TestSucc(t *testing.T) {
 	parser := GetFullMKParser()
 	mks := []string{
@@ -55,7 +42,7 @@ sometexts...`
 	textCount := 0
 	textTotalLen := 0
 	t.Logf(ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	ast.Root.PreVisit(func(node *AstNode) {
 		switch tp := node.Type.(type) {
 		case *Text:
@@ -109,7 +96,7 @@ __nice to meet you!__`
 	codeStrs := map[string]int{}
 	mathStrs := map[string]int{}
 	t.Logf("%s", ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	ast.Root.PreVisit(func(node *AstNode) {
 		switch node.Type.(type) {
 		case *Emphasis:
@@ -170,7 +157,7 @@ int main() {
 	var codeSuffix []string
 
 	t.Logf("%s\n%s", simplemk, ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	ast.Root.PreVisit(func(node *AstNode) {
 		switch tp := node.Type.(type) {
 		case *MathBlock:
@@ -205,7 +192,7 @@ func TestLink(t *testing.T) {
 	var simpleLinkNode []*AstNode
 	var htmlStartType []HtmlStartTag
 	t.Logf("%s\n%s", mk, ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	ast.Root.PreVisit(func(node *AstNode) {
 		switch tp := node.Type.(type) {
 		case *Link:
@@ -226,25 +213,66 @@ func TestLink(t *testing.T) {
 	assert.Equal(t, 2, len(imageType))
 	assert.Equal(t, 2, len(simpleLinkType))
 	assert.Equal(t, 2, len(htmlStartType))
-	assert.Equal(t, "hello", linkNode[0].Children[0].Text(mk))
+	assert.Equal(t, "hello", linkNode[0].Children[0].Text())
 	assert.Equal(t, "hello.com", linkType[0].Link)
 	assert.Equal(t, "hello link  ", linkType[0].Title)
-	assert.Equal(t, "image", imageNode[0].Children[0].Text(mk))
+	assert.Equal(t, "image", imageNode[0].Children[0].Text())
 	assert.Equal(t, "image.com", imageType[0].Link)
-	assert.Equal(t, "![image](imagePath)", linkNode[1].Children[0].Text(mk))
+	assert.Equal(t, "![image](imagePath)", linkNode[1].Children[0].Text())
 	assert.Equal(t, "imageLink", linkType[1].Link)
-	assert.Equal(t, "image", imageNode[1].Children[0].Text(mk))
+	assert.Equal(t, "image", imageNode[1].Children[0].Text())
 	assert.Equal(t, "imagePath", imageType[1].Link)
-	assert.Equal(t, `images\[good`, linkNode[2].Children[0].Text(mk))
+	assert.Equal(t, `images\[good`, linkNode[2].Children[0].Text())
 	assert.Equal(t, "https://www.baidu.com", simpleLinkType[0].Link)
-	assert.Equal(t, "<https://www.baidu.com>", simpleLinkNode[0].Text(mk))
+	assert.Equal(t, "<https://www.baidu.com>", simpleLinkNode[0].Text())
 	assert.Equal(t, "09@gmail.com", simpleLinkType[1].Link)
-	assert.Equal(t, "<09@gmail.com>", simpleLinkNode[1].Text(mk))
+	assert.Equal(t, "<09@gmail.com>", simpleLinkNode[1].Text())
 	assert.Equal(t, "link", htmlStartType[0].Tag)
 	assert.Equal(t, "a", htmlStartType[1].Tag)
 	assert.Equal(t, `class="hello"`, htmlStartType[0].Content)
 }
 
+func TestContentRanges(t *testing.T) {
+	mk := "## Title ##\n\n```go run\nx := 1\n```\n\n$$\ny\n$$ {#eq:a}\n\n" +
+		"[text](url.com \"title\") ![alt](a.png) `` a`b `` $m$\n"
+	parser := GetFullMKParser()
+	want := []string{"Title", "go run", "x := 1\n", "y\n", "text", "url.com", "title", "alt", "a.png", "", " a`b ", "m"}
+	fCheck := func(ast *Ast, src string, want []string) {
+		t.Logf(ast.String())
+		assert.Nil(t, ast.Validate())
+		var ranges []string
+		ast.Root.preVisitInlines(func(node *AstNode) {
+			if ranged, ok := node.Type.(rangedType); ok {
+				for _, r := range ranged.ranges() {
+					ranges = append(ranges, r.Text(src))
+				}
+			}
+		})
+		assert.Equal(t, want, ranges)
+	}
+	ast := parser.Parse(mk)
+	fCheck(&ast, mk, want)
+	link := ast.Root.Children[3].Children[0].Type.(*Link)
+	assert.Equal(t, Pos{Line: 10, Col: 7, Offset: 59}, link.LinkRange.Start)
+	lazy := parser.ParseLazy(mk)
+	fCheck(&lazy, mk, want)
+
+	crlf := strings.ReplaceAll(mk, "\n", "\r\n")
+	ast = parser.Parse(crlf)
+	crlfWant := make([]string, len(want))
+	for i := range want {
+		crlfWant[i] = strings.ReplaceAll(want[i], "\n", "\r\n")
+	}
+	fCheck(&ast, crlf, crlfWant)
+
+	// the ranges of the blocks after an edit are shifted
+	ast = parser.Parse(mk)
+	_, err := ast.Update(TextEdit{Start: 0, End: 0, Text: "# More\n\n"})
+	assert.Nil(t, err)
+	fCheck(&ast, ast.Source(), append([]string{"More"}, want...))
+	assert.Equal(t, Pos{Line: 12, Col: 7, Offset: 67}, ast.Root.Children[4].Children[0].Type.(*Link).LinkRange.Start)
+}
+
 func TestTable(t *testing.T) {
 	mk := `|  hello |  world| |
 |:--: | --: | -- |
@@ -254,7 +282,7 @@ abc
 	parser := GetFullMKParser()
 	ast := parser.Parse(mk)
 	t.Logf("%s", ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	assert.Equal(t, 1, len(ast.Root.Children))
 	assert.Equal(t, "Table", ast.Root.Children[0].Type.String())
 	assert.Equal(t, 5, len(ast.Root.Children[0].Children))
@@ -263,9 +291,9 @@ abc
 	lineNodes := ast.Root.Children[0].Children[2:]
 	assert.Equal(t, "TableHead", headerNode.Type.String())
 	assert.Equal(t, 3, len(headerNode.Children))
-	assert.Equal(t, "hello", headerNode.Children[0].Text(mk))
-	assert.Equal(t, "world", headerNode.Children[1].Text(mk))
-	assert.Equal(t, "", headerNode.Children[2].Text(mk))
+	assert.Equal(t, "hello", headerNode.Children[0].Text())
+	assert.Equal(t, "world", headerNode.Children[1].Text())
+	assert.Equal(t, "", headerNode.Children[2].Text())
 
 	assert.Equal(t, "TableAlign", alignNode.Type.String())
 	tbAlign := alignNode.Type.(*TableAlign)
@@ -275,18 +303,21 @@ abc
 	assert.Equal(t, AlignLeft, tbAlign.Aligns[2])
 
 	line0 := lineNodes[0]
-	assert.Equal(t, 1, len(line0.Children))
-	assert.Equal(t, "abc", line0.Children[0].Text(mk))
+	// missing cells are padded with empty texts
+	assert.Equal(t, 3, len(line0.Children))
+	assert.Equal(t, "abc", line0.Children[0].Text())
+	assert.Equal(t, "", line0.Children[1].Text())
+	assert.Equal(t, "", line0.Children[2].Text())
 	line1 := lineNodes[1]
 	assert.Equal(t, 3, len(line1.Children))
-	assert.Equal(t, "d", line1.Children[0].Text(mk))
-	assert.Equal(t, "e", line1.Children[1].Text(mk))
-	assert.Equal(t, "f", line1.Children[2].Text(mk))
+	assert.Equal(t, "d", line1.Children[0].Text())
+	assert.Equal(t, "e", line1.Children[1].Text())
+	assert.Equal(t, "f", line1.Children[2].Text())
 	line2 := lineNodes[2]
 	assert.Equal(t, 3, len(line2.Children))
-	assert.Equal(t, "g", line2.Children[0].Text(mk))
-	assert.Equal(t, "", line2.Children[1].Text(mk))
-	assert.Equal(t, "i", line2.Children[2].Text(mk))
+	assert.Equal(t, "g", line2.Children[0].Text())
+	assert.Equal(t, "", line2.Children[1].Text())
+	assert.Equal(t, "i", line2.Children[2].Text())
 }
 
 func TestQuoteBlock(t *testing.T) {
@@ -298,7 +329,7 @@ func TestQuoteBlock(t *testing.T) {
 	parser := GetFullMKParser()
 	ast := parser.Parse(mk)
 	t.Logf(ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	assert.Equal(t, 4, len(ast.Root.Children))
 
 	texts := []string{
@@ -310,7 +341,7 @@ func TestQuoteBlock(t *testing.T) {
 		assert.Equal(t, levels[i], ast.Root.Children[i].Type.(*QuoteBlock).Level)
 		assert.Equal(t, 1, len(ast.Root.Children[i].Children))
 		assert.Equal(t, "Text", ast.Root.Children[i].Children[0].Type.String())
-		assert.Equal(t, texts[i], ast.Root.Children[i].Children[0].Text(mk))
+		assert.Equal(t, texts[i], ast.Root.Children[i].Children[0].Text())
 	}
 }
 
@@ -323,7 +354,7 @@ func TestHorizontalRule(t *testing.T) {
 	parser := GetFullMKParser()
 	ast := parser.Parse(mk)
 	t.Logf(ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 
 	hcnt := 0
 	hLines := []int{}
@@ -346,12 +377,12 @@ func TestStrikeThrough(t *testing.T) {
 	parser := GetFullMKParser()
 	ast := parser.Parse(mk)
 	t.Logf(ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	collects := []string{}
 	ast.Root.PreVisit(func(node *AstNode) {
 		switch node.Type.(type) {
 		case *StrikeThrough:
-			collects = append(collects, node.Children[0].Text(mk))
+			collects = append(collects, node.Children[0].Text())
 		}
 	})
 	assert.Equal(t, 1, len(collects))
@@ -369,7 +400,7 @@ func TestList(t *testing.T) {
 	parser := GetFullMKParser()
 	ast := parser.Parse(mk)
 	t.Logf(ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	assert.Equal(t, 4, len(ast.Root.Children))
 	lst1 := ast.Root.Children[0]
 	lst2 := ast.Root.Children[1]
@@ -381,7 +412,7 @@ func TestList(t *testing.T) {
 	for i, ch := range lst1.Children {
 		assert.Equal(t, 1, len(ch.Children))
 		assert.Equal(t, "Text", ch.Children[0].Type.String())
-		assert.Equal(t, itemNames[i], ch.Children[0].Text(mk))
+		assert.Equal(t, itemNames[i], ch.Children[0].Text())
 	}
 
 	assert.Equal(t, true, lst2.Type.(*List).IsOrdered)
@@ -392,7 +423,7 @@ func TestList(t *testing.T) {
 		assert.Equal(t, orders[i], ch.Type.(*ListItem).Order)
 		assert.Equal(t, 1, len(ch.Children))
 		assert.Equal(t, "Text", ch.Children[0].Type.String())
-		assert.Equal(t, itemNames[i], ch.Children[0].Text(mk))
+		assert.Equal(t, itemNames[i], ch.Children[0].Text())
 	}
 
 	assert.Equal(t, false, lst3.Type.(*List).IsOrdered)
@@ -401,7 +432,7 @@ func TestList(t *testing.T) {
 	for i, ch := range lst3.Children {
 		assert.Equal(t, 1, len(ch.Children))
 		assert.Equal(t, "Text", ch.Children[0].Type.String())
-		assert.Equal(t, itemNames[i], ch.Children[0].Text(mk))
+		assert.Equal(t, itemNames[i], ch.Children[0].Text())
 	}
 
 	assert.Equal(t, true, lst4.Type.(*List).IsOrdered)
@@ -412,7 +443,7 @@ func TestList(t *testing.T) {
 		assert.Equal(t, orders[i], ch.Type.(*ListItem).Order)
 		assert.Equal(t, 1, len(ch.Children))
 		assert.Equal(t, "Text", ch.Children[0].Type.String())
-		assert.Equal(t, itemNames[i], ch.Children[0].Text(mk))
+		assert.Equal(t, itemNames[i], ch.Children[0].Text())
 	}
 }
 
@@ -452,7 +483,7 @@ func TestReferenceLink(t *testing.T) {
 		}
 	})
 	t.Logf(ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	assert.Equal(t, 2, len(refLink))
 	assert.Equal(t, 2, len(refLinkIndex))
 	trueRefMap := map[int][]string{
@@ -465,7 +496,7 @@ func TestReferenceLink(t *testing.T) {
 	}
 	for i := 0; i < 2; i++ {
 		assert.Equal(t, trueRefMap[i][0], refLink[i].Index)
-		assert.Equal(t, trueRefMap[i][1], refNode[i].Children[0].Text(mk))
+		assert.Equal(t, trueRefMap[i][1], refNode[i].Children[0].Text())
 		assert.Equal(t, trueIndexMap[i][0], refLinkIndex[i].Index)
 		assert.Equal(t, trueIndexMap[i][1], refLinkIndex[i].Link)
 		assert.Equal(t, trueIndexMap[i][2], refLinkIndex[i].Title)
@@ -485,7 +516,7 @@ func TestFootNote(t *testing.T) {
 	var footIndexNode []*AstNode
 
 	t.Logf(ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	ast.Root.PreVisit(func(node *AstNode) {
 		switch tp := node.Type.(type) {
 		case *FootNote:
@@ -504,7 +535,7 @@ func TestFootNote(t *testing.T) {
 	for i := 0; i < 2; i++ {
 		assert.Equal(t, trueMap[i][0], footType[i].Index)
 		assert.Equal(t, trueMap[i][0], footIndexType[i].Index)
-		assert.Equal(t, trueMap[i][1], footIndexNode[i].Children[0].Text(mk))
+		assert.Equal(t, trueMap[i][1], footIndexNode[i].Children[0].Text())
 	}
 }
 
@@ -519,7 +550,7 @@ func TestTaskList(t *testing.T) {
 	var listItemNode []*AstNode
 	ast := parser.Parse(mk)
 	t.Logf(ast.String())
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	ast.Root.PreVisit(func(node *AstNode) {
 		switch tp := node.Type.(type) {
 		case *ListItem:
@@ -542,7 +573,7 @@ func TestTaskList(t *testing.T) {
 		3: {true, false},
 	}
 	for i := 1; i < 4; i++ {
-		assert.Equal(t, trueMap[i], listItemNode[i].Children[0].Text(mk))
+		assert.Equal(t, trueMap[i], listItemNode[i].Children[0].Text())
 		assert.Equal(t, finishMap[i][0], listItemType[i].IsTask)
 		assert.Equal(t, finishMap[i][1], listItemType[i].IsFinished)
 	}
@@ -556,7 +587,7 @@ Part2`
 	ast := parser.Parse(mk)
 	s := ast.String()
 	t.Logf("%s", s)
-	_astCheck(&ast.Root)
+	assert.Nil(t, ast.Validate())
 	textCnt := 0
 	ast.Root.PreVisit(func(node *AstNode) {
 		switch node.Type.(type) {
@@ -566,3 +597,268 @@ Part2`
 	})
 	assert.Equal(t, 2, textCnt)
 }
+
+func TestFootNoteMultiParagraph(t *testing.T) {
+	mk := `text[^long]
+
+[^long]: first paragraph
+    still the first paragraph
+
+    second paragraph
+    - item1
+    - item2
+
+    ` + "```go\n    fmt.Println()\n    ```" + `
+after footnote`
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+	t.Logf(ast.String())
+	assert.Nil(t, ast.Validate())
+
+	var indexNode *AstNode
+	ast.Root.PreVisit(func(node *AstNode) {
+		switch node.Type.(type) {
+		case *FootNoteIndex:
+			indexNode = node
+		}
+	})
+	assert.NotNil(t, indexNode)
+	assert.Equal(t, 4, len(indexNode.Children))
+	assert.Equal(t, " first paragraph\n    still the first paragraph", indexNode.Children[0].Text())
+	assert.Equal(t, "Text", indexNode.Children[1].Type.String())
+	assert.Equal(t, "second paragraph\n", indexNode.Children[1].Text())
+	assert.Equal(t, "List", indexNode.Children[2].Type.String())
+	assert.Equal(t, 2, len(indexNode.Children[2].Children))
+	assert.Equal(t, "CodeBlock(go)", indexNode.Children[3].Type.String())
+	assert.Equal(t, "```go\n    fmt.Println()\n    ```\n", indexNode.Children[3].Text())
+	for _, ch := range indexNode.Children {
+		assert.Equal(t, indexNode, ch.Parent)
+	}
+
+	lastNode := ast.Root.Children[len(ast.Root.Children)-1]
+	assert.Equal(t, "Text", lastNode.Type.String())
+	assert.Equal(t, "after footnote", lastNode.Text())
+}
+
+func TestInlineFootNote(t *testing.T) {
+	mk := `inline^[a *short* note] and ^[] and ^[unclosed`
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+	t.Logf(ast.String())
+	assert.Nil(t, ast.Validate())
+
+	var notes []*AstNode
+	ast.Root.PreVisit(func(node *AstNode) {
+		switch node.Type.(type) {
+		case *InlineFootNote:
+			notes = append(notes, node)
+		}
+	})
+	assert.Equal(t, 1, len(notes))
+	assert.Equal(t, "^[a *short* note]", notes[0].Text())
+	assert.Equal(t, 3, len(notes[0].Children[0].Children))
+	assert.Equal(t, "Italic", notes[0].Children[0].Children[1].Type.String())
+}
+
+func TestAbbreviation(t *testing.T) {
+	mk := `# HTML and CSS
+The HTML spec, not HTMLX, mentions ` + "`HTML`" + ` and [HTML](html.com).
+- W3C maintains HTML
+
+*[HTML]: Hyper Text Markup Language
+*[W3C]:  World Wide Web Consortium `
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+	t.Logf(ast.String())
+	assert.Nil(t, ast.Validate())
+
+	var indexType []AbbreviationIndex
+	var abbrType []Abbreviation
+	var abbrNode []*AstNode
+	ast.Root.PreVisit(func(node *AstNode) {
+		switch tp := node.Type.(type) {
+		case *AbbreviationIndex:
+			indexType = append(indexType, *tp)
+		case *Abbreviation:
+			abbrType = append(abbrType, *tp)
+			abbrNode = append(abbrNode, node)
+		}
+	})
+	assert.Equal(t, 2, len(indexType))
+	assert.Equal(t, "HTML", indexType[0].Abbr)
+	assert.Equal(t, "Hyper Text Markup Language", indexType[0].Definition)
+	assert.Equal(t, "W3C", indexType[1].Abbr)
+	assert.Equal(t, "World Wide Web Consortium", indexType[1].Definition)
+
+	assert.Equal(t, 4, len(abbrType))
+	abbrLines := []int{0, 1, 2, 2}
+	for i, node := range abbrNode {
+		assert.Equal(t, abbrType[i].Abbr, node.Text())
+		assert.Equal(t, abbrLines[i], node.Start.Line)
+	}
+	assert.Equal(t, "W3C", abbrType[2].Abbr)
+	assert.Equal(t, "World Wide Web Consortium", abbrType[2].Definition)
+}
+
+func TestCrossRef(t *testing.T) {
+	mk := `See @fig:arch, @tbl:api and @eq:energy, but not @fig:missing.
+
+![Architecture](arch.png){#fig:arch} ![Other](other.png){#fig:other}
+
+| name | value |
+| -- | -- |
+| a | 1 |
+: API table {#tbl:api}
+
+$$
+E = mc^2
+$$ {#eq:energy}
+`
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+	t.Logf(ast.String())
+	assert.Nil(t, ast.Validate())
+
+	refs := ResolveCrossRefs(&ast)
+	assert.Equal(t, 4, len(refs.Targets))
+	assert.Equal(t, "Image", refs.Targets["fig:arch"].Type.String())
+	assert.Equal(t, "![Architecture](arch.png){#fig:arch}", refs.Targets["fig:arch"].Text())
+	assert.Equal(t, "Table", refs.Targets["tbl:api"].Type.String())
+	assert.Equal(t, "MathBlock", refs.Targets["eq:energy"].Type.String())
+
+	assert.Equal(t, 3, len(refs.Refs))
+	for _, ref := range refs.Refs {
+		assert.Equal(t, uint32(1), ref.Number)
+		assert.Equal(t, uint32(1), ref.Ref.Type.(*CitationItem).Number)
+	}
+	assert.Equal(t, refs.Targets["tbl:api"], refs.Refs[1].Target)
+	assert.Equal(t, 1, len(refs.Dangling))
+	assert.Equal(t, "fig:missing", refs.Dangling[0].Ref.Type.(*CitationItem).Key)
+
+	var labels []*AstNode
+	var caption *AstNode
+	ast.Root.PreVisit(func(node *AstNode) {
+		switch node.Type.(type) {
+		case *Label:
+			labels = append(labels, node)
+		case *TableCaption:
+			caption = node
+		}
+	})
+	assert.Equal(t, 4, len(labels))
+	numbers := []uint32{1, 2, 1, 1}
+	names := []string{"{#fig:arch}", "{#fig:other}", "{#tbl:api}", "{#eq:energy}"}
+	for i, label := range labels {
+		assert.Equal(t, numbers[i], label.Type.(*Label).Number)
+		assert.Equal(t, names[i], label.Text())
+	}
+	assert.Equal(t, "API table", caption.Children[0].Text())
+	assert.Equal(t, 3, len(refs.Targets["tbl:api"].Children)-1)
+}
+
+func TestTableCells(t *testing.T) {
+	mk := "Table: Shell pipelines {#tbl:shell}\n\n" +
+		"| command | note |\n" +
+		"| -- | -- |\n" +
+		"| `ls | wc -l` | count \\| files |\n" +
+		"| ``a`|`b`` | x | extra |\n" +
+		"| only |\n" +
+		"\n" +
+		"| a | b |\n" +
+		"| -- | -- |\n" +
+		"| 1 | 2 |\n" +
+		"Table: After"
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+	t.Logf(ast.String())
+	assert.Nil(t, ast.Validate())
+	assert.Equal(t, 2, len(ast.Root.Children))
+
+	table := ast.Root.Children[0]
+	assert.Equal(t, "Table", table.Type.String())
+	assert.Equal(t, 6, len(table.Children))
+	caption := table.Children[0]
+	assert.Equal(t, "TableCaption", caption.Type.String())
+	assert.Equal(t, "Shell pipelines", caption.Children[0].Text())
+	assert.Equal(t, "tbl:shell", caption.Children[1].Type.(*Label).Name)
+
+	rows := table.Children[3:]
+	cells := [][]string{
+		{"`ls | wc -l`", `count \| files`},
+		{"``a`|`b``", "x"},
+		{"only", ""},
+	}
+	for i, row := range rows {
+		assert.Equal(t, len(cells[i]), len(row.Children))
+		for j, cell := range row.Children {
+			assert.Equal(t, cells[i][j], cell.Text())
+		}
+	}
+	assert.Equal(t, "Code", rows[0].Children[0].Children[0].Type.String())
+	// extra cells are dropped from the ast, their source is left to the row
+	_, diags, _ := parser.ParseWithDiagnostics(mk)
+	assert.Equal(t, 1, len(diags))
+	assert.Equal(t, "extra", mk[diags[0].Start.Offset:diags[0].End.Offset])
+	assert.Equal(t, "| ``a`|`b`` | x | extra |\n", rows[1].Text())
+	assert.NotContains(t, RenderHTML(&ast), "extra")
+	assert.Equal(t, mk, ast.CST().Source())
+
+	table = ast.Root.Children[1]
+	assert.Equal(t, 4, len(table.Children))
+	caption = table.Children[3]
+	assert.Equal(t, "TableCaption", caption.Type.String())
+	assert.Equal(t, "After", caption.Children[0].Text())
+	assert.Equal(t, len(mk), table.End.Offset)
+}
+
+func TestProfile(t *testing.T) {
+	mk := "####### seven\n" +
+		"- [ ] task\n" +
+		"\n" +
+		"| a | b |\n" +
+		"| - | - |\n" +
+		"\n" +
+		"~~strike~~ $math$ note[^1]\n" +
+		"\n" +
+		"[^1]: footnote\n"
+	fTypes := func(ast *Ast) map[string]int {
+		types := map[string]int{}
+		ast.Root.PreVisit(func(node *AstNode) {
+			types[GetNodeTypeName(node.Type)] += 1
+		})
+		return types
+	}
+
+	classic := GetProfileParser(ProfileClassic)
+	full := GetFullMKParser()
+	assert.Equal(t, full.BlockParserNames(), classic.BlockParserNames())
+	ast := classic.Parse(mk)
+	types := fTypes(&ast)
+	assert.Equal(t, 1, types["Header"])
+	assert.Equal(t, 1, types["Math"])
+	assert.Equal(t, true, ast.Root.Children[1].Children[0].Type.(*ListItem).IsTask)
+
+	commonMark := GetProfileParser(ProfileCommonMark)
+	assert.Equal(t, []string{"HorizontalRule", "Header", "SetextHeader", "QuoteBlock", "CodeBlock", "IndentedCode", "List", "ReferenceLinkIndex"},
+		commonMark.BlockParserNames())
+	ast = commonMark.Parse(mk)
+	t.Logf(ast.String())
+	assert.Nil(t, ast.Validate())
+	types = fTypes(&ast)
+	for _, tp := range []string{"Header", "Table", "StrikeThrough", "Math", "FootNote", "FootNoteIndex"} {
+		assert.Equal(t, 0, types[tp], tp)
+	}
+	list := ast.Root.Children[1]
+	assert.Equal(t, false, list.Type.(*List).IsTask)
+	assert.Equal(t, "[ ] task", list.Children[0].Children[0].Text()[1:])
+
+	gfm := GetProfileParser(ProfileGFM)
+	ast = gfm.Parse(mk)
+	types = fTypes(&ast)
+	assert.Equal(t, 0, types["Header"])
+	assert.Equal(t, 0, types["Math"])
+	for _, tp := range []string{"Table", "StrikeThrough", "FootNote", "FootNoteIndex"} {
+		assert.Equal(t, 1, types[tp], tp)
+	}
+	assert.Equal(t, true, ast.Root.Children[1].Children[0].Type.(*ListItem).IsTask)
+}
@@ -0,0 +1,172 @@
+package parserlib
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// text inside these nodes is left untouched by post parsers
+func _isVerbatimNode(node *AstNode) bool {
+	switch node.Type.(type) {
+	case *Code, *Math, *CodeBlock, *MathBlock:
+		return true
+	case *Link, *SimpleLink, *ReferenceLink, *Image:
+		return true
+	case *HtmlStartTag, *HtmlEndTag:
+		return true
+	case *AbbreviationIndex, *Abbreviation:
+		return true
+	}
+	return false
+}
+
+// visit every leaf text node outside verbatim nodes
+func _visitPlainText(node *AstNode, f func(*AstNode)) {
+	if node == nil || _isVerbatimNode(node) {
+		return
+	}
+	if _, ok := node.Type.(*Text); ok && len(node.Inlines()) == 0 {
+		if node.End.Offset > node.Start.Offset {
+			f(node)
+		}
+		return
+	}
+	// f may change the children
+	children := append([]*AstNode{}, node.Children...)
+	for _, ch := range children {
+		_visitPlainText(ch, f)
+	}
+}
+
+// replace the leaf text node with pieces covering the same range
+func _spliceText(node *AstNode, pieces []*AstNode) {
+	parent := node.Parent
+	isContainer := false
+	if parent != nil {
+		_, isContainer = parent.Type.(*Text)
+	}
+	if !isContainer {
+		// turn the leaf into a text container
+		var leftSib *AstNode
+		for _, piece := range pieces {
+			piece.Parent = node
+			piece.LeftSibling = leftSib
+			leftSib = piece
+		}
+		node.Children = pieces
+		return
+	}
+	// the pieces inherit the syntax of the text from the container
+	parent.Syntax = append(parent.Syntax, node.Syntax...)
+	children := make([]*AstNode, 0, len(parent.Children)+len(pieces)-1)
+	for _, ch := range parent.Children {
+		if ch == node {
+			children = append(children, pieces...)
+		} else {
+			children = append(children, ch)
+		}
+	}
+	var leftSib *AstNode
+	for _, ch := range children {
+		ch.Parent = parent
+		ch.LeftSibling = leftSib
+		leftSib = ch
+	}
+	parent.Children = children
+}
+
+func _isWordRune(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// split the leaf text node into Text and Abbreviation nodes, nil if no abbreviation occurs
+func _splitAbbreviations(node *AstNode, s string, abbrs []string, definitions map[string]string) []*AstNode {
+	text := s[node.Start.Offset:node.End.Offset]
+	var pieces []*AstNode
+	curPos := node.Start
+	fAddPiece := func(tp AstNodeType, str string) {
+		endPos := curPos
+		endPos.ConsumeStr(str)
+		pieces = append(pieces, &AstNode{
+			Type:  tp,
+			Start: curPos,
+			End:   endPos,
+		})
+		curPos = endPos
+	}
+
+	textStart := 0
+	lastRune := utf8.RuneError
+	for i := 0; i < len(text); {
+		c, l := utf8.DecodeRuneInString(text[i:])
+		if i == 0 || !_isWordRune(lastRune) {
+			for _, abbr := range abbrs {
+				if !strings.HasPrefix(text[i:], abbr) {
+					continue
+				}
+				next, _ := utf8.DecodeRuneInString(text[i+len(abbr):])
+				if i+len(abbr) < len(text) && _isWordRune(next) {
+					continue
+				}
+				if textStart < i {
+					fAddPiece(&Text{}, text[textStart:i])
+				}
+				fAddPiece(&Abbreviation{Abbr: abbr, Definition: definitions[abbr]}, abbr)
+				i += len(abbr)
+				textStart = i
+				c, _ = utf8.DecodeLastRuneInString(abbr)
+				l = 0
+				break
+			}
+		}
+		lastRune = c
+		i += l
+	}
+	if len(pieces) == 0 {
+		return nil
+	}
+	if textStart < len(text) {
+		fAddPiece(&Text{}, text[textStart:])
+	}
+	return pieces
+}
+
+// definitions of the AbbreviationIndex nodes under nodes
+func _abbreviationDefinitions(nodes []*AstNode) map[string]string {
+	definitions := map[string]string{}
+	for _, node := range nodes {
+		node.PreVisit(func(node *AstNode) {
+			if tp, ok := node.Type.(*AbbreviationIndex); ok {
+				definitions[tp.Abbr] = tp.Definition
+			}
+		})
+	}
+	return definitions
+}
+
+// wrap whole-word occurrences of abbreviations defined by AbbreviationIndex
+func applyAbbreviations(ast *Ast, s string) {
+	definitions := _abbreviationDefinitions([]*AstNode{&ast.Root})
+	if len(definitions) == 0 {
+		return
+	}
+	abbrs := make([]string, 0, len(definitions))
+	for abbr := range definitions {
+		abbrs = append(abbrs, abbr)
+	}
+	// prefer the longest abbreviation
+	sort.Slice(abbrs, func(i, j int) bool {
+		if len(abbrs[i]) != len(abbrs[j]) {
+			return len(abbrs[i]) > len(abbrs[j])
+		}
+		return abbrs[i] < abbrs[j]
+	})
+
+	_visitPlainText(&ast.Root, func(node *AstNode) {
+		if pieces := _splitAbbreviations(node, s, abbrs, definitions); pieces != nil {
+			_spliceText(node, pieces)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package parserlib
+
+import "fmt"
+
+// ParserOrder places a named parser relative to other parsers of the same kind.
+// The parser is tried right before Before if it's set, otherwise right after After,
+// and before every other parser if neither is set.
+type ParserOrder struct {
+	Before string
+	After  string
+}
+
+// names may be out of sync if the parser sequences are changed directly
+func _fitNames(names []string, n int) []string {
+	for len(names) < n {
+		names = append(names, "")
+	}
+	return names[:n]
+}
+
+func _findName(names []string, name string) int {
+	for i, cur := range names {
+		if cur == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// index in a sequence tried backwards where a parser ordered by order is inserted
+func _orderIndex(names []string, order ParserOrder) (int, error) {
+	idx := len(names)
+	if len(order.Before) > 0 {
+		before := _findName(names, order.Before)
+		if before < 0 {
+			return 0, fmt.Errorf("parser %s is not registered", order.Before)
+		}
+		idx = before + 1
+	}
+	if len(order.After) > 0 {
+		after := _findName(names, order.After)
+		if after < 0 {
+			return 0, fmt.Errorf("parser %s is not registered", order.After)
+		}
+		if len(order.Before) == 0 {
+			idx = after
+		} else if after < idx {
+			return 0, fmt.Errorf("parser %s is tried after %s", order.After, order.Before)
+		}
+	}
+	return idx, nil
+}
+
+// names from the first parser tried to the last one
+func _reversedNames(names []string) []string {
+	res := make([]string, len(names))
+	for i, name := range names {
+		res[len(names)-1-i] = name
+	}
+	return res
+}
+
+// MKParser is returned by value, so its copies share the parser sequences.
+// The sequences are copied before they are changed, so that a copy is never affected.
+func (parser *MKParser) ownBlockParsers() {
+	parser.blockParserNames = append([]string{}, _fitNames(parser.blockParserNames, len(parser.BlockParserSeq))...)
+	parser.BlockParserSeq = append([]BlockParser{}, parser.BlockParserSeq...)
+}
+
+func (parser *MKParser) ownInlineParsers() {
+	seqs := make(map[rune][]InlineParser, len(parser.InlineParserSeq))
+	names := make(map[rune][]string, len(parser.InlineParserSeq))
+	for c, seq := range parser.InlineParserSeq {
+		seqs[c] = append([]InlineParser{}, seq...)
+		names[c] = append([]string{}, _fitNames(parser.inlineParserNames[c], len(seq))...)
+	}
+	parser.InlineParserSeq = seqs
+	parser.inlineParserNames = names
+}
+
+func (parser *MKParser) ownPostParsers() {
+	parser.postParserNames = append([]string{}, _fitNames(parser.postParserNames, len(parser.PostParserSeq))...)
+	parser.PostParserSeq = append([]PostParser{}, parser.PostParserSeq...)
+}
+
+func (parser *MKParser) appendBlockParser(name string, method BlockParser) {
+	parser.ownBlockParsers()
+	parser.blockParserNames = append(parser.blockParserNames, name)
+	parser.BlockParserSeq = append(parser.BlockParserSeq, method)
+}
+
+func (parser *MKParser) appendInlineParser(name string, lookAhead rune, method InlineParser) {
+	parser.ownInlineParsers()
+	parser.inlineParserNames[lookAhead] = append(parser.inlineParserNames[lookAhead], name)
+	parser.InlineParserSeq[lookAhead] = append(parser.InlineParserSeq[lookAhead], method)
+}
+
+func (parser *MKParser) appendPostParser(name string, method PostParser) {
+	parser.ownPostParsers()
+	parser.postParserNames = append(parser.postParserNames, name)
+	parser.PostParserSeq = append(parser.PostParserSeq, method)
+}
+
+// RegisterBlockParser adds a named block parser placed by order
+func (parser *MKParser) RegisterBlockParser(name string, method BlockParser, order ParserOrder) error {
+	names := _fitNames(parser.blockParserNames, len(parser.BlockParserSeq))
+	if len(name) == 0 || _findName(names, name) >= 0 {
+		return fmt.Errorf("invalid or duplicated block parser name: %q", name)
+	}
+	idx, err := _orderIndex(names, order)
+	if err != nil {
+		return err
+	}
+	parser.ownBlockParsers()
+	parser.blockParserNames = append(parser.blockParserNames[:idx], append([]string{name}, parser.blockParserNames[idx:]...)...)
+	parser.BlockParserSeq = append(parser.BlockParserSeq[:idx], append([]BlockParser{method}, parser.BlockParserSeq[idx:]...)...)
+	return nil
+}
+
+// RegisterInlineParser adds a named inline parser for lookAhead placed by order among the parsers of lookAhead
+func (parser *MKParser) RegisterInlineParser(name string, lookAhead rune, method InlineParser, order ParserOrder) error {
+	names := _fitNames(parser.inlineParserNames[lookAhead], len(parser.InlineParserSeq[lookAhead]))
+	if len(name) == 0 || _findName(names, name) >= 0 {
+		return fmt.Errorf("invalid or duplicated inline parser name for %q: %q", lookAhead, name)
+	}
+	idx, err := _orderIndex(names, order)
+	if err != nil {
+		return err
+	}
+	parser.ownInlineParsers()
+	names, seq := parser.inlineParserNames[lookAhead], parser.InlineParserSeq[lookAhead]
+	parser.inlineParserNames[lookAhead] = append(names[:idx], append([]string{name}, names[idx:]...)...)
+	parser.InlineParserSeq[lookAhead] = append(seq[:idx], append([]InlineParser{method}, seq[idx:]...)...)
+	return nil
+}
+
+// RemoveBlockParser removes the block parser named name and the post parser of the same name
+// it comes with, it returns false if there's no such block parser
+func (parser *MKParser) RemoveBlockParser(name string) bool {
+	idx := _findName(_fitNames(parser.blockParserNames, len(parser.BlockParserSeq)), name)
+	if len(name) == 0 || idx < 0 {
+		return false
+	}
+	parser.ownBlockParsers()
+	parser.blockParserNames = append(parser.blockParserNames[:idx], parser.blockParserNames[idx+1:]...)
+	parser.BlockParserSeq = append(parser.BlockParserSeq[:idx], parser.BlockParserSeq[idx+1:]...)
+	parser.RemovePostParser(name)
+	return true
+}
+
+// RemoveInlineParser removes the inline parsers named name of every look ahead rune,
+// it returns false if there's no such parser
+func (parser *MKParser) RemoveInlineParser(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	found := false
+	for lookAhead, seq := range parser.InlineParserSeq {
+		if _findName(_fitNames(parser.inlineParserNames[lookAhead], len(seq)), name) >= 0 {
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+	parser.ownInlineParsers()
+	for lookAhead, seq := range parser.InlineParserSeq {
+		names := parser.inlineParserNames[lookAhead]
+		if idx := _findName(names, name); idx >= 0 {
+			parser.inlineParserNames[lookAhead] = append(names[:idx], names[idx+1:]...)
+			parser.InlineParserSeq[lookAhead] = append(seq[:idx], seq[idx+1:]...)
+		}
+	}
+	return true
+}
+
+// RemovePostParser removes the post parser named name, it returns false if there's no such parser
+func (parser *MKParser) RemovePostParser(name string) bool {
+	idx := _findName(_fitNames(parser.postParserNames, len(parser.PostParserSeq)), name)
+	if len(name) == 0 || idx < 0 {
+		return false
+	}
+	parser.ownPostParsers()
+	parser.postParserNames = append(parser.postParserNames[:idx], parser.postParserNames[idx+1:]...)
+	parser.PostParserSeq = append(parser.PostParserSeq[:idx], parser.PostParserSeq[idx+1:]...)
+	return true
+}
+
+// ReplaceBlockParser replaces the block parser named name keeping its order
+func (parser *MKParser) ReplaceBlockParser(name string, method BlockParser) error {
+	idx := _findName(_fitNames(parser.blockParserNames, len(parser.BlockParserSeq)), name)
+	if len(name) == 0 || idx < 0 {
+		return fmt.Errorf("block parser %s is not registered", name)
+	}
+	parser.ownBlockParsers()
+	parser.BlockParserSeq[idx] = method
+	return nil
+}
+
+// ReplaceInlineParser replaces the inline parser named name for lookAhead keeping its order
+func (parser *MKParser) ReplaceInlineParser(name string, lookAhead rune, method InlineParser) error {
+	idx := _findName(_fitNames(parser.inlineParserNames[lookAhead], len(parser.InlineParserSeq[lookAhead])), name)
+	if len(name) == 0 || idx < 0 {
+		return fmt.Errorf("inline parser %s is not registered for %q", name, lookAhead)
+	}
+	parser.ownInlineParsers()
+	parser.InlineParserSeq[lookAhead][idx] = method
+	return nil
+}
+
+// BlockParserNames returns the names of block parsers in the order they are tried,
+// anonymous extensions are named ""
+func (parser *MKParser) BlockParserNames() []string {
+	return _reversedNames(_fitNames(parser.blockParserNames, len(parser.BlockParserSeq)))
+}
+
+// InlineParserNames returns the names of inline parsers for lookAhead in the order they are tried,
+// anonymous extensions are named ""
+func (parser *MKParser) InlineParserNames(lookAhead rune) []string {
+	return _reversedNames(_fitNames(parser.inlineParserNames[lookAhead], len(parser.InlineParserSeq[lookAhead])))
+}
+
+// PostParserNames returns the names of post parsers in the order they run,
+// anonymous extensions are named ""
+func (parser *MKParser) PostParserNames() []string {
+	return append([]string{}, _fitNames(parser.postParserNames, len(parser.PostParserSeq))...)
+}
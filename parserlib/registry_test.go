@@ -0,0 +1,115 @@
+package parserlib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParserRegistry(t *testing.T) {
+	parser := GetFullMKParser()
+	assert.Equal(t, []string{
+		"HorizontalRule", "Header", "QuoteBlock", "CodeBlock", "MathBlock", "Table", "List", "FootNoteIndex", "ReferenceLinkIndex", "AbbreviationIndex",
+	}, parser.BlockParserNames())
+	assert.Equal(t, []string{"Link", "Citation", "FootNote", "ReferenceLink"}, parser.InlineParserNames('['))
+	assert.Equal(t, []string{"Emphasis", "Italic"}, parser.InlineParserNames('*'))
+
+	// ==mark== before Emphasis
+	parseMark := func(s string, ctx ParseContext) *AstNode {
+		if !strings.HasPrefix(s, "**mark**") {
+			return nil
+		}
+		end := ctx.P
+		end.ConsumeStr("**mark**")
+		return &AstNode{Type: &Code{}, Start: ctx.P, End: end, Parent: ctx.Parent, LeftSibling: ctx.LeftSibling}
+	}
+	assert.Equal(t, nil, parser.RegisterInlineParser("Mark", '*', parseMark, ParserOrder{Before: "Emphasis"}))
+	assert.Equal(t, []string{"Mark", "Emphasis", "Italic"}, parser.InlineParserNames('*'))
+	assert.NotNil(t, parser.RegisterInlineParser("Mark", '*', parseMark, ParserOrder{}))
+	assert.NotNil(t, parser.RegisterInlineParser("Other", '*', parseMark, ParserOrder{Before: "Missing"}))
+	assert.NotNil(t, parser.RegisterInlineParser("Other", '*', parseMark, ParserOrder{Before: "Emphasis", After: "Italic"}))
+	assert.Equal(t, nil, parser.RegisterInlineParser("Other", '*', parseMark, ParserOrder{After: "Italic"}))
+	assert.Equal(t, []string{"Mark", "Emphasis", "Italic", "Other"}, parser.InlineParserNames('*'))
+	ast := parser.Parse("**mark** and **strong**")
+	assert.Equal(t, "Code", ast.Root.Children[0].Children[0].Type.String())
+	assert.Equal(t, "Emphasis", ast.Root.Children[0].Children[2].Type.String())
+
+	// remove and replace defaults
+	assert.Equal(t, true, parser.RemoveInlineParser("Emphasis"))
+	assert.Equal(t, false, parser.RemoveInlineParser("Emphasis"))
+	assert.Equal(t, []string{"Mark", "Italic", "Other"}, parser.InlineParserNames('*'))
+	assert.Equal(t, []string{"Italic"}, parser.InlineParserNames('_'))
+	assert.Equal(t, true, parser.RemoveBlockParser("Table"))
+	assert.Equal(t, nil, parser.ReplaceBlockParser("HorizontalRule", func(s string, ctx ParseContext) *AstNode {
+		return nil
+	}))
+	assert.NotNil(t, parser.ReplaceBlockParser("Table", parseTable))
+	ast = parser.Parse("---\n| a |\n| - |\n")
+	assert.Equal(t, 1, len(ast.Root.Children))
+	assert.Equal(t, "Text", ast.Root.Children[0].Type.String())
+
+	// named block parser between anonymous extensions
+	parser.AddExtensionBlockParser(parseTable)
+	assert.Equal(t, nil, parser.RegisterBlockParser("Table", parseTable, ParserOrder{After: "List"}))
+	names := parser.BlockParserNames()
+	assert.Equal(t, []string{"", "HorizontalRule", "Header"}, names[:3])
+	assert.Equal(t, "Table", names[_findName(names, "List")+1])
+	assert.Equal(t, names, parser.Freeze().BlockParserNames())
+}
+
+func TestParserRegistryCopy(t *testing.T) {
+	mk := "---\n\n| a |\n| - |\n\n**strong** HTML\n\n*[HTML]: Hyper\n"
+	parsed := func(parser MKParser) string {
+		ast := parser.Parse(mk)
+		return ast.String()
+	}
+	parser := GetFullMKParser()
+	want := parsed(parser)
+	blockNames, inlineNames := parser.BlockParserNames(), parser.InlineParserNames('*')
+
+	// copies share nothing with the parser they are copied from
+	other := parser
+	assert.Equal(t, true, other.RemoveBlockParser("Table"))
+	assert.Equal(t, true, other.RemoveInlineParser("Emphasis"))
+	assert.Equal(t, nil, other.ReplaceBlockParser("HorizontalRule", func(s string, ctx ParseContext) *AstNode {
+		return nil
+	}))
+	assert.Equal(t, nil, other.ReplaceInlineParser("Italic", '*', func(s string, ctx ParseContext) *AstNode {
+		return nil
+	}))
+	assert.Equal(t, nil, other.RegisterBlockParser("Other", parseTable, ParserOrder{}))
+	assert.Equal(t, nil, other.RegisterInlineParser("Other", '*', parseCode, ParserOrder{}))
+	other.AddExtensionBlockParser(parseTable)
+	other.AddExtensionInlineParser('*', parseCode)
+	other.AddExtensionPostParser(func(ast *Ast, s string) {
+		ast.Root.Children = nil
+	})
+	assert.NotEqual(t, want, parsed(other))
+
+	assert.Equal(t, blockNames, parser.BlockParserNames())
+	assert.Equal(t, inlineNames, parser.InlineParserNames('*'))
+	assert.Equal(t, want, parsed(parser))
+	assert.Equal(t, want, parsed(GetFullMKParser()))
+}
+
+func TestPostParserRegistry(t *testing.T) {
+	parser := GetFullMKParser()
+	parser.AddExtensionPostParser(func(ast *Ast, s string) {})
+	assert.Equal(t, []string{"AbbreviationIndex", ""}, parser.PostParserNames())
+	assert.Equal(t, parser.PostParserNames(), parser.Freeze().PostParserNames())
+
+	// the abbreviations go with their definitions
+	other := parser
+	assert.Equal(t, true, other.RemoveBlockParser("AbbreviationIndex"))
+	assert.Equal(t, []string{""}, other.PostParserNames())
+	assert.Equal(t, false, other.RemovePostParser("AbbreviationIndex"))
+	mk := "HTML\n\n*[HTML]: Hyper\n"
+	ast := other.Parse(mk)
+	assert.NotContains(t, ast.String(), "Abbreviation(")
+	ast = parser.Parse(mk)
+	assert.Contains(t, ast.String(), "Abbreviation(")
+	assert.Equal(t, true, parser.RemovePostParser("AbbreviationIndex"))
+	ast = parser.Parse(mk)
+	assert.NotContains(t, ast.String(), "Abbreviation(")
+}
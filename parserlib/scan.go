@@ -0,0 +1,199 @@
+package parserlib
+
+import (
+	"sort"
+	"strings"
+)
+
+// scanCache memorizes the scans of parsers over the text of a parseText or parseBlocks call,
+// so that a long text full of unmatched delimiters is parsed in linear time instead of
+// every delimiter scanning to the end of the text.
+// A parser receives a suffix of the text, lookups for other strings fall back to scanning.
+type scanCache struct {
+	s    string
+	base int
+	// offsets of the unescaped occurrences of a byte
+	positions map[byte][]int
+	// offset of the ']' matching a '[', -1 if it's unmatched in its line
+	brackets map[int]int
+	// runs of backticks, their starts by length
+	runStarts []int
+	runEnds   []int
+	runsByLen map[int][]int
+	// offset from which fenced blocks can't be closed, by notation and indentation
+	unclosed map[string]int
+	// emphasis nodes by their starts, see emphasisMatches
+	emphasis map[int]emphasisMatch
+}
+
+func newScanCache(s string, base int) *scanCache {
+	return &scanCache{s: s, base: base}
+}
+
+// offset of s in the cached text, -1 if s isn't a suffix of it starting at offset at
+func (scan *scanCache) index(s string, at int) int {
+	if scan == nil {
+		return -1
+	}
+	i := at - scan.base
+	if i < 0 || i+len(s) != len(scan.s) {
+		return -1
+	}
+	return i
+}
+
+// _findInLine(s, string(c)), s starts at offset at
+func (scan *scanCache) findByte(s string, at int, c byte) int {
+	i := scan.index(s, at)
+	if i < 0 {
+		return _findInLine(s, string(c))
+	}
+	if len(s) == 0 {
+		return -1
+	}
+	if s[0] == c {
+		// it can't be escaped by a character out of s
+		return 0
+	}
+	positions, ok := scan.positions[c]
+	if !ok {
+		for j := 0; j < len(scan.s); j++ {
+			if scan.s[j] == c && (j == 0 || scan.s[j-1] != '\\') {
+				positions = append(positions, j)
+			}
+		}
+		if scan.positions == nil {
+			scan.positions = make(map[byte][]int)
+		}
+		scan.positions[c] = positions
+	}
+	k := sort.SearchInts(positions, i+1)
+	if k == len(positions) {
+		return -1
+	}
+	return positions[k] - i
+}
+
+// _findRightBracket(s), s starts at offset at
+func (scan *scanCache) findRightBracket(s string, at int) int {
+	i := scan.index(s, at)
+	if i < 0 {
+		return _findRightBracket(s)
+	}
+	if match, ok := scan.brackets[i]; ok {
+		if match < 0 {
+			return -1
+		}
+		return match - i
+	}
+	if scan.brackets == nil {
+		scan.brackets = make(map[int]int)
+	}
+
+	// match every '[' met on the way, escaped ones don't count for the others
+	// but are matched as if they were the first one
+	type opening struct {
+		offset  int
+		escaped bool
+	}
+	stack := []opening{{offset: i}}
+	for j := i + 1; j < len(scan.s) && len(stack) > 0; j++ {
+		c := scan.s[j]
+		if c == '\n' {
+			break
+		}
+		escaped := scan.s[j-1] == '\\'
+		if c == '[' {
+			stack = append(stack, opening{offset: j, escaped: escaped})
+		} else if c == ']' && !escaped {
+			for len(stack) > 0 && stack[len(stack)-1].escaped {
+				scan.brackets[stack[len(stack)-1].offset] = j
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) > 0 {
+				scan.brackets[stack[len(stack)-1].offset] = j
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	for _, open := range stack {
+		scan.brackets[open.offset] = -1
+	}
+	if match := scan.brackets[i]; match >= 0 {
+		return match - i
+	}
+	return -1
+}
+
+// length of the run of backticks s starts with, and the index in s of the next
+// run of the same length, -1 if not found. s starts at offset at.
+func (scan *scanCache) findBacktickRun(s string, at int) (int, int) {
+	i := scan.index(s, at)
+	if i < 0 {
+		run := len(s) - len(strings.TrimLeft(s, "`"))
+		if run == 0 {
+			return 0, -1
+		}
+		idx := _findBacktickRun(s[run:], run)
+		if idx >= 0 {
+			idx += run
+		}
+		return run, idx
+	}
+	if scan.runsByLen == nil {
+		scan.runsByLen = make(map[int][]int)
+		for j := 0; j < len(scan.s); {
+			if scan.s[j] != '`' {
+				j += 1
+				continue
+			}
+			end := j + 1
+			for end < len(scan.s) && scan.s[end] == '`' {
+				end += 1
+			}
+			scan.runStarts = append(scan.runStarts, j)
+			scan.runEnds = append(scan.runEnds, end)
+			scan.runsByLen[end-j] = append(scan.runsByLen[end-j], j)
+			j = end
+		}
+	}
+	run := 0
+	// the run containing i
+	if k := sort.SearchInts(scan.runStarts, i+1) - 1; k >= 0 && scan.runEnds[k] > i {
+		run = scan.runEnds[k] - i
+	}
+	if run == 0 {
+		return 0, -1
+	}
+	starts := scan.runsByLen[run]
+	k := sort.SearchInts(starts, i+run)
+	if k == len(starts) {
+		return run, -1
+	}
+	return run, starts[k] - i
+}
+
+// whether a fenced block of key opened at the beginning of s is known to be unclosed,
+// its content starts at s[content]. s starts at offset at.
+func (scan *scanCache) isUnclosed(key string, s string, at int, content int) bool {
+	i := scan.index(s, at)
+	if i < 0 {
+		return false
+	}
+	first, ok := scan.unclosed[key]
+	return ok && i+content >= first
+}
+
+// no fenced block of key with its content starting at or after s[content] can be closed
+func (scan *scanCache) setUnclosed(key string, s string, at int, content int) {
+	i := scan.index(s, at)
+	if i < 0 {
+		return
+	}
+	if scan.unclosed == nil {
+		scan.unclosed = make(map[string]int)
+	}
+	if first, ok := scan.unclosed[key]; !ok || i+content < first {
+		scan.unclosed[key] = i + content
+	}
+}
@@ -0,0 +1,219 @@
+package parserlib
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// splits a document into chunks of whole top-level blocks, line by line
+type blockSplitter struct {
+	// whether the current chunk has any line
+	started bool
+	// last non-blank line of the current chunk
+	lastLine string
+	// notation of the fenced block opened at column 0, empty if none
+	fence     string
+	lastBlank bool
+}
+
+func _isCaptionLine(line string) bool {
+	return strings.HasPrefix(line, "Table:") || strings.HasPrefix(line, ": ")
+}
+
+// whether line starts a new chunk, i.e. no block before it can continue on it
+func (sp *blockSplitter) isBoundary(line string) bool {
+	line = strings.TrimSuffix(line, "\n")
+	if !sp.started || len(sp.fence) > 0 || !sp.lastBlank || _isBlankLine(line) {
+		return false
+	}
+	if line[0] == ' ' || line[0] == '\t' {
+		// indented blocks may belong to a footnote definition
+		return false
+	}
+	// table captions may be separated from the table by a blank line
+	return !_isCaptionLine(line) && !_isCaptionLine(sp.lastLine)
+}
+
+func (sp *blockSplitter) add(line string) {
+	content := strings.TrimSuffix(line, "\n")
+	if len(sp.fence) > 0 {
+		if content == sp.fence || (sp.fence == "$$" && strings.HasPrefix(content, "$$") && _isLabelSuffix(content[2:])) {
+			sp.fence = ""
+		}
+	} else if strings.HasPrefix(content, "```") {
+		sp.fence = "```"
+	} else if strings.HasPrefix(content, "$$") {
+		sp.fence = "$$"
+	}
+	// paragraphs end at empty lines only
+	sp.lastBlank = len(content) == 0
+	if !sp.lastBlank {
+		sp.lastLine = content
+	}
+	sp.started = true
+}
+
+// start a new chunk
+func (sp *blockSplitter) reset() {
+	sp.started = false
+	sp.lastLine = ""
+	sp.lastBlank = false
+}
+
+// offsets of the lines starting a chunk in s, 0 and len(s) included
+func _chunkBoundaries(s string) []int {
+	boundaries := []int{0}
+	_scanChunks(s, 0, func(offset int) bool {
+		boundaries = append(boundaries, offset)
+		return true
+	})
+	return boundaries
+}
+
+// calls f with the offsets of the lines starting a chunk in s after start, a chunk starts
+// at start, and then with len(s) once the end is reached. The scan stops once f returns false.
+func _scanChunks(s string, start int, f func(offset int) bool) {
+	sp := &blockSplitter{}
+	for cur := start; cur < len(s); {
+		end := _lineEnd(s, cur)
+		if end < len(s) {
+			end += 1
+		}
+		if sp.isBoundary(s[cur:end]) {
+			if !f(cur) {
+				return
+			}
+			sp.reset()
+		}
+		sp.add(s[cur:end])
+		cur = end
+	}
+	if start < len(s) {
+		f(len(s))
+	}
+}
+
+// whether a chunk starts at the line at offset of s given that no fenced block is open before it,
+// as at the start of a chunk of an unchanged prefix of s
+func _isChunkStart(s string, offset int) bool {
+	if offset == 0 {
+		return true
+	}
+	sp := &blockSplitter{started: true}
+	for end := offset - 1; end >= 0 && s[end] == '\n'; {
+		lineStart := strings.LastIndexByte(s[:end], '\n') + 1
+		if lineStart < end {
+			sp.lastLine = s[lineStart:end]
+			break
+		}
+		// the line before offset is empty
+		sp.lastBlank = true
+		end = lineStart - 1
+	}
+	return sp.isBoundary(s[offset:_lineEnd(s, offset)])
+}
+
+// bytes a chunk of ParseReader may grow to, fenced blocks left open run to the end of the input
+var readerChunkLimit = 16 << 20
+
+// reads a line ended by "\n", "\r\n" or "\r", or the first limit+1 bytes of a longer line
+func _readLine(reader *bufio.Reader, limit int) (string, error) {
+	var builder strings.Builder
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return builder.String(), err
+		}
+		builder.WriteByte(c)
+		if c == '\n' {
+			return builder.String(), nil
+		} else if c == '\r' {
+			if next, err := reader.Peek(1); err == nil && next[0] == '\n' {
+				reader.ReadByte()
+				builder.WriteByte('\n')
+			}
+			return builder.String(), nil
+		} else if builder.Len() > limit {
+			return builder.String(), nil
+		}
+	}
+}
+
+// ParseReader parses the document read from r and calls emit with every top-level block
+// as soon as it is complete, so that only the blocks being parsed are kept in memory.
+// The document is split at empty lines followed by an unindented line outside fenced blocks.
+// The input is normalized like Parse does, chunk by chunk.
+// Emitted nodes keep the source of their chunk only for Text.
+// Emitted blocks share a childless Document parent,
+// LeftSibling links only blocks parsed together and post parsers are not run.
+// Parsing stops at the first error returned by emit or r, or with a LimitError of
+// LimitInputSize once a chunk, e.g. a fenced block that is never closed, grows larger than 16MiB.
+func (parser *MKParser) ParseReader(r io.Reader, emit func(*AstNode) error) error {
+	root := &AstNode{Type: &Document{}}
+	reader := bufio.NewReader(r)
+	sp := &blockSplitter{}
+	var pending strings.Builder
+	pos := Pos{Line: 0, Col: 0, Offset: 0}
+
+	fParseChunk := func() error {
+		chunk := pending.String()
+		pending.Reset()
+		sp.reset()
+		m := newSourceMap(chunk, false)
+		ctx := ParseContext{
+			P:           pos,
+			Parent:      root,
+			LeftSibling: nil,
+			ParseText:   parser.parseText,
+			ParseBlocks: parser.parseBlocks,
+			trace:       parser.newTrace(m, pos.Offset),
+		}
+		src := &nodeSource{s: chunk, base: pos.Offset}
+		for _, node := range parser.parseBlocks(m.norm, ctx) {
+			m.remap(node, pos.Offset)
+			_setSource(node, src)
+			if err := emit(node); err != nil {
+				return err
+			}
+		}
+		start := pos.Offset
+		pos.ConsumeStr(m.norm)
+		pos.Offset = start + len(chunk)
+		return nil
+	}
+
+	for {
+		line, err := _readLine(reader, readerChunkLimit)
+		if pos.Offset == 0 && pending.Len() == 0 && strings.HasPrefix(line, utf8BOM) {
+			line = line[len(utf8BOM):]
+			pos.Offset = len(utf8BOM)
+		}
+		if len(line) > 0 {
+			normLine := strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			if len(normLine) < len(line) {
+				normLine += "\n"
+			}
+			if sp.isBoundary(normLine) {
+				if err := fParseChunk(); err != nil {
+					return err
+				}
+			}
+			sp.add(normLine)
+			pending.WriteString(line)
+		}
+		if pending.Len() > readerChunkLimit {
+			// the block starting the chunk can't be closed
+			return &LimitError{Limit: LimitInputSize, Pos: pos}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if pending.Len() > 0 {
+		return fParseChunk()
+	}
+	return nil
+}
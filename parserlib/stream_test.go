@@ -0,0 +1,124 @@
+package parserlib
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReader(t *testing.T) {
+	mk := "# 标题\n" +
+		"first *paragraph*\n" +
+		"\n" +
+		"```go\n" +
+		"code\n" +
+		"\n" +
+		"more code\n" +
+		"```\n" +
+		"\n" +
+		"| a | b |\n" +
+		"| -- | -- |\n" +
+		"| 1 | 2 |\n" +
+		"\n" +
+		"Table: caption\n" +
+		"\n" +
+		"[^1]: note\n" +
+		"\n" +
+		"    second paragraph of the note\n" +
+		"\n" +
+		"- item\n" +
+		"- item\n" +
+		"\n" +
+		"last"
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+
+	var blocks []*AstNode
+	err := parser.ParseReader(iotest.OneByteReader(strings.NewReader(mk)), func(node *AstNode) error {
+		blocks = append(blocks, node)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(ast.Root.Children), len(blocks))
+	for i, node := range blocks {
+		assert.Equal(t, ast.Root.Children[i].String(), node.String())
+		assert.Equal(t, ast.Root.Children[i].End, node.End)
+		assert.Equal(t, "Document", node.Parent.Type.String())
+	}
+
+	stop := errors.New("stop")
+	cnt := 0
+	err = parser.ParseReader(strings.NewReader(mk), func(node *AstNode) error {
+		cnt += 1
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, cnt)
+}
+
+func TestParseReaderLineEndings(t *testing.T) {
+	// lines ended by "\r" only are read one by one
+	mk := "# a\r\rtext\rmore\r\r- item\r"
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+	var blocks []*AstNode
+	reader := bufio.NewReaderSize(strings.NewReader(mk), 16)
+	err := parser.ParseReader(reader, func(node *AstNode) error {
+		blocks = append(blocks, node)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, len(ast.Root.Children), len(blocks))
+	for i, node := range blocks {
+		assert.Equal(t, ast.Root.Children[i].String(), node.String())
+		assert.Equal(t, ast.Root.Children[i].End, node.End)
+	}
+	assert.Equal(t, "text\rmore\r", blocks[1].Text())
+}
+
+func TestParseReaderChunkLimit(t *testing.T) {
+	defer func(limit int) { readerChunkLimit = limit }(readerChunkLimit)
+	readerChunkLimit = 64
+	parser := GetFullMKParser()
+
+	// blocks of the limit are still parsed
+	mk := "para\n\n" + strings.Repeat("a", 60) + "\n\nlast\n"
+	cnt := 0
+	err := parser.ParseReader(strings.NewReader(mk), func(node *AstNode) error {
+		cnt += 1
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, cnt)
+
+	// lines ended by "\r" only count one by one
+	cnt = 0
+	err = parser.ParseReader(strings.NewReader(strings.Repeat("para\r\r", 100)), func(node *AstNode) error {
+		cnt += 1
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, cnt)
+
+	// an unclosed fence or a long line can't grow the chunk to the end of the input
+	for _, mk := range []string{
+		"para\n\n```\n" + strings.Repeat("code\n\n", 100),
+		"para\n\n$$x$$\n" + strings.Repeat("text\n\n", 100),
+		"para\n\n" + strings.Repeat("a", 1000),
+	} {
+		cnt = 0
+		err = parser.ParseReader(strings.NewReader(mk), func(node *AstNode) error {
+			cnt += 1
+			return nil
+		})
+		var limitErr *LimitError
+		assert.True(t, errors.As(err, &limitErr), mk)
+		assert.Equal(t, LimitInputSize, limitErr.Limit)
+		assert.Equal(t, 6, limitErr.Pos.Offset)
+		assert.Equal(t, 1, cnt)
+	}
+}
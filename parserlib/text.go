@@ -0,0 +1,169 @@
+package parserlib
+
+import (
+	"strings"
+)
+
+// source of the nodes parsed together
+type nodeSource struct {
+	s string
+	// offset of s in the document, ParseReader keeps the chunk of its nodes only
+	base int
+}
+
+// set the source of the nodes under root, root included
+func _setSource(root *AstNode, src *nodeSource) {
+	root.PreVisit(func(node *AstNode) {
+		node.src = src
+	})
+}
+
+// share the source of the ast with all its nodes
+func (ast *Ast) setSource() {
+	_setSource(&ast.Root, &nodeSource{s: ast.src})
+}
+
+// the part r of the source, src is the source of the document or nil for the source of
+// the node. It's empty if there is no source, like for nodes built by hand or by
+// xxmkproto.AstFromProtobuf, or if r isn't in the source.
+func (astnode *AstNode) source(src *nodeSource, r Range) string {
+	if src == nil {
+		src = astnode.src
+	}
+	if src == nil {
+		return ""
+	}
+	start, end := r.Start.Offset-src.base, r.End.Offset-src.base
+	if start < 0 || start > end || end > len(src.s) {
+		return ""
+	}
+	return src.s[start:end]
+}
+
+// Text returns the source of the node, it's empty if the node isn't parsed by a parser
+func (astnode *AstNode) Text() string {
+	return astnode.text(nil)
+}
+
+// TextOf returns the source of the node in s, the source of the document
+func (astnode *AstNode) TextOf(s string) string {
+	return astnode.text(&nodeSource{s: s})
+}
+
+func (astnode *AstNode) text(src *nodeSource) string {
+	return astnode.source(src, Range{Start: astnode.Start, End: astnode.End})
+}
+
+// Literal returns the content of the node: the body of code and math verbatim, the text of
+// headers, links and images and the source of the other nodes with backslash escapes resolved
+func (astnode *AstNode) Literal() string {
+	return astnode.literal(nil)
+}
+
+// LiteralOf returns the Literal of the node in s, the source of the document
+func (astnode *AstNode) LiteralOf(s string) string {
+	return astnode.literal(&nodeSource{s: s})
+}
+
+func (astnode *AstNode) literal(src *nodeSource) string {
+	switch tp := astnode.Type.(type) {
+	case *Code:
+		return astnode.source(src, tp.BodyRange)
+	case *Math:
+		return astnode.source(src, tp.BodyRange)
+	case *CodeBlock:
+		if tp.Indent > 0 {
+			return _stripIndent(astnode.source(src, tp.BodyRange), tp.BodyRange.Start.Col, int(tp.Indent))
+		}
+		return astnode.source(src, tp.BodyRange)
+	case *MathBlock:
+		return astnode.source(src, tp.BodyRange)
+	case *Header:
+		return _unescapeMarkdown(astnode.source(src, tp.TextRange))
+	case *Link:
+		return _unescapeMarkdown(astnode.source(src, tp.TextRange))
+	case *Image:
+		return _unescapeMarkdown(astnode.source(src, tp.TextRange))
+	default:
+		return _unescapeMarkdown(astnode.text(src))
+	}
+}
+
+// PlainText returns the text of the node and its descendants without markup. Blocks are
+// separated by '\n' and table cells by '\t', labels, html tags and definitions of
+// links and abbreviations are left out.
+func (astnode *AstNode) PlainText() string {
+	return astnode.plainText(nil)
+}
+
+// PlainTextOf returns the PlainText of the node in s, the source of the document
+func (astnode *AstNode) PlainTextOf(s string) string {
+	return astnode.plainText(&nodeSource{s: s})
+}
+
+func (astnode *AstNode) plainText(src *nodeSource) string {
+	var builder strings.Builder
+	astnode.writePlainText(&builder, src)
+	return builder.String()
+}
+
+func (astnode *AstNode) writePlainText(builder *strings.Builder, src *nodeSource) {
+	fInlines := func() {
+		for _, ch := range astnode.Inlines() {
+			ch.writePlainText(builder, src)
+		}
+	}
+	// blocks without text are skipped, cells are kept in their columns
+	fJoin := func(sep string) {
+		first := true
+		for _, ch := range astnode.Inlines() {
+			text := strings.TrimRight(ch.plainText(src), "\n")
+			if _, ok := ch.Type.(*Text); ok && sep == "\n" {
+				// the space after a list marker
+				text = strings.TrimLeft(text, " \t")
+			}
+			if len(text) == 0 && sep == "\n" {
+				continue
+			}
+			if !first {
+				builder.WriteString(sep)
+			}
+			builder.WriteString(text)
+			first = false
+		}
+	}
+	switch tp := astnode.Type.(type) {
+	case *Document, *QuoteBlock, *List, *ListItem, *FootNoteIndex, *Table:
+		fJoin("\n")
+	case *TableHead, *TableLine:
+		fJoin("\t")
+	case *Header:
+		builder.WriteString(_unescapeMarkdown(astnode.source(src, tp.TextRange)))
+	case *Code, *Math, *CodeBlock, *MathBlock:
+		builder.WriteString(astnode.literal(src))
+	case *SimpleLink:
+		builder.WriteString(tp.Link)
+	case *Label, *HtmlStartTag, *HtmlEndTag, *TableAlign, *HorizontalRule,
+		*ReferenceLinkIndex, *AbbreviationIndex, *FootNote:
+	case *Emphasis, *Italic:
+		if len(astnode.Children) > 0 {
+			fInlines()
+			break
+		}
+		n := 1
+		if _, ok := tp.(*Emphasis); ok {
+			n = 2
+		}
+		text := astnode.text(src)
+		if len(text) < 2*n {
+			break
+		}
+		builder.WriteString(_unescapeMarkdown(text[n : len(text)-n]))
+	default:
+		if len(astnode.Inlines()) > 0 {
+			fInlines()
+		} else {
+			builder.WriteString(_unescapeMarkdown(astnode.text(src)))
+		}
+	}
+}
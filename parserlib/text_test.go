@@ -0,0 +1,63 @@
+package parserlib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeText(t *testing.T) {
+	mk := "# Title \\# ##\n\n```go\nx := 1\n```\n\n" +
+		"see [the \\*docs\\*](docs.md) and `a\\b` **bold** *it* <http://a.b>[^n]\n\n" +
+		"| a | b |\n|---|---|\n| c |   |\n\n- one\n- two\n\n[^n]: note\n[r]: /url\n"
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+	t.Logf(ast.String())
+	header, code, paragraph := ast.Root.Children[0], ast.Root.Children[1], ast.Root.Children[2]
+	assert.Equal(t, "# Title \\# ##\n", header.Text())
+	assert.Equal(t, "Title #", header.Literal())
+	assert.Equal(t, "x := 1\n", code.Literal())
+	link := paragraph.Children[1]
+	assert.Equal(t, "[the \\*docs\\*](docs.md)", link.Text())
+	assert.Equal(t, "the *docs*", link.Literal())
+	assert.Equal(t, "a\\b", paragraph.Children[3].Literal())
+	assert.Equal(t, "see the *docs* and a\\b bold it http://a.b\n", paragraph.PlainText())
+	assert.Equal(t, "Title #\nx := 1\nsee the *docs* and a\\b bold it http://a.b\na\tb\nc\t\none\ntwo\nnote",
+		ast.Root.PlainText())
+
+	// the nodes follow the source through updates
+	_, err := ast.Update(TextEdit{Start: 0, End: 0, Text: "intro\n\n"})
+	assert.Nil(t, err)
+	assert.Equal(t, "# Title \\# ##\n", header.Text())
+	assert.Equal(t, "intro\n", ast.Root.Children[0].Text())
+
+	lazy := parser.ParseLazy(mk)
+	assert.Equal(t, "the *docs*", lazy.Root.Children[2].Inlines()[1].Literal())
+
+	c, err := ast.Compact()
+	assert.Nil(t, err)
+	compact := c.ToAst()
+	assert.Equal(t, "x := 1\n", compact.Root.Children[2].Literal())
+
+	// nodes of ParseReader keep the source of their chunk
+	texts := []string{}
+	src := "\uFEFF# a\r\n\r\ntext\r\n\r\n```\r\nb\r\n```\r\n"
+	err = parser.ParseReader(strings.NewReader(src), func(node *AstNode) error {
+		texts = append(texts, node.Text())
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"# a\r\n", "text\r\n", "```\r\nb\r\n```\r\n"}, texts)
+
+	// nodes without a source take it as an argument
+	node := &AstNode{Type: &Text{}, Start: Pos{Offset: 2}, End: Pos{Offset: 6}}
+	assert.Equal(t, "", node.Text())
+	assert.Equal(t, "", node.PlainText())
+	assert.Equal(t, "a\\*b", node.TextOf("x a\\*b"))
+	assert.Equal(t, "a*b", node.LiteralOf("x a\\*b"))
+	assert.Equal(t, "a*b", node.PlainTextOf("x a\\*b"))
+	assert.Equal(t, "", node.TextOf("x a"))
+	assert.Equal(t, "Title #", header.LiteralOf("intro\n\n"+mk))
+	assert.Equal(t, ast.Root.PlainText(), ast.Root.PlainTextOf("intro\n\n"+mk))
+}
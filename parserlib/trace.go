@@ -0,0 +1,157 @@
+package parserlib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TraceBlock uint32 = iota
+	TraceInline
+)
+
+// TraceEvent reports a block or inline parser tried at a position
+type TraceEvent struct {
+	// TraceBlock or TraceInline
+	Kind uint32
+	// name of the parser, empty for anonymous extensions
+	Name string
+	// the rune the inline parser is registered for
+	LookAhead rune
+	Start     Pos
+	Matched   bool
+	// the node produced, nil if the parser rejected the text
+	Node *AstNode
+	// time spent in the parser, parsers it calls included
+	Elapsed time.Duration
+}
+
+// Tracer receives an event for every parser tried. A tracer of a frozen parser
+// or of ParseParallel must be safe for concurrent use.
+type Tracer interface {
+	Trace(event TraceEvent)
+}
+
+// SetTracer traces parsing with tracer, nil to stop tracing
+func (parser *MKParser) SetTracer(tracer Tracer) {
+	parser.tracer = tracer
+}
+
+type traceState struct {
+	tracer Tracer
+	// maps the positions back to the source, nil if it's not normalized
+	m    *sourceMap
+	base int
+}
+
+// nil unless the parser has a tracer, m is nil if the source isn't normalized
+func (parser *MKParser) newTrace(m *sourceMap, base int) *traceState {
+	if parser.tracer == nil {
+		return nil
+	}
+	trace := &traceState{tracer: parser.tracer, base: base}
+	if m != nil && m.changed() {
+		trace.m = m
+	}
+	return trace
+}
+
+func (trace *traceState) run(kind uint32, name string, lookAhead rune, pos Pos, f func() *AstNode) *AstNode {
+	start := time.Now()
+	node := f()
+	elapsed := time.Since(start)
+	if trace.m != nil {
+		pos = trace.m.pos(pos, trace.base)
+	}
+	trace.tracer.Trace(TraceEvent{
+		Kind:      kind,
+		Name:      name,
+		LookAhead: lookAhead,
+		Start:     pos,
+		Matched:   node != nil,
+		Node:      node,
+		Elapsed:   elapsed,
+	})
+	return node
+}
+
+func _parserName(names []string, i int) string {
+	if i < len(names) {
+		return names[i]
+	}
+	return ""
+}
+
+// ParserStat sums up the events of a parser
+type ParserStat struct {
+	Kind    uint32
+	Name    string
+	Tried   int
+	Matched int
+	Elapsed time.Duration
+}
+
+// ParserStats is a Tracer counting the tries, matches and time of every parser,
+// it's safe for concurrent use
+type ParserStats struct {
+	lock  sync.Mutex
+	stats map[string]*ParserStat
+}
+
+func NewParserStats() *ParserStats {
+	return &ParserStats{stats: map[string]*ParserStat{}}
+}
+
+func (stats *ParserStats) Trace(event TraceEvent) {
+	key := fmt.Sprintf("%d:%s", event.Kind, event.Name)
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	stat, ok := stats.stats[key]
+	if !ok {
+		stat = &ParserStat{Kind: event.Kind, Name: event.Name}
+		stats.stats[key] = stat
+	}
+	stat.Tried += 1
+	if event.Matched {
+		stat.Matched += 1
+	}
+	stat.Elapsed += event.Elapsed
+}
+
+// Report returns the stats of the parsers by elapsed time, the slowest first
+func (stats *ParserStats) Report() []ParserStat {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	report := make([]ParserStat, 0, len(stats.stats))
+	for _, stat := range stats.stats {
+		report = append(report, *stat)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Elapsed != report[j].Elapsed {
+			return report[i].Elapsed > report[j].Elapsed
+		}
+		if report[i].Kind != report[j].Kind {
+			return report[i].Kind < report[j].Kind
+		}
+		return report[i].Name < report[j].Name
+	})
+	return report
+}
+
+func (stats *ParserStats) String() string {
+	lines := []string{fmt.Sprintf("%-8s %-20s %8s %8s %12s", "kind", "parser", "tried", "matched", "elapsed")}
+	for _, stat := range stats.Report() {
+		kind, name := "block", stat.Name
+		if stat.Kind == TraceInline {
+			kind = "inline"
+		}
+		if len(name) == 0 {
+			name = "(anonymous)"
+		}
+		lines = append(lines, fmt.Sprintf("%-8s %-20s %8d %8d %12s", kind, name, stat.Tried, stat.Matched, stat.Elapsed))
+	}
+	return strings.Join(lines, "\n")
+}
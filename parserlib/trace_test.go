@@ -0,0 +1,86 @@
+package parserlib
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type traceRecorder struct {
+	events []TraceEvent
+}
+
+func (recorder *traceRecorder) Trace(event TraceEvent) {
+	recorder.events = append(recorder.events, event)
+}
+
+func TestTracer(t *testing.T) {
+	parser := GetFullMKParser()
+	recorder := &traceRecorder{}
+	parser.SetTracer(recorder)
+	ast := parser.Parse("# a *b*\r\n\r\ntext `c`\r\n")
+
+	var header, code *TraceEvent
+	rejected := 0
+	for i, event := range recorder.events {
+		t.Logf("%d %s %q %s %v", event.Kind, event.Name, event.LookAhead, event.Start, event.Matched)
+		if event.Kind == TraceBlock && event.Start.Offset == 0 {
+			if event.Matched {
+				header = &recorder.events[i]
+			} else {
+				rejected += 1
+			}
+		}
+		if event.Kind == TraceInline && event.Name == "Code" {
+			code = &recorder.events[i]
+		}
+	}
+	// the first block parser matching is the last one tried
+	if assert.NotNil(t, header) {
+		assert.Equal(t, "Header", header.Name)
+		assert.Equal(t, ast.Root.Children[0], header.Node)
+	}
+	assert.Greater(t, rejected, 0)
+	// positions point into the source with "\r\n"
+	if assert.NotNil(t, code) {
+		assert.True(t, code.Matched)
+		assert.Equal(t, '`', code.LookAhead)
+		assert.Equal(t, Pos{Line: 2, Col: 5, Offset: 16}, code.Start)
+	}
+
+	stats := NewParserStats()
+	parser.SetTracer(stats)
+	parser.Parse("# a *b*\n\n# c\n\ntext\n")
+	report := stats.Report()
+	found := false
+	for _, stat := range report {
+		if stat.Kind == TraceBlock && stat.Name == "Header" {
+			found = true
+			assert.Equal(t, 2, stat.Matched)
+			assert.GreaterOrEqual(t, stat.Tried, 3)
+		}
+		assert.LessOrEqual(t, stat.Matched, stat.Tried)
+	}
+	assert.True(t, found)
+	assert.True(t, strings.Contains(stats.String(), "Header"))
+
+	// frozen parsers share the tracer
+	stats = NewParserStats()
+	parser.SetTracer(stats)
+	frozen := parser.Freeze()
+	parser.SetTracer(nil)
+	_, err := frozen.ParseMany(context.Background(), []string{"# a\n", "# b\n", "# c\n", "# d\n"}, 2)
+	assert.Nil(t, err)
+	for _, stat := range stats.Report() {
+		if stat.Kind == TraceBlock && stat.Name == "Header" {
+			assert.Equal(t, 4, stat.Matched)
+		}
+	}
+	recorder.events = nil
+	parser.SetTracer(recorder)
+	parser.SetTracer(nil)
+	parser.Parse("# a\n")
+	assert.Empty(t, recorder.events)
+}
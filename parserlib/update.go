@@ -0,0 +1,165 @@
+package parserlib
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+)
+
+// TextEdit replaces the bytes [Start, End) of the source with Text
+type TextEdit struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// Source returns the document the ast is parsed from
+func (ast *Ast) Source() string {
+	return ast.src
+}
+
+func (node *AstNode) shift(offset int, line int) {
+	node.PreVisit(func(node *AstNode) {
+		node.mapPos(func(pos Pos) Pos {
+			pos.Offset += offset
+			pos.Line += line
+			return pos
+		})
+	})
+}
+
+// Update applies edit to the source and reparses the top-level blocks around it.
+// Blocks before the edit are kept, blocks after it are kept with their positions shifted,
+// and the reparsed blocks are returned. The document is split into independently parsed
+// chunks the same way as ParseReader, and only the chunks from the last one before the
+// edit to the first one after it that starts in both sources are scanned again.
+// Post parsers run on the whole ast again.
+// The ast must be returned by Parse, and the edit must be within its source.
+// A source with a BOM or "\r" line endings is reparsed as a whole, and so is a source whose
+// abbreviation definitions are changed, since the kept blocks are wrapped with the old ones.
+func (ast *Ast) Update(edit TextEdit) ([]*AstNode, error) {
+	if ast.parser == nil {
+		return nil, fmt.Errorf("the ast isn't returned by Parse")
+	}
+	if edit.Start < 0 || edit.Start > edit.End || edit.End > len(ast.src) {
+		return nil, fmt.Errorf("edit [%d, %d) is out of the source of %d bytes", edit.Start, edit.End, len(ast.src))
+	}
+	oldSrc := ast.src
+	newSrc := oldSrc[:edit.Start] + edit.Text + oldSrc[edit.End:]
+	fReparse := func() []*AstNode {
+		parser := ast.parser
+		*ast = parser.parse(newSrc, nil, nil, false)
+		for _, node := range ast.Root.Children {
+			node.Parent = &ast.Root
+		}
+		return ast.Root.Children
+	}
+	if ast.chunks == nil || _needsNormalization(newSrc) {
+		return fReparse(), nil
+	}
+	delta := len(newSrc) - len(oldSrc)
+	lineDelta := strings.Count(edit.Text, "\n") - strings.Count(oldSrc[edit.Start:edit.End], "\n")
+
+	// the last chunk starting before the edit starts in both sources unless its first line is
+	// edited, then the one before it does
+	oldChunks := ast.chunks
+	first := sort.SearchInts(oldChunks, edit.Start+1) - 1
+	for first > 0 && (oldChunks[first] == len(oldSrc) ||
+		(_lineEnd(oldSrc, oldChunks[first])+1 > edit.Start && !_isChunkStart(newSrc, oldChunks[first]))) {
+		first -= 1
+	}
+	start := oldChunks[first]
+	// reparse up to the first chunk after the edit that starts in both sources
+	newEnd := len(newSrc)
+	var chunks []int
+	_scanChunks(newSrc, start, func(offset int) bool {
+		if offset >= edit.Start+len(edit.Text) && offset-delta >= edit.End {
+			i := sort.SearchInts(oldChunks, offset-delta)
+			if i < len(oldChunks) && oldChunks[i] == offset-delta {
+				newEnd = offset
+				return false
+			}
+		}
+		chunks = append(chunks, offset)
+		return true
+	})
+	oldEnd := newEnd - delta
+
+	var before, removed, after []*AstNode
+	for _, node := range ast.Root.Children {
+		if node.End.Offset <= start {
+			before = append(before, node)
+		} else if node.Start.Offset >= oldEnd {
+			after = append(after, node)
+		} else {
+			removed = append(removed, node)
+		}
+	}
+
+	// only blank lines are between the kept blocks and start
+	startPos, prevEnd := Pos{}, 0
+	if len(before) > 0 {
+		startPos = before[len(before)-1].End
+		prevEnd = startPos.Offset
+	}
+	startPos.ConsumeStr(newSrc[prevEnd:start])
+	ctx := ParseContext{
+		P:           startPos,
+		Parent:      &ast.Root,
+		LeftSibling: nil,
+		ParseText:   ast.parser.parseText,
+		ParseBlocks: ast.parser.parseBlocks,
+		trace:       ast.parser.newTrace(nil, 0),
+	}
+	if len(before) > 0 {
+		ctx.LeftSibling = before[len(before)-1]
+	}
+	changed := ast.parser.parseBlocks(newSrc[start:newEnd], ctx)
+	if !maps.Equal(_abbreviationDefinitions(removed), _abbreviationDefinitions(changed)) {
+		return fReparse(), nil
+	}
+
+	for i, node := range after {
+		node.shift(delta, lineDelta)
+		if i == 0 {
+			node.LeftSibling = ctx.LeftSibling
+			if len(changed) > 0 {
+				node.LeftSibling = changed[len(changed)-1]
+			}
+		}
+	}
+	children := make([]*AstNode, 0, len(before)+len(changed)+len(after))
+	children = append(children, before...)
+	children = append(children, changed...)
+	children = append(children, after...)
+	for _, node := range children {
+		node.Parent = &ast.Root
+	}
+	ast.Root.Children = children
+	if newEnd < len(newSrc) {
+		// the last line is untouched
+		ast.Root.End.Offset += delta
+		ast.Root.End.Line += lineDelta
+	} else {
+		ast.Root.End = startPos
+		ast.Root.End.ConsumeStr(newSrc[start:])
+	}
+	ast.src = newSrc
+
+	// the chunks before start and after the reparsed ones are kept
+	newChunks := append([]int{}, oldChunks[:first+1]...)
+	newChunks = append(newChunks, chunks...)
+	for _, offset := range oldChunks[sort.SearchInts(oldChunks, oldEnd):] {
+		if offset+delta > start {
+			newChunks = append(newChunks, offset+delta)
+		}
+	}
+	ast.chunks = newChunks
+
+	for _, postParser := range ast.parser.PostParserSeq {
+		postParser(ast, newSrc)
+	}
+	ast.setSource()
+	return changed, nil
+}
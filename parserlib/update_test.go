@@ -0,0 +1,134 @@
+package parserlib
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdate(t *testing.T) {
+	mk := "# Title\n" +
+		"\n" +
+		"first paragraph\n" +
+		"\n" +
+		"second *paragraph*\n" +
+		"\n" +
+		"- item\n" +
+		"- item\n" +
+		"\n" +
+		"last"
+	parser := GetFullMKParser()
+	ast := parser.Parse(mk)
+
+	// edit inside the second paragraph
+	offset := strings.Index(mk, "second") + len("second")
+	changed, err := ast.Update(TextEdit{Start: offset, End: offset, Text: " `code`"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(changed))
+	assert.Equal(t, "second `code` *paragraph*\n", changed[0].Text())
+	expected := parser.Parse(ast.Source())
+	assert.Equal(t, expected.String(), ast.String())
+	assert.Equal(t, expected.Root.End, ast.Root.End)
+	assert.Nil(t, ast.Validate())
+
+	// an unclosed fence is text until it's closed
+	offset = strings.Index(ast.Source(), "first")
+	changed, err = ast.Update(TextEdit{Start: offset, End: offset, Text: "```\n"})
+	assert.Nil(t, err)
+	expected = parser.Parse(ast.Source())
+	assert.Equal(t, expected.String(), ast.String())
+	assert.Equal(t, "Text", changed[0].Type.String())
+	changed, err = ast.Update(TextEdit{Start: len(ast.Source()) - len("last"), End: len(ast.Source()), Text: "```"})
+	assert.Nil(t, err)
+	expected = parser.Parse(ast.Source())
+	assert.Equal(t, expected.String(), ast.String())
+	assert.Nil(t, ast.Validate())
+	assert.Equal(t, 2, len(ast.Root.Children))
+	_, ok := changed[0].Type.(*CodeBlock)
+	assert.Equal(t, true, ok)
+}
+
+func TestUpdateRandom(t *testing.T) {
+	pieces := []string{"# h\n", "\n", "text ", "*a*", "`c`", "```\n", "$$\n", "- i\n", "| a | b |\n", "| - | - |\n", "    ", "[^1]: n\n", "Table: t\n", "x\n", "HTML ", "*[HTML]: Hyper\n"}
+	rnd := rand.New(rand.NewSource(42))
+	parser := GetFullMKParser()
+	ast := parser.Parse("")
+	for i := 0; i < 500; i++ {
+		src := ast.Source()
+		start := rnd.Intn(len(src) + 1)
+		end := start + rnd.Intn(len(src)-start+1)
+		if rnd.Intn(2) == 0 {
+			end = start
+		}
+		edit := TextEdit{Start: start, End: end, Text: pieces[rnd.Intn(len(pieces))]}
+		_, err := ast.Update(edit)
+		assert.Nil(t, err)
+		expected := parser.Parse(ast.Source())
+		if !assert.Equal(t, expected.String(), ast.String(), "%q %v", src, edit) {
+			break
+		}
+		assert.Equal(t, expected.Root.End, ast.Root.End)
+		assert.Equal(t, expected.chunks, ast.chunks)
+		assert.Nil(t, ast.Validate())
+	}
+}
+
+func TestUpdateAbbreviation(t *testing.T) {
+	parser := GetFullMKParser()
+	mk := "HTML here\n\n*[HTML]: Hyper\n"
+	ast := parser.Parse(mk)
+	assert.Contains(t, ast.String(), "Abbreviation(")
+
+	// the wrapped paragraph is kept but its abbreviations are gone
+	changed, err := ast.Update(TextEdit{Start: len("HTML here\n\n"), End: len(mk), Text: ""})
+	assert.Nil(t, err)
+	expected := parser.Parse(ast.Source())
+	assert.Equal(t, expected.String(), ast.String())
+	assert.NotContains(t, ast.String(), "Abbreviation(")
+	assert.Same(t, ast.Root.Children[0], changed[0])
+	assert.Nil(t, ast.Validate())
+
+	changed, err = ast.Update(TextEdit{Start: len(ast.Source()), End: len(ast.Source()), Text: "\n*[here]: There\n"})
+	assert.Nil(t, err)
+	expected = parser.Parse(ast.Source())
+	assert.Equal(t, expected.String(), ast.String())
+	assert.Equal(t, 2, len(changed))
+
+	// the edit ends in the middle of the last line
+	ast = parser.Parse("text")
+	_, err = ast.Update(TextEdit{Start: 4, End: 4, Text: ""})
+	assert.Nil(t, err)
+	assert.Equal(t, Pos{Line: 0, Col: 4, Offset: 4}, ast.Root.End)
+	assert.Nil(t, ast.Validate())
+}
+
+func TestUpdateWindow(t *testing.T) {
+	parser := GetFullMKParser()
+	mk := strings.Repeat("paragraph\n\n", 1000)
+	ast := parser.Parse(mk)
+	blocks := append([]*AstNode{}, ast.Root.Children...)
+
+	// the blocks around the edited one are kept as they are
+	offset := 500*len("paragraph\n\n") + len("para")
+	changed, err := ast.Update(TextEdit{Start: offset, End: offset, Text: "\n\n# h\n\n"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(changed))
+	assert.Same(t, blocks[499], ast.Root.Children[499])
+	assert.Same(t, blocks[501], ast.Root.Children[503])
+	assert.Equal(t, Pos{Line: 1000, Col: 0, Offset: offset - len("para")}, changed[0].Start)
+	expected := parser.Parse(ast.Source())
+	assert.Equal(t, expected.String(), ast.String())
+	assert.Equal(t, expected.chunks, ast.chunks)
+
+	_, err = ast.Update(TextEdit{Start: 5, End: 4})
+	assert.NotNil(t, err)
+	_, err = ast.Update(TextEdit{Start: 0, End: len(ast.Source()) + 1})
+	assert.NotNil(t, err)
+	c, err := ast.Compact()
+	assert.Nil(t, err)
+	compact := c.ToAst()
+	_, err = compact.Update(TextEdit{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,152 @@
+package parserlib
+
+import (
+	"fmt"
+)
+
+type astValidator struct {
+	ast *Ast
+	// nil if the ast has no source to check against
+	index *LineIndex
+}
+
+func (v *astValidator) fail(node *AstNode, format string, args ...interface{}) error {
+	name := "nil"
+	if node.Type != nil {
+		name = node.Type.String()
+	}
+	return &Diagnostic{
+		Severity: SeverityError,
+		Message:  name + ": " + fmt.Sprintf(format, args...),
+		Start:    node.Start,
+		End:      node.End,
+	}
+}
+
+// whether pos is at a rune boundary of the source and its line and column match its offset
+func (v *astValidator) checkPos(pos Pos) bool {
+	if pos.Offset < 0 || pos.Offset > len(v.ast.src) {
+		return false
+	}
+	if v.index.Pos(pos.Offset) != pos {
+		return false
+	}
+	// an offset inside a rune or a line ending doesn't map back to itself,
+	// the root starts before the BOM
+	return pos.Offset == 0 || v.index.Offset(pos.Line, pos.Col) == pos.Offset
+}
+
+func (v *astValidator) checkRange(node *AstNode, r Range) error {
+	if r.Start.Offset > r.End.Offset || r.Start.Offset < node.Start.Offset || r.End.Offset > node.End.Offset {
+		return v.fail(node, "range %s-%s out of the node", r.Start, r.End)
+	}
+	if v.index != nil && (!v.checkPos(r.Start) || !v.checkPos(r.End)) {
+		return v.fail(node, "range %s-%s doesn't match the source", r.Start, r.End)
+	}
+	return nil
+}
+
+func (v *astValidator) check(node *AstNode) error {
+	if node.Type == nil {
+		return v.fail(node, "node without type")
+	}
+	if node.Start.Offset > node.End.Offset {
+		return v.fail(node, "ends before it starts")
+	}
+	if v.index != nil && (!v.checkPos(node.Start) || !v.checkPos(node.End)) {
+		return v.fail(node, "range doesn't match the source")
+	}
+	if ranged, ok := node.Type.(rangedType); ok {
+		for _, r := range ranged.ranges() {
+			if err := v.checkRange(node, *r); err != nil {
+				return err
+			}
+		}
+	}
+	for _, syntax := range node.Syntax {
+		if syntax.Kind != TokenMarker && syntax.Kind != TokenTrivia {
+			return v.fail(node, "syntax of kind %d", syntax.Kind)
+		}
+		if err := v.checkRange(node, syntax.Range); err != nil {
+			return err
+		}
+	}
+	var last *AstNode
+	for _, ch := range node.Children {
+		if ch == nil {
+			return v.fail(node, "nil child")
+		}
+		if ch.Parent != node && (node != &v.ast.Root || !_isCopiedRoot(ch.Parent)) {
+			return v.fail(ch, "parent isn't %s", node.Type)
+		}
+		if ch.LeftSibling != last {
+			return v.fail(ch, "left sibling isn't the previous child")
+		}
+		if ch.Start.Offset < node.Start.Offset || ch.End.Offset > node.End.Offset {
+			return v.fail(ch, "out of its parent %s%s-%s", node.Type, node.Start, node.End)
+		}
+		if last != nil && ch.Start.Offset < last.End.Offset {
+			return v.fail(ch, "overlaps its left sibling %s%s-%s", last.Type, last.Start, last.End)
+		}
+		if err := v.check(ch); err != nil {
+			return err
+		}
+		last = ch
+	}
+	return nil
+}
+
+// Parse returns the ast by value, the parent of top-level nodes is the root before the copy
+func _isCopiedRoot(node *AstNode) bool {
+	if node == nil || node.Parent != nil {
+		return false
+	}
+	_, ok := node.Type.(*Document)
+	return ok
+}
+
+// Validate checks the structural invariants of the ast: the Parent, LeftSibling and Children
+// links are consistent, children are ordered within their parent without overlapping,
+// the ranges held by node types are within their nodes,
+// and if the ast has its source, every position matches the source and the document
+// covers the whole source with only whitespaces and the BOM left out of top-level blocks.
+// The first violation is returned as a *Diagnostic.
+func (ast *Ast) Validate() error {
+	v := &astValidator{ast: ast}
+	if _, ok := ast.Root.Type.(*Document); !ok {
+		return v.fail(&ast.Root, "root isn't a document")
+	}
+	if ast.Root.Start != (Pos{}) {
+		return v.fail(&ast.Root, "root doesn't start at the beginning")
+	}
+	if ast.parser != nil {
+		v.index = NewLineIndex(ast.src)
+		if ast.Root.End.Offset != len(ast.src) {
+			return v.fail(&ast.Root, "doesn't cover the source of %d bytes", len(ast.src))
+		}
+	}
+	if err := v.check(&ast.Root); err != nil {
+		return err
+	}
+	if v.index != nil {
+		covered := v.index.bom
+		fGap := func(end int) error {
+			for i := covered; i < end; i++ {
+				if c := ast.src[i]; c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+					return v.fail(&ast.Root, "%q at %s isn't in any block", c, v.index.Pos(i))
+				}
+			}
+			return nil
+		}
+		for _, ch := range ast.Root.Children {
+			if err := fGap(ch.Start.Offset); err != nil {
+				return err
+			}
+			covered = ch.End.Offset
+		}
+		if err := fGap(len(ast.src)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
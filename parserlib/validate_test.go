@@ -0,0 +1,96 @@
+package parserlib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	content, err := os.ReadFile("../tests/largemk.md")
+	assert.Equal(t, nil, err)
+	parser := GetFullMKParser()
+	ast := parser.Parse(string(content))
+	assert.Equal(t, nil, ast.Validate())
+
+	s := "# title\n\ntext *a*\n"
+	fBroken := func(f func(ast *Ast)) error {
+		ast := parser.Parse(s)
+		assert.Equal(t, nil, ast.Validate())
+		f(&ast)
+		err := ast.Validate()
+		t.Log(err)
+		return err
+	}
+	assert.NotNil(t, fBroken(func(ast *Ast) { ast.Root.Children[1].LeftSibling = nil }))
+	assert.NotNil(t, fBroken(func(ast *Ast) { ast.Root.Children[1].Children[1].Parent = ast.Root.Children[0] }))
+	assert.NotNil(t, fBroken(func(ast *Ast) { ast.Root.Children[1].Children[1].End.Offset += 1 }))
+	assert.NotNil(t, fBroken(func(ast *Ast) { ast.Root.Children[1].Children[1].Start.Col += 1 }))
+	assert.NotNil(t, fBroken(func(ast *Ast) { ast.Root.Children[1].Children[0].End = ast.Root.Children[1].Children[1].End }))
+	assert.NotNil(t, fBroken(func(ast *Ast) { ast.Root.Children = ast.Root.Children[1:]; ast.Root.Children[0].LeftSibling = nil }))
+	assert.NotNil(t, fBroken(func(ast *Ast) { ast.Root.End.Offset -= 1 }))
+	assert.NotNil(t, fBroken(func(ast *Ast) { ast.Root.Children[0].Syntax[0].End.Offset += 1 }))
+	assert.NotNil(t, fBroken(func(ast *Ast) {
+		ast.Root.Children[0].Syntax[0].Range = ast.Root.Children[1].Children[1].Syntax[0].Range
+	}))
+}
+
+// kinds and ranges of all the tokens of the tree
+func _cstKinds(node *CstNode) string {
+	var builder strings.Builder
+	for _, token := range node.AllTokens() {
+		builder.WriteString(fmt.Sprintf("%d%s%s\n", token.Kind, token.Start, token.End))
+	}
+	return builder.String()
+}
+
+func FuzzParse(f *testing.F) {
+	content, err := os.ReadFile("../tests/largemk.md")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, block := range strings.Split(string(content), "\n\n") {
+		f.Add(block)
+	}
+	f.Add("")
+	f.Add("[a][b] [^c] ^[d] [@e] ![f](g){#fig:h} <i> <http://j.k> `l` $m$ *n* **o** ~~p~~\n")
+	f.Add("- [x] a\n- [ ] b\n\n1. c\n2. d\n\n> e\n>> f\n\n***\n")
+	f.Add("| a | b |\n|:-:|--:|\n| c | d |\nTable: caption\n")
+	f.Add("[^a]: x\n\n\t:[^")
+	f.Add("^[\n][a]")
+	f.Add("```go\ncode\n```\n\n$$\nx\n$$ {#eq:a}\n\n[a]: /url \"t\"\n[^b]: note\n    more\n*[HTML]: markup\n")
+	f.Add("\uFEFF# a\r\n```\r\nb\r\n```\r\n- c\r\t- d\r\n\r\n| e |\r\n| - |\r\n")
+
+	parsers := []MKParser{GetProfileParser(ProfileClassic), GetProfileParser(ProfileCommonMark), GetProfileParser(ProfileGFM)}
+	f.Fuzz(func(t *testing.T, s string) {
+		for i := range parsers {
+			ast := parsers[i].Parse(s)
+			if err := ast.Validate(); err != nil {
+				t.Fatalf("profile %d: %v\n%s", i, err, ast.String())
+			}
+			lazy := parsers[i].ParseLazy(s)
+			if want, got := _inlineShape(&ast.Root), _inlineShape(&lazy.Root); want != got {
+				t.Fatalf("profile %d: lazy inlines differ\n%s\n%s", i, want, got)
+			}
+			cst := ast.CST()
+			if source := cst.Source(); source != s {
+				t.Fatalf("profile %d: cst reproduces %q", i, source)
+			}
+			if want, got := _cstKinds(cst), _cstKinds(lazy.CST()); want != got {
+				t.Fatalf("profile %d: lazy tokens differ\n%s\n%s", i, want, got)
+			}
+			if err := lazy.Validate(); err != nil {
+				t.Fatalf("profile %d: lazy: %v\n%s", i, err, lazy.String())
+			}
+			lazy.Root.preVisitInlines(func(node *AstNode) {
+				if node.Text() != s[node.Start.Offset:node.End.Offset] {
+					t.Fatalf("profile %d: text of %s%s", i, node.Type, node.Start)
+				}
+			})
+			_ = lazy.Root.PlainText()
+		}
+	})
+}
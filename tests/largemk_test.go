@@ -14,5 +14,6 @@ func TestDontPanic(t *testing.T) {
 	s := string(res)
 
 	parser := parserlib.GetFullMKParser()
-	parser.Parse(s)
+	ast := parser.Parse(s)
+	assert.Equal(t, nil, ast.Validate())
 }
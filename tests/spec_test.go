@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/XiaoXuan42/xxmk/parserlib"
+)
+
+// go test ./tests -run TestSpec -spec.update rewrites the known failures
+var specUpdate = flag.Bool("spec.update", false, "rewrite the known failures of the spec examples")
+
+// go test ./tests -run TestSpec -spec.fetch downloads the spec files of specSources again
+var specFetch = flag.Bool("spec.fetch", false, "download the spec files of CommonMark and GFM")
+
+// a spec file in a module zip of the Go module proxy, which is pinned and checksummed
+type specSource struct {
+	url  string
+	file string
+}
+
+// the committed spec files and the versions their known failures are recorded against:
+// the examples of CommonMark 0.31.2 dumped to json, as vendored by goldmark, and GFM 0.29
+var specSources = map[string]specSource{
+	"./spec/commonmark.json": {
+		url:  "https://proxy.golang.org/github.com/yuin/goldmark/@v/v1.8.6.zip",
+		file: "github.com/yuin/goldmark@v1.8.6/_test/spec.json",
+	},
+	"./spec/gfm.txt": {
+		url:  "https://proxy.golang.org/github.com/github/cmark-gfm/@v/v0.0.0-20260713143608-499789b49373.zip",
+		file: "github.com/github/cmark-gfm@v0.0.0-20260713143608-499789b49373/test/spec.txt",
+	},
+}
+
+func fetchSpec(path string) error {
+	source := specSources[path]
+	resp, err := http.Get(source.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", source.url, resp.Status)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return err
+	}
+	file, err := archive.Open(source.file)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	content, err = io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+const specFence = "````````````````````````````````"
+
+type specExample struct {
+	Number   int
+	Section  string
+	Markdown string
+	HTML     string
+}
+
+// examples in the format of the CommonMark spec, or dumped to json by its spec_tests.py
+func loadSpec(path string) ([]specExample, error) {
+	if filepath.Ext(path) == ".json" {
+		return loadSpecJson(path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var examples []specExample
+	var builder strings.Builder
+	section := ""
+	state := 0 // 0: out of an example, 1: markdown, 2: html
+	cur := specExample{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.ReplaceAll(scanner.Text(), "→", "\t")
+		switch {
+		case state == 0 && strings.HasPrefix(line, specFence+" example"):
+			state = 1
+			cur = specExample{Number: len(examples) + 1, Section: section}
+			builder.Reset()
+		case state == 0 && strings.HasPrefix(line, "#"):
+			section = strings.TrimSpace(strings.TrimLeft(line, "#"))
+		case state == 1 && line == ".":
+			state = 2
+			cur.Markdown = builder.String()
+			builder.Reset()
+		case state == 2 && line == specFence:
+			state = 0
+			cur.HTML = builder.String()
+			examples = append(examples, cur)
+		case state != 0:
+			builder.WriteString(line + "\n")
+		}
+	}
+	return examples, scanner.Err()
+}
+
+func loadSpecJson(path string) ([]specExample, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var dumped []struct {
+		Markdown string `json:"markdown"`
+		HTML     string `json:"html"`
+		Example  int    `json:"example"`
+		Section  string `json:"section"`
+	}
+	if err := json.Unmarshal(content, &dumped); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	examples := make([]specExample, 0, len(dumped))
+	for _, example := range dumped {
+		examples = append(examples, specExample{
+			Number:   example.Example,
+			Section:  example.Section,
+			Markdown: example.Markdown,
+			HTML:     example.HTML,
+		})
+	}
+	return examples, nil
+}
+
+// example numbers, one per line
+func loadKnownFailures(path string) (map[int]bool, error) {
+	failures := map[int]bool{}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		number, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		failures[number] = true
+	}
+	return failures, nil
+}
+
+func runSpec(t *testing.T, path string, profile uint32) {
+	if _, ok := specSources[path]; ok && *specFetch {
+		if err := fetchSpec(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	examples, err := loadSpec(path)
+	if os.IsNotExist(err) {
+		t.Fatalf("%s is not found, download it with -spec.fetch", path)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	failuresPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".failures"
+	known, err := loadKnownFailures(failuresPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mkParser := parserlib.GetProfileParser(profile)
+	parser := mkParser.Freeze()
+	var sections []string
+	passed := map[string]int{}
+	total := map[string]int{}
+	var failed []int
+	for _, example := range examples {
+		if _, ok := total[example.Section]; !ok {
+			sections = append(sections, example.Section)
+		}
+		total[example.Section] += 1
+		html, ok := func() (html string, ok bool) {
+			defer func() {
+				if r := recover(); r != nil {
+					html, ok = fmt.Sprintf("panic: %v\n", r), false
+				}
+			}()
+			ast := parser.Parse(example.Markdown)
+			html = parserlib.RenderHTML(&ast)
+			return html, html == example.HTML
+		}()
+		if ok {
+			passed[example.Section] += 1
+			if known != nil && known[example.Number] {
+				t.Errorf("example %d (%s) passes but is a known failure", example.Number, example.Section)
+			}
+			continue
+		}
+		failed = append(failed, example.Number)
+		if known != nil && !known[example.Number] && !*specUpdate {
+			t.Errorf("example %d (%s) fails\nmarkdown:\n%s\nexpected:\n%s\ngot:\n%s",
+				example.Number, example.Section, example.Markdown, example.HTML, html)
+		}
+	}
+
+	for _, section := range sections {
+		t.Logf("%-50s %4d/%4d", section, passed[section], total[section])
+	}
+	t.Logf("%s: %d/%d examples passed", filepath.Base(path), len(examples)-len(failed), len(examples))
+
+	if *specUpdate {
+		sort.Ints(failed)
+		var builder strings.Builder
+		for _, number := range failed {
+			builder.WriteString(strconv.Itoa(number) + "\n")
+		}
+		if err := os.WriteFile(failuresPath, []byte(builder.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	} else if known == nil && len(failed) > 0 {
+		t.Logf("%s is not found, failed examples: %v", failuresPath, failed)
+	}
+}
+
+// The spec files of CommonMark and GFM are committed with their known failures,
+// run with -spec.fetch -spec.update to move to the versions of specSources.
+func TestSpecCommonMark(t *testing.T) {
+	runSpec(t, "./spec/commonmark.json", parserlib.ProfileCommonMark)
+}
+
+func TestSpecGFM(t *testing.T) {
+	runSpec(t, "./spec/gfm.txt", parserlib.ProfileGFM)
+}
+
+func TestSpecSample(t *testing.T) {
+	runSpec(t, "./spec/xxmk.txt", parserlib.ProfileClassic)
+}
@@ -1,6 +1,7 @@
 package xxmkproto
 
 import (
+	"log"
 	"reflect"
 
 	"github.com/XiaoXuan42/xxmk/naivesel"
@@ -8,19 +9,31 @@ import (
 )
 
 func _setWhich(s string, buf *AstNodeTypeProto) {
-	buf.Which = AstNodeTypeEnumProto(AstNodeTypeEnumProto_value[s])
+	which, ok := AstNodeTypeEnumProto_value[s]
+	if ok && AstNodeTypeEnumProto(which) != AstNodeTypeEnumProto_Extension {
+		buf.Which = AstNodeTypeEnumProto(which)
+	} else {
+		buf.Which = AstNodeTypeEnumProto_Extension
+		buf.Name = s
+	}
 }
 
 func _getAstNodeType(buf *AstNodeTypeProto) parserlib.AstNodeType {
 	str := AstNodeTypeEnumProto_name[int32(buf.Which)]
-	intf := reflect.New(reflect.TypeOf(parserlib.GetDefaultTypePtr(str)).Elem()).Interface()
+	if buf.Which == AstNodeTypeEnumProto_Extension {
+		str = buf.Name
+	}
+	prototype := parserlib.GetDefaultTypePtr(str)
+	if prototype == nil {
+		log.Panicf("node type %s is not registered", str)
+	}
+	intf := reflect.New(reflect.TypeOf(prototype).Elem()).Interface()
 	return intf.(parserlib.AstNodeType)
 }
 
 func _astNodeTypeToProtobuf(tp parserlib.AstNodeType) *AstNodeTypeProto {
 	typeProto := &AstNodeTypeProto{}
-	tpName := reflect.TypeOf(tp).Elem().Name()
-	_setWhich(tpName, typeProto)
+	_setWhich(parserlib.GetNodeTypeName(tp), typeProto)
 	typeProto.Encode = append(typeProto.Encode, naivesel.Serialize(tp)...)
 	return typeProto
 }
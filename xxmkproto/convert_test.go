@@ -70,3 +70,22 @@ hello world!
 	AstFromProtobuf(ast2, astProto2)
 	assert.Equal(t, true, ast.Eq(ast2))
 }
+
+type callout struct {
+	Kind string
+}
+
+func (c callout) String() string {
+	return "Callout(" + c.Kind + ")"
+}
+
+func TestExtensionNodeType(t *testing.T) {
+	err := parserlib.RegisterNodeType("Callout", parserlib.FirstExtensionNodeTypeId, &callout{})
+	assert.Equal(t, nil, err)
+
+	tp := &callout{Kind: "warning"}
+	buf := _astNodeTypeToProtobuf(tp)
+	assert.Equal(t, AstNodeTypeEnumProto_Extension, buf.Which)
+	assert.Equal(t, "Callout", buf.Name)
+	assert.Equal(t, tp, _astNodeTypeFromProtobuf(buf))
+}